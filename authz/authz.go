@@ -0,0 +1,220 @@
+package authz
+
+import (
+	"errors"
+
+	"github.com/princeparmar/contact_manager/permission"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Decision is the outcome of checking one access for a user. When the access is not allowed,
+// UnmetConditions lists the conditions that kept a matching conditional grant from applying.
+type Decision struct {
+	Access          string   `json:"access"`
+	Allowed         bool     `json:"allowed"`
+	MatchedBy       string   `json:"matched_by,omitempty"`
+	DeniedBy        string   `json:"denied_by,omitempty"`
+	UnmetConditions []string `json:"unmet_conditions,omitempty"`
+}
+
+// Authorizer answers permission checks from live role assignments, so that revocations take
+// effect immediately instead of when the user's token expires.
+type Authorizer struct {
+	UserRoleRepo   repositories.UserRoleRepository
+	RoleRepo       repositories.RoleRepository
+	RoleParentRepo repositories.RoleParentRepository
+	RoleAccessRepo repositories.RoleAccessRepository
+	AccessRepo     repositories.AccessRepository
+	DenyRepo       repositories.AccessDenyRepository
+	GroupRepo      repositories.GroupRepository
+	BindingRepo    repositories.RoleBindingRepository
+	AttributeRepo  repositories.UserAttributeRepository
+}
+
+// NewAuthorizer returns a new instance of Authorizer.
+func NewAuthorizer(userRoleRepo repositories.UserRoleRepository, roleRepo repositories.RoleRepository, roleParentRepo repositories.RoleParentRepository, roleAccessRepo repositories.RoleAccessRepository, accessRepo repositories.AccessRepository, denyRepo repositories.AccessDenyRepository, groupRepo repositories.GroupRepository, bindingRepo repositories.RoleBindingRepository, attributeRepo repositories.UserAttributeRepository) *Authorizer {
+	return &Authorizer{
+		UserRoleRepo:   userRoleRepo,
+		RoleRepo:       roleRepo,
+		RoleParentRepo: roleParentRepo,
+		RoleAccessRepo: roleAccessRepo,
+		AccessRepo:     accessRepo,
+		DenyRepo:       denyRepo,
+		GroupRepo:      groupRepo,
+		BindingRepo:    bindingRepo,
+		AttributeRepo:  attributeRepo,
+	}
+}
+
+// ForOrg returns a copy of the Authorizer that decides for users acting in an organization. It only
+// considers the roles, groups, assignments, bindings and denies of the organization and global ones.
+func (a *Authorizer) ForOrg(orgID int) *Authorizer {
+	return &Authorizer{
+		UserRoleRepo:   a.UserRoleRepo.ForOrg(orgID),
+		RoleRepo:       *a.RoleRepo.ForOrg(orgID),
+		RoleParentRepo: a.RoleParentRepo,
+		RoleAccessRepo: a.RoleAccessRepo,
+		AccessRepo:     a.AccessRepo,
+		DenyRepo:       *a.DenyRepo.ForOrg(orgID),
+		GroupRepo:      *a.GroupRepo.ForOrg(orgID),
+		BindingRepo:    *a.BindingRepo.ForOrg(orgID),
+		AttributeRepo:  a.AttributeRepo,
+	}
+}
+
+// Grants returns the permission set currently granted to a user, including the denies that apply to the user.
+// With a resource, the set also includes the roles bound to the user on the resource or its ancestors.
+// Conditional grants are included when their conditions are satisfied by the request attributes, which
+// may be nil, and the stored attributes of the user.
+func (a *Authorizer) Grants(userID int, resource *repositories.ResourceRef, request map[string]interface{}) (*permission.Set, error) {
+	set, _, err := a.grants(userID, resource, request)
+	return set, err
+}
+
+// grants returns the permission set of Grants and the conditional grants left out of it.
+func (a *Authorizer) grants(userID int, resource *repositories.ResourceRef, request map[string]interface{}) (*permission.Set, []*conditionalGrant, error) {
+	accesses, err := a.UserRoleRepo.GetAllAccess(userID)
+	if err != nil && !errors.Is(err, repositories.ErrNoAccess) {
+		return nil, nil, err
+	}
+
+	denies, err := a.DenyRepo.GetEffectiveDenies(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extra, err := a.evaluateExtra(userID, resource, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	accesses = append(accesses, extra.accesses...)
+	denies = append(denies, extra.denies...)
+
+	names := make([]string, 0, len(accesses))
+	for _, access := range accesses {
+		names = append(names, access.Name)
+	}
+
+	set := permission.NewSet(names)
+	set.Deny(denyNames(denies))
+
+	return set, extra.unmet, nil
+}
+
+// EffectiveAccesses returns the accesses granted to a user with deny-overrides applied: grants a deny
+// matches entirely are dropped, and the denies that carve exceptions out of a remaining grant are
+// returned alongside.
+func (a *Authorizer) EffectiveAccesses(userID int) ([]*repositories.Access, []string, error) {
+	accesses, err := a.UserRoleRepo.GetAllAccess(userID)
+	if err != nil && !errors.Is(err, repositories.ErrNoAccess) {
+		return nil, nil, err
+	}
+
+	denies, err := a.DenyRepo.GetEffectiveDenies(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	granted, exceptions := applyDenies(accesses, denyNames(denies))
+	return granted, exceptions, nil
+}
+
+// applyDenies drops the accesses a deny matches entirely and returns the remaining accesses with every
+// deny intersecting one of them. A deny intersects a grant when some permission is matched by both, as
+// "contacts:entry:*" does with "contacts:*:read"; such a deny is an exception consumers of the grant
+// must honour.
+func applyDenies(accesses []*repositories.Access, denyNames []string) ([]*repositories.Access, []string) {
+	denied := []permission.Permission{}
+	for _, name := range denyNames {
+		denied = append(denied, permission.FromLegacyName(name))
+	}
+
+	granted := []*repositories.Access{}
+	grants := []permission.Permission{}
+	for _, access := range accesses {
+		grant := permission.FromLegacyName(access.Name)
+		if !matchedByAny(denied, grant) {
+			granted = append(granted, access)
+			grants = append(grants, grant)
+		}
+	}
+
+	// Only the denies that overlap a remaining grant matter to token consumers
+	exceptions := []string{}
+	for _, deny := range denied {
+		for _, grant := range grants {
+			if deny.Intersects(grant) {
+				exceptions = append(exceptions, deny.String())
+				break
+			}
+		}
+	}
+
+	return granted, exceptions
+}
+
+// matchedByAny reports whether any deny matches the whole of grant.
+func matchedByAny(denies []permission.Permission, grant permission.Permission) bool {
+	for _, deny := range denies {
+		if deny.Matches(grant) {
+			return true
+		}
+	}
+	return false
+}
+
+// denyNames returns the distinct access names of denies.
+func denyNames(denies []*repositories.AccessDeny) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, deny := range denies {
+		if !seen[deny.AccessName] {
+			seen[deny.AccessName] = true
+			names = append(names, deny.AccessName)
+		}
+	}
+	return names
+}
+
+// Check decides each of the requested accesses for a user, on a resource when one is given, evaluating
+// conditional grants against the request attributes.
+func (a *Authorizer) Check(userID int, resource *repositories.ResourceRef, request map[string]interface{}, accesses []string) ([]*Decision, error) {
+	grants, unmet, err := a.grants(userID, resource, request)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]*Decision, 0, len(accesses))
+	for _, access := range accesses {
+		decision := &Decision{Access: access}
+		if deny, ok := grants.DeniedBy(access); ok {
+			decision.DeniedBy = deny.String()
+		} else if grant, ok := grants.Match(access); ok {
+			decision.Allowed = true
+			decision.MatchedBy = grant.String()
+		} else {
+			decision.UnmetConditions = unmetConditions(unmet, requiredPermission(access))
+		}
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
+
+// unmetConditions returns the distinct unmet conditions of the grants matching required.
+func unmetConditions(grants []*conditionalGrant, required permission.Permission) []string {
+	seen := map[string]bool{}
+	conditions := []string{}
+	for _, grant := range grants {
+		if !permission.FromLegacyName(grant.access.Name).Matches(required) {
+			continue
+		}
+		for _, c := range grant.conditions {
+			if !seen[c] {
+				seen[c] = true
+				conditions = append(conditions, c)
+			}
+		}
+	}
+	return conditions
+}
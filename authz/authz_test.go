@@ -0,0 +1,89 @@
+package authz
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+func TestApplyDenies(t *testing.T) {
+	tests := []struct {
+		name           string
+		grants         []string
+		denies         []string
+		wantGranted    []string
+		wantExceptions []string
+	}{
+		{
+			name:           "no denies",
+			grants:         []string{"contacts:entry:read"},
+			wantGranted:    []string{"contacts:entry:read"},
+			wantExceptions: []string{},
+		},
+		{
+			name:           "deny drops the grant",
+			grants:         []string{"contacts:entry:read", "contacts:entry:write"},
+			denies:         []string{"contacts:entry:write"},
+			wantGranted:    []string{"contacts:entry:read"},
+			wantExceptions: []string{},
+		},
+		{
+			name:           "wildcard deny drops every matching grant",
+			grants:         []string{"contacts:entry:read", "contacts:note:read", "billing:invoice:read"},
+			denies:         []string{"contacts:*:*"},
+			wantGranted:    []string{"billing:invoice:read"},
+			wantExceptions: []string{},
+		},
+		{
+			name:           "specific deny is an exception to a wildcard grant",
+			grants:         []string{"contacts:*:*"},
+			denies:         []string{"contacts:entry:delete"},
+			wantGranted:    []string{"contacts:*:*"},
+			wantExceptions: []string{"contacts:entry:delete"},
+		},
+		{
+			name:           "partially overlapping wildcards",
+			grants:         []string{"contacts:*:read"},
+			denies:         []string{"contacts:entry:*"},
+			wantGranted:    []string{"contacts:*:read"},
+			wantExceptions: []string{"contacts:entry:*"},
+		},
+		{
+			name:           "unrelated deny is left out",
+			grants:         []string{"contacts:*:read"},
+			denies:         []string{"billing:*:*", "contacts:entry:write"},
+			wantGranted:    []string{"contacts:*:read"},
+			wantExceptions: []string{},
+		},
+		{
+			name:           "legacy names",
+			grants:         []string{"User Admin", "contacts:*:*"},
+			denies:         []string{"user_admin"},
+			wantGranted:    []string{"contacts:*:*"},
+			wantExceptions: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accesses := []*repositories.Access{}
+			for i, name := range tt.grants {
+				accesses = append(accesses, &repositories.Access{ID: i + 1, Name: name})
+			}
+
+			granted, exceptions := applyDenies(accesses, tt.denies)
+
+			names := []string{}
+			for _, access := range granted {
+				names = append(names, access.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantGranted) {
+				t.Errorf("granted = %v, want %v", names, tt.wantGranted)
+			}
+			if !reflect.DeepEqual(exceptions, tt.wantExceptions) {
+				t.Errorf("exceptions = %v, want %v", exceptions, tt.wantExceptions)
+			}
+		})
+	}
+}
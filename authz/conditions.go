@@ -0,0 +1,275 @@
+package authz
+
+import (
+	"time"
+
+	"github.com/princeparmar/contact_manager/condition"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// conditionalGrant is an access reached through a role entry, with the conditions on the way.
+type conditionalGrant struct {
+	access     *repositories.Access
+	conditions []string
+}
+
+// roleEntries returns an entry for every role a user holds directly, conditionally or not, or through
+// a group. Assignments that have not started or have expired are skipped.
+func (a *Authorizer) roleEntries(g *graph, userID int) ([]*roleEntry, error) {
+	assignments, err := a.UserRoleRepo.GetAssignmentsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := a.GroupRepo.GetGroupsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*roleEntry{}
+	now := time.Now()
+	for _, assignment := range assignments {
+		if !assignment.Active(now) {
+			continue
+		}
+
+		link := &Link{Type: LinkRole, ID: assignment.RoleID, Name: assignment.RoleName, Condition: assignment.Condition}
+		if !assignment.StartDate.IsZero() {
+			link.StartDate = &assignment.StartDate
+		}
+		if !assignment.ExpiryDate.IsZero() {
+			link.ExpiryDate = &assignment.ExpiryDate
+		}
+
+		entry := &roleEntry{path: []*Link{link}, roleID: assignment.RoleID}
+		if assignment.Condition != "" {
+			entry.conditions = []string{assignment.Condition}
+			entry.extra = true
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, group := range groups {
+		link := &Link{Type: LinkGroup, ID: group.ID, Name: group.Name}
+		entries = append(entries, g.groupEntries([]*Link{link}, group.ID)...)
+	}
+
+	return entries, nil
+}
+
+// entryGrants returns the accesses of the role of entry and of the roles it inherits from, each with the
+// conditions of the entry and of the grant.
+func (g *graph) entryGrants(entry *roleEntry) []*conditionalGrant {
+	grants := []*conditionalGrant{}
+	for _, roleID := range g.lineage(entry.roleID) {
+		for _, access := range g.roleAccesses[roleID] {
+			conditions := entry.conditions
+			if c := g.condition(roleID, access.ID); c != "" {
+				conditions = append(conditions[:len(conditions):len(conditions)], c)
+			}
+			grants = append(grants, &conditionalGrant{access: access, conditions: conditions})
+		}
+	}
+	return grants
+}
+
+// entryDenies returns the denies attached to the roles of entries and the roles they inherit from.
+func (a *Authorizer) entryDenies(g *graph, entries []*roleEntry) ([]*repositories.AccessDeny, error) {
+	seen := map[int]bool{}
+	denies := []*repositories.AccessDeny{}
+	for _, entry := range entries {
+		for _, roleID := range g.lineage(entry.roleID) {
+			if seen[roleID] {
+				continue
+			}
+			seen[roleID] = true
+
+			roleDenies, err := a.DenyRepo.GetForRole(roleID)
+			if err != nil {
+				return nil, err
+			}
+			denies = append(denies, roleDenies...)
+		}
+	}
+	return denies, nil
+}
+
+// extraGrants holds what the effective role queries leave out of a decision.
+type extraGrants struct {
+	accesses []*repositories.Access
+	denies   []*repositories.AccessDeny
+	unmet    []*conditionalGrant
+}
+
+// evaluateExtra evaluates the roles bound to a user on resource and the grants that depend on a condition.
+// It returns the accesses they grant, the denies of the roles held through them and the conditional grants
+// whose conditions are not met, with the unmet conditions only. The role graph is only loaded when there
+// is something to evaluate.
+func (a *Authorizer) evaluateExtra(userID int, resource *repositories.ResourceRef, request map[string]interface{}) (*extraGrants, error) {
+	extra := &extraGrants{}
+
+	bindings, err := a.bindings(userID, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	conditional, err := a.RoleAccessRepo.HasConditions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bindings) == 0 && !conditional {
+		return extra, nil
+	}
+
+	g, err := a.loadGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := bindingEntries(bindings)
+	if conditional {
+		held, err := a.roleEntries(g, userID)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(held, entries...)
+	}
+
+	ev := a.newEvaluator(userID, request)
+	for _, entry := range entries {
+		for _, grant := range g.entryGrants(entry) {
+			// Unconditional grants of roles held unconditionally are already known
+			if len(grant.conditions) == 0 && !entry.extra {
+				continue
+			}
+
+			if unmet := ev.unmet(grant.conditions); len(unmet) > 0 {
+				extra.unmet = append(extra.unmet, &conditionalGrant{access: grant.access, conditions: unmet})
+			} else {
+				extra.accesses = append(extra.accesses, grant.access)
+			}
+		}
+	}
+
+	extra.denies, err = a.entryDenies(g, ev.heldExtra(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	return extra, nil
+}
+
+// ConditionalAccesses returns the accesses a user may hold depending on the attributes of a request,
+// which tokens cannot carry. Services seeing one of them in a token must ask the check API.
+func (a *Authorizer) ConditionalAccesses(userID int) ([]string, error) {
+	conditional, err := a.RoleAccessRepo.HasConditions(userID)
+	if err != nil || !conditional {
+		return nil, err
+	}
+
+	g, err := a.loadGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := a.roleEntries(g, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	unconditional := map[int]bool{}
+	grants := []*conditionalGrant{}
+	for _, entry := range entries {
+		for _, grant := range g.entryGrants(entry) {
+			if len(grant.conditions) == 0 {
+				unconditional[grant.access.ID] = true
+			} else {
+				grants = append(grants, grant)
+			}
+		}
+	}
+
+	seen := map[int]bool{}
+	names := []string{}
+	for _, grant := range grants {
+		if !unconditional[grant.access.ID] && !seen[grant.access.ID] {
+			seen[grant.access.ID] = true
+			names = append(names, grant.access.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// evaluator evaluates conditions for one decision against the request attributes and the stored
+// attributes of the user, which are loaded on first use. Results are cached by condition.
+type evaluator struct {
+	authorizer *Authorizer
+	userID     int
+	request    map[string]interface{}
+	user       map[string]interface{}
+	userErr    error
+	results    map[string]*ConditionResult
+}
+
+// newEvaluator returns an evaluator for a decision about a user.
+func (a *Authorizer) newEvaluator(userID int, request map[string]interface{}) *evaluator {
+	return &evaluator{
+		authorizer: a,
+		userID:     userID,
+		request:    request,
+		results:    map[string]*ConditionResult{},
+	}
+}
+
+// evaluate returns the result of a condition. A condition that cannot be evaluated is not satisfied.
+func (e *evaluator) evaluate(src string) *ConditionResult {
+	if result, ok := e.results[src]; ok {
+		return result
+	}
+
+	if e.user == nil && e.userErr == nil {
+		e.user, e.userErr = e.authorizer.AttributeRepo.GetForUser(e.userID)
+		if e.userErr == nil {
+			e.user["id"] = e.userID
+		}
+	}
+
+	result := &ConditionResult{Condition: src}
+	if e.userErr != nil {
+		result.Error = "user attributes unavailable: " + e.userErr.Error()
+	} else {
+		satisfied, err := condition.Evaluate(src, e.request, e.user)
+		result.Satisfied = satisfied
+		if err != nil {
+			result.Error = err.Error()
+		}
+	}
+
+	e.results[src] = result
+	return result
+}
+
+// unmet returns the conditions that are not satisfied.
+func (e *evaluator) unmet(conditions []string) []string {
+	unmet := []string{}
+	for _, c := range conditions {
+		if !e.evaluate(c).Satisfied {
+			unmet = append(unmet, c)
+		}
+	}
+	return unmet
+}
+
+// heldExtra returns the extra entries whose own conditions are satisfied, i.e. the roles the user holds
+// beyond the effective role queries.
+func (e *evaluator) heldExtra(entries []*roleEntry) []*roleEntry {
+	held := []*roleEntry{}
+	for _, entry := range entries {
+		if entry.extra && len(e.unmet(entry.conditions)) == 0 {
+			held = append(held, entry)
+		}
+	}
+	return held
+}
@@ -0,0 +1,144 @@
+package authz
+
+import (
+	"sort"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// AccessDiff compares the access set of a user or role with that of a reference. Added lists the accesses
+// only the reference has, which the subject would gain by matching it, Removed those only the subject has
+// and Common those both have.
+type AccessDiff struct {
+	Added   []*repositories.Access `json:"added"`
+	Removed []*repositories.Access `json:"removed"`
+	Common  []*repositories.Access `json:"common"`
+}
+
+// DiffUsers compares the effective accesses of a user with those of a reference user, with deny-overrides
+// applied as in EffectiveAccesses.
+func (a *Authorizer) DiffUsers(userID, referenceID int) (*AccessDiff, error) {
+	accesses, _, err := a.EffectiveAccesses(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	reference, _, err := a.EffectiveAccesses(referenceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffAccesses(accesses, reference), nil
+}
+
+// DiffRoles compares the direct and inherited accesses of a role with those of a reference role. Grants
+// that depend on a condition are left out, as they are for users.
+func (a *Authorizer) DiffRoles(roleID, referenceID int) (*AccessDiff, error) {
+	g, err := a.loadGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	return diffAccesses(g.unconditionalAccesses(roleID), g.unconditionalAccesses(referenceID)), nil
+}
+
+// MissingRoles returns the unconditional, active assignments of the reference user in the organization
+// of roles the user does not hold in any way. Roles the reference only holds through groups are not
+// included, since groups are the place to grant them.
+func (a *Authorizer) MissingRoles(userID, referenceID int) ([]*repositories.RoleAssignment, error) {
+	g, err := a.loadGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := a.roleEntries(g, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	held := map[int]bool{}
+	for _, entry := range entries {
+		if entry.extra {
+			continue
+		}
+		for _, roleID := range g.lineage(entry.roleID) {
+			held[roleID] = true
+		}
+	}
+
+	assignments, err := a.UserRoleRepo.GetAssignmentsForUser(referenceID)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []*repositories.RoleAssignment{}
+	now := time.Now()
+	for _, assignment := range assignments {
+		if !assignment.Active(now) || assignment.Condition != "" || held[assignment.RoleID] {
+			continue
+		}
+		held[assignment.RoleID] = true
+		missing = append(missing, assignment)
+	}
+
+	return missing, nil
+}
+
+// unconditionalAccesses returns the distinct accesses a role grants without a condition, directly or by
+// inheritance.
+func (g *graph) unconditionalAccesses(roleID int) []*repositories.Access {
+	seen := map[int]bool{}
+	accesses := []*repositories.Access{}
+	for _, id := range g.lineage(roleID) {
+		for _, access := range g.roleAccesses[id] {
+			if seen[access.ID] || g.condition(id, access.ID) != "" {
+				continue
+			}
+			seen[access.ID] = true
+			accesses = append(accesses, access)
+		}
+	}
+	return accesses
+}
+
+// diffAccesses compares two access sets by access ID. Each list is sorted by access name.
+func diffAccesses(accesses, reference []*repositories.Access) *AccessDiff {
+	diff := &AccessDiff{
+		Added:   []*repositories.Access{},
+		Removed: []*repositories.Access{},
+		Common:  []*repositories.Access{},
+	}
+
+	inReference := map[int]bool{}
+	for _, access := range reference {
+		inReference[access.ID] = true
+	}
+
+	inSubject := map[int]bool{}
+	for _, access := range accesses {
+		if inSubject[access.ID] {
+			continue
+		}
+		inSubject[access.ID] = true
+
+		if inReference[access.ID] {
+			diff.Common = append(diff.Common, access)
+		} else {
+			diff.Removed = append(diff.Removed, access)
+		}
+	}
+
+	for _, access := range reference {
+		if !inSubject[access.ID] {
+			inSubject[access.ID] = true
+			diff.Added = append(diff.Added, access)
+		}
+	}
+
+	for _, list := range [][]*repositories.Access{diff.Added, diff.Removed, diff.Common} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+
+	return diff
+}
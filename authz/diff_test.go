@@ -0,0 +1,97 @@
+package authz
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+func accessNames(accesses []*repositories.Access) []string {
+	names := []string{}
+	for _, access := range accesses {
+		names = append(names, access.Name)
+	}
+	return names
+}
+
+func TestDiffAccesses(t *testing.T) {
+	read := &repositories.Access{ID: 1, Name: "contacts:entry:read"}
+	write := &repositories.Access{ID: 2, Name: "contacts:entry:write"}
+	audit := &repositories.Access{ID: 3, Name: "reports:audit:read"}
+
+	tests := []struct {
+		name        string
+		accesses    []*repositories.Access
+		reference   []*repositories.Access
+		wantAdded   []string
+		wantRemoved []string
+		wantCommon  []string
+	}{
+		{
+			name:        "both empty",
+			wantAdded:   []string{},
+			wantRemoved: []string{},
+			wantCommon:  []string{},
+		},
+		{
+			name:        "identical",
+			accesses:    []*repositories.Access{read, write},
+			reference:   []*repositories.Access{write, read},
+			wantAdded:   []string{},
+			wantRemoved: []string{},
+			wantCommon:  []string{"contacts:entry:read", "contacts:entry:write"},
+		},
+		{
+			name:        "disjoint",
+			accesses:    []*repositories.Access{audit},
+			reference:   []*repositories.Access{write, read},
+			wantAdded:   []string{"contacts:entry:read", "contacts:entry:write"},
+			wantRemoved: []string{"reports:audit:read"},
+			wantCommon:  []string{},
+		},
+		{
+			name:        "duplicates are listed once",
+			accesses:    []*repositories.Access{read, read, audit},
+			reference:   []*repositories.Access{read, write, write},
+			wantAdded:   []string{"contacts:entry:write"},
+			wantRemoved: []string{"reports:audit:read"},
+			wantCommon:  []string{"contacts:entry:read"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffAccesses(tt.accesses, tt.reference)
+
+			if got := accessNames(diff.Added); !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Errorf("Added = %v, want %v", got, tt.wantAdded)
+			}
+			if got := accessNames(diff.Removed); !reflect.DeepEqual(got, tt.wantRemoved) {
+				t.Errorf("Removed = %v, want %v", got, tt.wantRemoved)
+			}
+			if got := accessNames(diff.Common); !reflect.DeepEqual(got, tt.wantCommon) {
+				t.Errorf("Common = %v, want %v", got, tt.wantCommon)
+			}
+		})
+	}
+}
+
+func TestGraphUnconditionalAccesses(t *testing.T) {
+	tests := []struct {
+		roleID int
+		want   []string
+	}{
+		{1, []string{"contacts:entry:read"}},
+		{2, []string{"contacts:entry:read"}},
+		{3, []string{"contacts:*:*", "contacts:entry:read"}},
+		{5, []string{}},
+	}
+
+	g := testGraph()
+	for _, tt := range tests {
+		if got := accessNames(g.unconditionalAccesses(tt.roleID)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("unconditionalAccesses(%d) = %v, want %v", tt.roleID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package authz
+
+import (
+	"sort"
+	"time"
+
+	"github.com/princeparmar/contact_manager/permission"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Link types used in a GrantPath.
+const (
+	LinkRole          = "role"
+	LinkInheritedRole = "inherited_role"
+	LinkGroup         = "group"
+
+	// LinkBinding is a role binding; its name is the resource the role is bound on, which is the
+	// checked resource or one of its ancestors
+	LinkBinding = "binding"
+)
+
+// maxSuggestions is the number of roles suggested for a denied access.
+const maxSuggestions = 5
+
+// Link is one step on the way from a user to an access. A role link with a Condition is an
+// assignment that only holds when the condition is satisfied.
+type Link struct {
+	Type       string     `json:"type"`
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	StartDate  *time.Time `json:"start_date,omitempty"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty"`
+	Condition  string     `json:"condition,omitempty"`
+}
+
+// ConditionResult is the outcome of evaluating a condition against the attributes of a request.
+type ConditionResult struct {
+	Condition string `json:"condition"`
+	Satisfied bool   `json:"satisfied"`
+	Error     string `json:"error,omitempty"`
+}
+
+// GrantPath is a chain of links ending in a role that is granted an access matching the request. A path
+// with conditions, from its assignment or from the grant itself, only counts when all are satisfied.
+type GrantPath struct {
+	Links      []*Link            `json:"links"`
+	Grant      string             `json:"grant"`
+	Conditions []*ConditionResult `json:"conditions,omitempty"`
+
+	conditions []string
+}
+
+// RoleSuggestion is a role that would grant a denied access, with the number of accesses the user
+// would gain in addition to it.
+type RoleSuggestion struct {
+	RoleID             int    `json:"role_id"`
+	RoleName           string `json:"role_name"`
+	Grant              string `json:"grant"`
+	AdditionalAccesses int    `json:"additional_accesses"`
+}
+
+// Explanation describes why a user does or does not hold an access.
+type Explanation struct {
+	UserID      int                        `json:"user_id"`
+	Resource    *repositories.ResourceRef  `json:"resource,omitempty"`
+	Access      string                     `json:"access"`
+	Allowed     bool                       `json:"allowed"`
+	Paths       []*GrantPath               `json:"paths"`
+	DeniedBy    []*repositories.AccessDeny `json:"denied_by,omitempty"`
+	Suggestions []*RoleSuggestion          `json:"suggestions,omitempty"`
+}
+
+// requiredPermission returns the permission asked for by an access name.
+func requiredPermission(access string) permission.Permission {
+	required, err := permission.Parse(access)
+	if err != nil {
+		required = permission.FromLegacyName(access)
+	}
+	return required
+}
+
+// Explain returns every path that grants access to a user, the denies overriding them and, when there
+// is no path, the roles that would grant it with the fewest additional accesses. With a resource, the
+// roles bound to the user on the resource or its ancestors are paths too. Conditions on the way are
+// evaluated against the request attributes, which may be nil.
+func (a *Authorizer) Explain(userID int, resource *repositories.ResourceRef, request map[string]interface{}, access string) (*Explanation, error) {
+	g, err := a.loadGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	required := requiredPermission(access)
+
+	explanation := &Explanation{
+		UserID:   userID,
+		Resource: resource,
+		Access:   access,
+		Paths:    []*GrantPath{},
+	}
+
+	// Every role the user holds directly, through a group or through a binding is an entry point into the role graph
+	entries, err := a.roleEntries(g, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := a.bindings(userID, resource)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, bindingEntries(bindings)...)
+
+	ev := a.newEvaluator(userID, request)
+	held := map[int]bool{}
+	satisfied := false
+	for _, entry := range entries {
+		for _, path := range g.tracePaths(entry.path, entry.conditions, entry.roleID, required) {
+			met := true
+			for _, c := range path.conditions {
+				result := ev.evaluate(c)
+				path.Conditions = append(path.Conditions, result)
+				met = met && result.Satisfied
+			}
+			satisfied = satisfied || met
+			explanation.Paths = append(explanation.Paths, path)
+		}
+		for _, granted := range g.effectiveAccesses(entry.roleID) {
+			held[granted.ID] = true
+		}
+	}
+
+	denies, err := a.DenyRepo.GetEffectiveDenies(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	extraDenies, err := a.entryDenies(g, ev.heldExtra(entries))
+	if err != nil {
+		return nil, err
+	}
+
+	// A role may be reached more than once, so the same deny can be too
+	seen := map[int]bool{}
+	for _, deny := range append(denies, extraDenies...) {
+		if seen[deny.ID] {
+			continue
+		}
+		seen[deny.ID] = true
+
+		if permission.FromLegacyName(deny.AccessName).Matches(required) {
+			explanation.DeniedBy = append(explanation.DeniedBy, deny)
+		}
+	}
+
+	explanation.Allowed = satisfied && len(explanation.DeniedBy) == 0
+	if len(explanation.Paths) == 0 {
+		explanation.Suggestions = g.suggestRoles(required, held)
+	}
+
+	return explanation, nil
+}
+
+// roleEntry is a role reached by a user through path. The role is only held when its conditions are
+// satisfied. Extra entries are those the effective role queries leave out: conditional assignments
+// and bindings.
+type roleEntry struct {
+	path       []*Link
+	roleID     int
+	conditions []string
+	extra      bool
+}
+
+// groupEntries returns the roles held through groupID and the groups containing it.
+func (g *graph) groupEntries(path []*Link, groupID int) []*roleEntry {
+	entries := []*roleEntry{}
+
+	for _, gr := range g.groupRoles[groupID] {
+		link := &Link{Type: LinkRole, ID: gr.RoleID, Name: gr.RoleName}
+		if !gr.ExpiryDate.IsZero() {
+			link.ExpiryDate = &gr.ExpiryDate
+		}
+		entries = append(entries, &roleEntry{path: append(path[:len(path):len(path)], link), roleID: gr.RoleID})
+	}
+
+	for _, container := range g.containers[groupID] {
+		link := &Link{Type: LinkGroup, ID: container, Name: g.groupName(container)}
+		entries = append(entries, g.groupEntries(append(path[:len(path):len(path)], link), container)...)
+	}
+
+	return entries
+}
+
+// tracePaths follows roleID and its ancestors and returns a path for every direct grant matching required,
+// with the conditions of the path and of the grant.
+func (g *graph) tracePaths(path []*Link, conditions []string, roleID int, required permission.Permission) []*GrantPath {
+	paths := []*GrantPath{}
+
+	for _, access := range g.roleAccesses[roleID] {
+		grant := permission.FromLegacyName(access.Name)
+		if grant.Matches(required) {
+			links := make([]*Link, len(path))
+			copy(links, path)
+
+			grantConditions := conditions
+			if c := g.condition(roleID, access.ID); c != "" {
+				grantConditions = append(conditions[:len(conditions):len(conditions)], c)
+			}
+
+			paths = append(paths, &GrantPath{Links: links, Grant: access.Name, conditions: grantConditions})
+		}
+	}
+
+	for _, parent := range g.parents[roleID] {
+		link := &Link{Type: LinkInheritedRole, ID: parent, Name: g.roleName(parent)}
+		paths = append(paths, g.tracePaths(append(path[:len(path):len(path)], link), conditions, parent, required)...)
+	}
+
+	return paths
+}
+
+// suggestRoles returns the roles granting required, ordered by how many accesses beyond held they add.
+func (g *graph) suggestRoles(required permission.Permission, held map[int]bool) []*RoleSuggestion {
+	suggestions := []*RoleSuggestion{}
+
+	for roleID, role := range g.roles {
+		var grant string
+		additional := map[int]bool{}
+
+		for _, access := range g.effectiveAccesses(roleID) {
+			if grant == "" && permission.FromLegacyName(access.Name).Matches(required) {
+				grant = access.Name
+			}
+			if !held[access.ID] {
+				additional[access.ID] = true
+			}
+		}
+
+		if grant != "" {
+			suggestions = append(suggestions, &RoleSuggestion{
+				RoleID:             roleID,
+				RoleName:           role.Name,
+				Grant:              grant,
+				AdditionalAccesses: len(additional),
+			})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].AdditionalAccesses != suggestions[j].AdditionalAccesses {
+			return suggestions[i].AdditionalAccesses < suggestions[j].AdditionalAccesses
+		}
+		return suggestions[i].RoleID < suggestions[j].RoleID
+	})
+
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+
+	return suggestions
+}
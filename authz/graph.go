@@ -0,0 +1,171 @@
+package authz
+
+import (
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// graph is an in-memory snapshot of roles, their parents and their direct accesses, used to
+// trace how an access reaches a user. It only contains the roles and groups visible to the
+// organization the Authorizer is scoped to.
+type graph struct {
+	roles        map[int]*repositories.Role
+	parents      map[int][]int
+	roleAccesses map[int][]*repositories.Access
+	conditions   map[roleAccessKey]string
+
+	groups     map[int]*repositories.Group
+	containers map[int][]int
+	groupRoles map[int][]*repositories.GroupRole
+}
+
+// roleAccessKey identifies the grant of an access to a role.
+type roleAccessKey struct {
+	roleID   int
+	accessID int
+}
+
+// loadGraph reads the role graph from the repositories.
+func (a *Authorizer) loadGraph() (*graph, error) {
+	roles, err := a.RoleRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	roleParents, err := a.RoleParentRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	accesses, err := a.AccessRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	roleAccesses, err := a.RoleAccessRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := a.GroupRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	nesting, err := a.GroupRepo.GetAllNesting()
+	if err != nil {
+		return nil, err
+	}
+
+	groupRoles, err := a.GroupRepo.GetAllActiveRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &graph{
+		roles:        map[int]*repositories.Role{},
+		parents:      map[int][]int{},
+		roleAccesses: map[int][]*repositories.Access{},
+		conditions:   map[roleAccessKey]string{},
+		groups:       map[int]*repositories.Group{},
+		containers:   map[int][]int{},
+		groupRoles:   map[int][]*repositories.GroupRole{},
+	}
+
+	for _, group := range groups {
+		g.groups[group.ID] = group
+	}
+
+	for _, n := range nesting {
+		if g.groups[n.GroupID] == nil || g.groups[n.MemberGroupID] == nil {
+			continue
+		}
+		g.containers[n.MemberGroupID] = append(g.containers[n.MemberGroupID], n.GroupID)
+	}
+
+	for _, gr := range groupRoles {
+		if g.groups[gr.GroupID] == nil {
+			continue
+		}
+		g.groupRoles[gr.GroupID] = append(g.groupRoles[gr.GroupID], gr)
+	}
+
+	for _, role := range roles {
+		g.roles[role.ID] = role
+	}
+
+	for _, rp := range roleParents {
+		if g.roles[rp.RoleID] == nil || g.roles[rp.ParentRoleID] == nil {
+			continue
+		}
+		g.parents[rp.RoleID] = append(g.parents[rp.RoleID], rp.ParentRoleID)
+	}
+
+	byID := map[int]*repositories.Access{}
+	for _, access := range accesses {
+		byID[access.ID] = access
+	}
+
+	for _, ra := range roleAccesses {
+		if access, ok := byID[ra.AccessID]; ok && g.roles[ra.RoleID] != nil {
+			g.roleAccesses[ra.RoleID] = append(g.roleAccesses[ra.RoleID], access)
+			if ra.Condition != "" {
+				g.conditions[roleAccessKey{ra.RoleID, ra.AccessID}] = ra.Condition
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// roleName returns the name of a role, or an empty string for an unknown role.
+func (g *graph) roleName(roleID int) string {
+	if role, ok := g.roles[roleID]; ok {
+		return role.Name
+	}
+	return ""
+}
+
+// groupName returns the name of a group, or an empty string for an unknown group.
+func (g *graph) groupName(groupID int) string {
+	if group, ok := g.groups[groupID]; ok {
+		return group.Name
+	}
+	return ""
+}
+
+// lineage returns a role followed by the roles it inherits from, directly or transitively.
+func (g *graph) lineage(roleID int) []int {
+	seen := map[int]bool{}
+	roles := []int{}
+
+	var walk func(id int)
+	walk = func(id int) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+
+		roles = append(roles, id)
+		for _, parent := range g.parents[id] {
+			walk(parent)
+		}
+	}
+	walk(roleID)
+
+	return roles
+}
+
+// condition returns the condition of the grant of an access to a role, or an empty string for an
+// unconditional grant.
+func (g *graph) condition(roleID, accessID int) string {
+	return g.conditions[roleAccessKey{roleID, accessID}]
+}
+
+// effectiveAccesses returns the direct and inherited accesses of a role, conditional or not.
+func (g *graph) effectiveAccesses(roleID int) []*repositories.Access {
+	accesses := []*repositories.Access{}
+	for _, id := range g.lineage(roleID) {
+		accesses = append(accesses, g.roleAccesses[id]...)
+	}
+	return accesses
+}
@@ -0,0 +1,174 @@
+package authz
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/permission"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// testGraph returns a graph with the role chain admin -> editor -> viewer, an unrelated auditor role,
+// two roles inheriting from each other and the group managers nested in staff.
+func testGraph() *graph {
+	read := &repositories.Access{ID: 10, Name: "contacts:entry:read"}
+	write := &repositories.Access{ID: 11, Name: "contacts:entry:write"}
+	all := &repositories.Access{ID: 12, Name: "contacts:*:*"}
+	audit := &repositories.Access{ID: 13, Name: "reports:audit:read"}
+
+	return &graph{
+		roles: map[int]*repositories.Role{
+			1: {ID: 1, Name: "viewer"},
+			2: {ID: 2, Name: "editor"},
+			3: {ID: 3, Name: "admin"},
+			4: {ID: 4, Name: "auditor"},
+			5: {ID: 5, Name: "left"},
+			6: {ID: 6, Name: "right"},
+		},
+		parents: map[int][]int{2: {1}, 3: {2}, 5: {6}, 6: {5}},
+		roleAccesses: map[int][]*repositories.Access{
+			1: {read},
+			2: {write},
+			3: {all},
+			4: {audit},
+		},
+		conditions: map[roleAccessKey]string{{2, 11}: "request.ip == \"10.0.0.1\""},
+		groups: map[int]*repositories.Group{
+			100: {ID: 100, Name: "staff"},
+			101: {ID: 101, Name: "managers"},
+		},
+		containers: map[int][]int{101: {100}},
+		groupRoles: map[int][]*repositories.GroupRole{
+			100: {{GroupID: 100, RoleID: 1, RoleName: "viewer"}},
+			101: {{GroupID: 101, RoleID: 4, RoleName: "auditor"}},
+		},
+	}
+}
+
+func sorted(ids []int) []int {
+	sort.Ints(ids)
+	return ids
+}
+
+func TestGraphLineage(t *testing.T) {
+	tests := []struct {
+		name   string
+		roleID int
+		want   []int
+	}{
+		{"no parents", 1, []int{1}},
+		{"chain", 3, []int{3, 2, 1}},
+		{"cycle", 5, []int{5, 6}},
+		{"unknown role", 99, []int{99}},
+	}
+
+	g := testGraph()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.lineage(tt.roleID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lineage(%d) = %v, want %v", tt.roleID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphEffectiveAccesses(t *testing.T) {
+	tests := []struct {
+		roleID int
+		want   []int
+	}{
+		{1, []int{10}},
+		{2, []int{10, 11}},
+		{3, []int{10, 11, 12}},
+		{5, []int{}},
+	}
+
+	g := testGraph()
+	for _, tt := range tests {
+		ids := []int{}
+		for _, access := range g.effectiveAccesses(tt.roleID) {
+			ids = append(ids, access.ID)
+		}
+		if got := sorted(ids); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("effectiveAccesses(%d) = %v, want %v", tt.roleID, got, tt.want)
+		}
+	}
+}
+
+func TestGraphTracePaths(t *testing.T) {
+	type trace struct {
+		grant      string
+		links      int
+		conditions int
+	}
+
+	tests := []struct {
+		name     string
+		roleID   int
+		required string
+		want     []trace
+	}{
+		{"direct grant", 1, "contacts:entry:read", []trace{{"contacts:entry:read", 0, 0}}},
+		{"inherited and wildcard", 3, "contacts:entry:read", []trace{{"contacts:*:*", 0, 0}, {"contacts:entry:read", 2, 0}}},
+		{"conditional grant", 2, "contacts:entry:write", []trace{{"contacts:entry:write", 0, 1}}},
+		{"not granted", 4, "contacts:entry:read", []trace{}},
+	}
+
+	g := testGraph()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []trace{}
+			for _, path := range g.tracePaths(nil, nil, tt.roleID, permission.FromLegacyName(tt.required)) {
+				got = append(got, trace{path.Grant, len(path.Links), len(path.conditions)})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tracePaths(%d, %q) = %v, want %v", tt.roleID, tt.required, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphInheritingRoles(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles map[int]bool
+		want  []int
+	}{
+		{"none", map[int]bool{}, []int{}},
+		{"root of a chain", map[int]bool{1: true}, []int{1, 2, 3}},
+		{"middle of a chain", map[int]bool{2: true}, []int{2, 3}},
+		{"cycle", map[int]bool{6: true}, []int{5, 6}},
+	}
+
+	g := testGraph()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sorted(g.inheritingRoles(tt.roles)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inheritingRoles(%v) = %v, want %v", tt.roles, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphGroupsHolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []int
+		want  []int
+	}{
+		{"none", nil, []int{}},
+		{"role of the containing group", []int{1}, []int{100, 101}},
+		{"role of the nested group", []int{4}, []int{101}},
+		{"role of no group", []int{3}, []int{}},
+	}
+
+	g := testGraph()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sorted(g.groupsHolding(tt.roles)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupsHolding(%v) = %v, want %v", tt.roles, got, tt.want)
+			}
+		})
+	}
+}
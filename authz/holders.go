@@ -0,0 +1,150 @@
+package authz
+
+import (
+	"github.com/princeparmar/contact_manager/permission"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Holder is a user holding an access, with every unconditional path granting it.
+type Holder struct {
+	UserID   int          `json:"user_id"`
+	UserName string       `json:"user_name"`
+	Paths    []*GrantPath `json:"paths"`
+}
+
+// HolderPage is a page of the users holding an access. Total is the number of holders across all pages.
+type HolderPage struct {
+	Access  string    `json:"access"`
+	Total   int       `json:"total"`
+	Offset  int       `json:"offset"`
+	Limit   int       `json:"limit"`
+	Holders []*Holder `json:"holders"`
+}
+
+// Holders returns a page of the members of the organization holding access, ordered by user ID. A user
+// holds it through an unexpired, unconditional assignment or group role of a role granting it without a
+// condition, directly or by inheritance, and is not denied it. Conditional grants and role bindings depend
+// on the request and are not considered.
+func (a *Authorizer) Holders(access string, offset, limit int) (*HolderPage, error) {
+	g, err := a.loadGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	required := requiredPermission(access)
+
+	granting := map[int]bool{}
+	for roleID, accesses := range g.roleAccesses {
+		for _, granted := range accesses {
+			if g.condition(roleID, granted.ID) == "" && permission.FromLegacyName(granted.Name).Matches(required) {
+				granting[roleID] = true
+			}
+		}
+	}
+
+	denies, err := a.DenyRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	denying := map[int]bool{}
+	deniedUsers := []int{}
+	for _, deny := range denies {
+		if !permission.FromLegacyName(deny.AccessName).Matches(required) {
+			continue
+		}
+		if deny.UserID != 0 {
+			deniedUsers = append(deniedUsers, deny.UserID)
+		} else {
+			denying[deny.RoleID] = true
+		}
+	}
+
+	holdingRoles := g.inheritingRoles(granting)
+	deniedRoles := g.inheritingRoles(denying)
+
+	users, total, err := a.UserRoleRepo.GetHolders(&repositories.HolderQuery{
+		RoleIDs:          holdingRoles,
+		GroupIDs:         g.groupsHolding(holdingRoles),
+		ExcludedUserIDs:  deniedUsers,
+		ExcludedRoleIDs:  deniedRoles,
+		ExcludedGroupIDs: g.groupsHolding(deniedRoles),
+		Offset:           offset,
+		Limit:            limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &HolderPage{
+		Access:  access,
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Holders: []*Holder{},
+	}
+
+	for _, user := range users {
+		entries, err := a.roleEntries(g, user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		holder := &Holder{UserID: user.ID, UserName: user.UserName, Paths: []*GrantPath{}}
+		for _, entry := range entries {
+			if entry.extra {
+				continue
+			}
+			for _, path := range g.tracePaths(entry.path, nil, entry.roleID, required) {
+				if len(path.conditions) == 0 {
+					holder.Paths = append(holder.Paths, path)
+				}
+			}
+		}
+		page.Holders = append(page.Holders, holder)
+	}
+
+	return page, nil
+}
+
+// inheritingRoles returns the roles that are in roles or inherit from one of them.
+func (g *graph) inheritingRoles(roles map[int]bool) []int {
+	inheriting := []int{}
+	if len(roles) == 0 {
+		return inheriting
+	}
+
+	for roleID := range g.roles {
+		for _, id := range g.lineage(roleID) {
+			if roles[id] {
+				inheriting = append(inheriting, roleID)
+				break
+			}
+		}
+	}
+	return inheriting
+}
+
+// groupsHolding returns the groups whose members hold one of roles through the group or a group
+// containing it.
+func (g *graph) groupsHolding(roles []int) []int {
+	wanted := map[int]bool{}
+	for _, roleID := range roles {
+		wanted[roleID] = true
+	}
+
+	holding := []int{}
+	if len(wanted) == 0 {
+		return holding
+	}
+
+	for groupID := range g.groups {
+		for _, entry := range g.groupEntries(nil, groupID) {
+			if wanted[entry.roleID] {
+				holding = append(holding, groupID)
+				break
+			}
+		}
+	}
+	return holding
+}
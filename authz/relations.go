@@ -0,0 +1,384 @@
+package authz
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/princeparmar/contact_manager/rebac"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// MaxRelationDepth bounds how many relations a check or expand follows from the relation asked about.
+const MaxRelationDepth = 25
+
+// ErrRelationDepth is returned when evaluating a relation needs more than MaxRelationDepth steps.
+var ErrRelationDepth = errors.New("relation evaluation exceeds the maximum depth")
+
+// RelationChecker answers relationship checks from the tuple store, following the rewrites of the
+// namespace configurations. Every answer carries the consistency token of the revision it was evaluated
+// at; given a token, it evaluates at that revision or a later one, so reads observe earlier writes.
+type RelationChecker struct {
+	TupleRepo     repositories.TupleRepository
+	NamespaceRepo repositories.NamespaceRepository
+}
+
+// NewRelationChecker returns a new instance of RelationChecker.
+func NewRelationChecker(tupleRepo repositories.TupleRepository, namespaceRepo repositories.NamespaceRepository) *RelationChecker {
+	return &RelationChecker{
+		TupleRepo:     tupleRepo,
+		NamespaceRepo: namespaceRepo,
+	}
+}
+
+// ForOrg returns a copy of the RelationChecker that reads and writes the tuples of an organization.
+func (c *RelationChecker) ForOrg(orgID int) *RelationChecker {
+	return &RelationChecker{
+		TupleRepo:     *c.TupleRepo.ForOrg(orgID),
+		NamespaceRepo: c.NamespaceRepo,
+	}
+}
+
+// UsersetTree is the result of expanding a relation of an object: a node per rewrite, with the subjects
+// written in tuples at the leaves. Subjects that are usersets are not expanded further. A relation already
+// being expanded higher up the tree is reported with the operation "recursive" and no children.
+type UsersetTree struct {
+	Object    string         `json:"object"`
+	Relation  string         `json:"relation"`
+	Operation string         `json:"operation"`
+	Subjects  []string       `json:"subjects,omitempty"`
+	Children  []*UsersetTree `json:"children,omitempty"`
+}
+
+// Write validates and applies tuple writes and deletes in one revision and returns its consistency token.
+// Written tuples must use a relation their namespace accepts tuples for, and userset subjects must name a
+// relation defined in their namespace. Deletes are not validated, so that stale tuples can be removed.
+func (c *RelationChecker) Write(writes, deletes []rebac.Tuple) (string, error) {
+	ev := c.newRelationEval(0)
+	for _, t := range writes {
+		config, err := ev.namespace(t.Object.Namespace)
+		if err != nil {
+			return "", err
+		}
+		if !config.AllowsTuples(t.Relation) {
+			return "", fmt.Errorf("relation %s of namespace %s does not accept tuples", t.Relation, t.Object.Namespace)
+		}
+
+		if t.Subject.IsUserset() {
+			subjectConfig, err := ev.namespace(t.Subject.Namespace)
+			if err != nil {
+				return "", err
+			}
+			if _, ok := subjectConfig.Relations[t.Subject.Relation]; !ok {
+				return "", fmt.Errorf("relation %s is not defined in namespace %s", t.Subject.Relation, t.Subject.Namespace)
+			}
+		}
+	}
+
+	revision, err := c.TupleRepo.Write(writes, deletes)
+	if err != nil {
+		return "", err
+	}
+	return rebac.EncodeToken(revision), nil
+}
+
+// Read returns the tuples of an object, restricted to a relation unless it is empty, and the consistency
+// token of the revision they were read at.
+func (c *RelationChecker) Read(object rebac.Object, relation, token string) ([]rebac.Tuple, string, error) {
+	revision, err := c.revision(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tuples, err := c.TupleRepo.Read(object, relation, revision)
+	if err != nil {
+		return nil, "", err
+	}
+	return tuples, rebac.EncodeToken(revision), nil
+}
+
+// Check reports whether subject holds relation on object, and returns the consistency token of the
+// revision it was evaluated at.
+func (c *RelationChecker) Check(object rebac.Object, relation string, subject rebac.Subject, token string) (bool, string, error) {
+	revision, err := c.revision(token)
+	if err != nil {
+		return false, "", err
+	}
+
+	allowed, err := c.newRelationEval(revision).check(object, relation, subject, 0)
+	if err != nil {
+		return false, "", err
+	}
+	return allowed, rebac.EncodeToken(revision), nil
+}
+
+// Expand returns the userset tree of relation on object, and the consistency token of the revision it
+// was evaluated at.
+func (c *RelationChecker) Expand(object rebac.Object, relation, token string) (*UsersetTree, string, error) {
+	revision, err := c.revision(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tree, err := c.newRelationEval(revision).expand(object, relation, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return tree, rebac.EncodeToken(revision), nil
+}
+
+// revision returns the revision to evaluate at: the latest one, which is never older than a given token.
+// A token from a later revision than the store has cannot have been issued by it and is rejected.
+func (c *RelationChecker) revision(token string) (int64, error) {
+	latest, err := c.TupleRepo.Revision()
+	if err != nil {
+		return 0, err
+	}
+
+	if token == "" {
+		return latest, nil
+	}
+
+	revision, err := rebac.DecodeToken(token)
+	if err != nil {
+		return 0, err
+	}
+	if revision > latest {
+		return 0, errors.New("consistency token is newer than the tuple store")
+	}
+	return latest, nil
+}
+
+// relationEval evaluates relations at one revision. Namespace configurations and tuples are read once
+// per evaluation, and relations being evaluated are tracked so that cyclic rewrites terminate.
+type relationEval struct {
+	checker  *RelationChecker
+	revision int64
+	configs  map[string]*rebac.NamespaceConfig
+	tuples   map[string][]rebac.Subject
+	visiting map[string]bool
+	held     map[string]bool
+}
+
+// newRelationEval returns an evaluation at a revision.
+func (c *RelationChecker) newRelationEval(revision int64) *relationEval {
+	return &relationEval{
+		checker:  c,
+		revision: revision,
+		configs:  map[string]*rebac.NamespaceConfig{},
+		tuples:   map[string][]rebac.Subject{},
+		visiting: map[string]bool{},
+		held:     map[string]bool{},
+	}
+}
+
+// namespace returns the configuration of a namespace, reporting an unconfigured namespace as an error.
+func (ev *relationEval) namespace(name string) (*rebac.NamespaceConfig, error) {
+	if config, ok := ev.configs[name]; ok {
+		return config, nil
+	}
+
+	config, err := ev.checker.NamespaceRepo.Get(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("namespace %s is not configured", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ev.configs[name] = config
+	return config, nil
+}
+
+// rewrite returns the rewrite of a relation, which is This for relations defined without one.
+func (ev *relationEval) rewrite(object rebac.Object, relation string) (*rebac.Rewrite, error) {
+	config, err := ev.namespace(object.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrite, ok := config.Relations[relation]
+	if !ok {
+		return nil, fmt.Errorf("relation %s is not defined in namespace %s", relation, object.Namespace)
+	}
+	if rewrite == nil {
+		rewrite = &rebac.Rewrite{This: &rebac.This{}}
+	}
+	return rewrite, nil
+}
+
+// subjects returns the subjects written in tuples for a relation of an object.
+func (ev *relationEval) subjects(object rebac.Object, relation string) ([]rebac.Subject, error) {
+	key := object.String() + "#" + relation
+	if subjects, ok := ev.tuples[key]; ok {
+		return subjects, nil
+	}
+
+	subjects, err := ev.checker.TupleRepo.GetSubjects(object, relation, ev.revision)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.tuples[key] = subjects
+	return subjects, nil
+}
+
+// check reports whether subject holds relation on object. A relation met again while it is being checked
+// adds nothing, so it is treated as not held on that path. Only positive results are cached, since a
+// negative one may depend on a path cut short by a cycle.
+func (ev *relationEval) check(object rebac.Object, relation string, subject rebac.Subject, depth int) (bool, error) {
+	if depth > MaxRelationDepth {
+		return false, ErrRelationDepth
+	}
+
+	key := object.String() + "#" + relation
+	if ev.held[key] {
+		return true, nil
+	}
+	if ev.visiting[key] {
+		return false, nil
+	}
+
+	rewrite, err := ev.rewrite(object, relation)
+	if err != nil {
+		return false, err
+	}
+
+	ev.visiting[key] = true
+	held, err := ev.checkRewrite(object, relation, rewrite, subject, depth)
+	delete(ev.visiting, key)
+	if err != nil {
+		return false, err
+	}
+
+	if held {
+		ev.held[key] = true
+	}
+	return held, nil
+}
+
+// checkRewrite reports whether subject is in the userset a rewrite of relation computes for object.
+func (ev *relationEval) checkRewrite(object rebac.Object, relation string, rewrite *rebac.Rewrite, subject rebac.Subject, depth int) (bool, error) {
+	switch {
+	case rewrite.This != nil:
+		subjects, err := ev.subjects(object, relation)
+		if err != nil {
+			return false, err
+		}
+
+		for _, s := range subjects {
+			if s == subject {
+				return true, nil
+			}
+		}
+
+		for _, s := range subjects {
+			if !s.IsUserset() {
+				continue
+			}
+			held, err := ev.check(s.Object, s.Relation, subject, depth+1)
+			if err != nil || held {
+				return held, err
+			}
+		}
+
+	case rewrite.ComputedUserset != nil:
+		return ev.check(object, rewrite.ComputedUserset.Relation, subject, depth+1)
+
+	case rewrite.TupleToUserset != nil:
+		subjects, err := ev.subjects(object, rewrite.TupleToUserset.Tupleset)
+		if err != nil {
+			return false, err
+		}
+
+		for _, s := range subjects {
+			held, err := ev.check(s.Object, rewrite.TupleToUserset.ComputedUserset, subject, depth+1)
+			if err != nil || held {
+				return held, err
+			}
+		}
+
+	default:
+		for _, child := range rewrite.Union {
+			held, err := ev.checkRewrite(object, relation, child, subject, depth)
+			if err != nil || held {
+				return held, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// expand returns the userset tree of relation on object.
+func (ev *relationEval) expand(object rebac.Object, relation string, depth int) (*UsersetTree, error) {
+	if depth > MaxRelationDepth {
+		return nil, ErrRelationDepth
+	}
+
+	key := object.String() + "#" + relation
+	if ev.visiting[key] {
+		return &UsersetTree{Object: object.String(), Relation: relation, Operation: "recursive"}, nil
+	}
+
+	rewrite, err := ev.rewrite(object, relation)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.visiting[key] = true
+	defer delete(ev.visiting, key)
+
+	return ev.expandRewrite(object, relation, rewrite, depth)
+}
+
+// expandRewrite returns the node of the userset tree for a rewrite of relation on object.
+func (ev *relationEval) expandRewrite(object rebac.Object, relation string, rewrite *rebac.Rewrite, depth int) (*UsersetTree, error) {
+	node := &UsersetTree{Object: object.String(), Relation: relation}
+
+	switch {
+	case rewrite.This != nil:
+		node.Operation = "this"
+		subjects, err := ev.subjects(object, relation)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range subjects {
+			node.Subjects = append(node.Subjects, s.String())
+		}
+
+	case rewrite.ComputedUserset != nil:
+		node.Operation = "computed_userset"
+		child, err := ev.expand(object, rewrite.ComputedUserset.Relation, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = []*UsersetTree{child}
+
+	case rewrite.TupleToUserset != nil:
+		node.Operation = "tuple_to_userset"
+		subjects, err := ev.subjects(object, rewrite.TupleToUserset.Tupleset)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range subjects {
+			child, err := ev.expand(s.Object, rewrite.TupleToUserset.ComputedUserset, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+
+	default:
+		node.Operation = "union"
+		for _, r := range rewrite.Union {
+			child, err := ev.expandRewrite(object, relation, r, depth)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	return node, nil
+}
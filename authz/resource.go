@@ -0,0 +1,29 @@
+package authz
+
+import (
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// bindings returns the active bindings of a user that apply to resource, or none without a resource.
+// Bindings only hold for the resource they are checked against, so they are never part of a user's token.
+func (a *Authorizer) bindings(userID int, resource *repositories.ResourceRef) ([]*repositories.RoleBinding, error) {
+	if resource == nil {
+		return nil, nil
+	}
+	return a.BindingRepo.GetApplicable(userID, *resource)
+}
+
+// bindingEntries returns an entry into the role graph for each binding, reached through the binding.
+func bindingEntries(bindings []*repositories.RoleBinding) []*roleEntry {
+	entries := []*roleEntry{}
+	for _, binding := range bindings {
+		link := &Link{Type: LinkBinding, ID: binding.ID, Name: binding.Resource.String()}
+		if !binding.ExpiryDate.IsZero() {
+			link.ExpiryDate = &binding.ExpiryDate
+		}
+
+		path := []*Link{link, {Type: LinkRole, ID: binding.RoleID, Name: binding.RoleName}}
+		entries = append(entries, &roleEntry{path: path, roleID: binding.RoleID, extra: true})
+	}
+	return entries
+}
@@ -0,0 +1,612 @@
+// Package condition implements the expression language of conditional grants, such as
+// "request.amount < 10000" or "in_cidr(request.ip, '10.20.0.0/16')".
+//
+// An expression is evaluated against two sets of attributes: request attributes, supplied by the
+// service asking for a decision, and user attributes, stored for the user. The language is kept
+// deliberately small so that evaluating untrusted expressions is safe:
+//
+//   - literals: numbers, 'single' or "double" quoted strings, true, false, null and lists [1, 2]
+//   - attributes: request.<name> and user.<name>, with dots to reach into nested objects
+//   - comparison: == != < <= > >= and in (membership of a list)
+//   - logic: && || ! and parentheses
+//   - functions: in_cidr(ip, cidr)
+//
+// There are no assignments, loops or user-defined functions, expressions are limited in length and
+// nesting, and a missing attribute is null. An expression that fails to evaluate is not satisfied.
+package condition
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Attribute roots available to expressions.
+const (
+	RequestRoot = "request"
+	UserRoot    = "user"
+)
+
+const (
+	// MaxLength is the longest expression accepted by Parse.
+	MaxLength = 1024
+	// MaxDepth is the deepest nesting of sub-expressions accepted by Parse.
+	MaxDepth = 32
+)
+
+// Expr is a parsed condition expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse parses a condition expression.
+func Parse(src string) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, errors.New("condition is empty")
+	}
+	if len(src) > MaxLength {
+		return nil, fmt.Errorf("condition is longer than %d characters", MaxLength)
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against request and user attributes. Either may be nil.
+func (e *Expr) Eval(request, user map[string]interface{}) (bool, error) {
+	env := map[string]interface{}{
+		RequestRoot: request,
+		UserRoot:    user,
+	}
+
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.New("condition does not evaluate to true or false")
+	}
+	return b, nil
+}
+
+// Evaluate parses and evaluates a condition expression.
+func Evaluate(src string, request, user map[string]interface{}) (bool, error) {
+	e, err := Parse(src)
+	if err != nil {
+		return false, err
+	}
+	return e.Eval(request, user)
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators lists the operators, longest first so that "<=" is not read as "<".
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!"}
+
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',' || c == '.':
+			tokens = append(tokens, token{kind: tokPunct, text: string(c), pos: i})
+			i++
+
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: src[i+1 : i+1+end], pos: i})
+			i += end + 2
+
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			i++
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start})
+
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it has the given text.
+func (p *parser) accept(text string) bool {
+	if tok := p.peek(); tok.kind != tokString && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at position %d", text, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, fmt.Errorf("condition is nested deeper than %d levels", MaxDepth)
+	}
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!") {
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > MaxDepth {
+			return nil, fmt.Errorf("condition is nested deeper than %d levels", MaxDepth)
+		}
+
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	if tok.kind == tokOp && tok.text != "&&" && tok.text != "||" && tok.text != "!" || tok.kind == tokIdent && tok.text == "in" {
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &comparison{op: tok.text, left: left, right: right}, nil
+	}
+
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return &literal{value: f}, nil
+
+	case tokString:
+		return &literal{value: tok.text}, nil
+
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		case RequestRoot, UserRoot:
+			path := []string{tok.text}
+			for p.accept(".") {
+				segment := p.next()
+				if segment.kind != tokIdent {
+					return nil, fmt.Errorf("expected an attribute name at position %d", segment.pos)
+				}
+				path = append(path, segment.text)
+			}
+			if len(path) == 1 {
+				return nil, fmt.Errorf("expected an attribute name after %q at position %d", tok.text, tok.pos)
+			}
+			return &attribute{path: path}, nil
+		}
+
+		if fn, ok := functions[tok.text]; ok && p.accept("(") {
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != fn.arity {
+				return nil, fmt.Errorf("%s takes %d arguments", tok.text, fn.arity)
+			}
+			return &call{name: tok.text, fn: fn.fn, args: args}, nil
+		}
+
+		return nil, fmt.Errorf("unknown name %q at position %d", tok.text, tok.pos)
+
+	case tokPunct:
+		switch tok.text {
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &list{items: items}, nil
+		}
+	}
+
+	if tok.kind == tokEOF {
+		return nil, errors.New("unexpected end of condition")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+}
+
+// parseList parses comma separated expressions up to the closing token.
+func (p *parser) parseList(closing string) ([]node, error) {
+	items := []node{}
+	if p.accept(closing) {
+		return items, nil
+	}
+
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if p.accept(closing) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Evaluation
+
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(env map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type attribute struct {
+	path []string
+}
+
+func (n *attribute) eval(env map[string]interface{}) (interface{}, error) {
+	var v interface{} = env
+	for _, segment := range n.path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		v = m[segment]
+	}
+	return normalize(v), nil
+}
+
+type list struct {
+	items []node
+}
+
+func (n *list) eval(env map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) eval(env map[string]interface{}) (interface{}, error) {
+	b, err := evalBool(n.operand, env)
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n *logical) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if left != n.and {
+		return left, nil
+	}
+	return evalBool(n.right, env)
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n *comparison) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		items, ok := right.([]interface{})
+		if !ok {
+			return nil, errors.New("the right side of in must be a list")
+		}
+		for _, item := range items {
+			if equal(left, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", n.op)
+}
+
+type function struct {
+	arity int
+	fn    func(args []interface{}) (interface{}, error)
+}
+
+// functions are the only functions an expression may call.
+var functions = map[string]function{
+	"in_cidr": {arity: 2, fn: inCIDR},
+}
+
+type call struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n *call) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return n.fn(args)
+}
+
+// inCIDR reports whether an IP address lies in a CIDR block.
+func inCIDR(args []interface{}) (interface{}, error) {
+	ip, ok1 := args[0].(string)
+	block, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errors.New("in_cidr takes an IP address and a CIDR block as strings")
+	}
+
+	_, network, err := net.ParseCIDR(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR block %q", block)
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, nil
+	}
+	return network.Contains(addr), nil
+}
+
+func evalBool(n node, env map[string]interface{}) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.New("&&, || and ! take true or false")
+	}
+	return b, nil
+}
+
+// normalize converts the numbers of attributes to float64, so that attributes decoded from JSON and
+// attributes set in Go compare alike.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+func equal(a, b interface{}) bool {
+	switch a.(type) {
+	case nil:
+		return b == nil
+	case float64, string, bool:
+		return a == b
+	}
+	return false
+}
+
+func compare(a, b interface{}) (int, error) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot order %v and %v", a, b)
+}
@@ -0,0 +1,105 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr bool
+	}{
+		{"comparison", "request.amount < 10000", false},
+		{"logic", "request.a == 1 && (user.b != 'x' || !request.c)", false},
+		{"membership", "user.dept in ['sales', \"support\"]", false},
+		{"function", "in_cidr(request.ip, '10.20.0.0/16')", false},
+		{"empty", "   ", true},
+		{"too long", "request.a == '" + strings.Repeat("x", MaxLength) + "'", true},
+		{"too deep", strings.Repeat("(", MaxDepth+1) + "true" + strings.Repeat(")", MaxDepth+1), true},
+		{"unknown root", "session.id == 1", true},
+		{"unknown function", "exec('rm')", true},
+		{"wrong arity", "in_cidr(request.ip)", true},
+		{"unterminated string", "request.a == 'x", true},
+		{"trailing tokens", "true false", true},
+		{"missing operand", "request.a ==", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, wantErr %v", tt.src, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	request := map[string]interface{}{
+		"amount": 500,
+		"ip":     "10.20.3.4",
+		"tags":   []interface{}{"a"},
+		"nested": map[string]interface{}{"level": "high"},
+	}
+	user := map[string]interface{}{
+		"dept":    "sales",
+		"limit":   float64(1000),
+		"enabled": true,
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		want    bool
+		wantErr bool
+	}{
+		{"less than", "request.amount < 10000", true, false},
+		{"attribute against attribute", "request.amount <= user.limit", true, false},
+		{"greater than", "request.amount > user.limit", false, false},
+		{"string equality", "user.dept == 'sales'", true, false},
+		{"string order", "user.dept >= 'a'", true, false},
+		{"inequality", "user.dept != \"sales\"", false, false},
+		{"membership", "user.dept in ['sales', 'support']", true, false},
+		{"not a member", "user.dept in ['support']", false, false},
+		{"nested attribute", "request.nested.level == 'high'", true, false},
+		{"missing attribute is null", "request.missing == null", true, false},
+		{"and", "user.enabled && request.amount < 1000", true, false},
+		{"or short-circuits", "user.enabled || request.missing > 1", true, false},
+		{"and short-circuits", "!user.enabled && request.missing > 1", false, false},
+		{"in cidr", "in_cidr(request.ip, '10.20.0.0/16')", true, false},
+		{"not in cidr", "in_cidr(request.ip, '192.168.0.0/16')", false, false},
+		{"invalid ip", "in_cidr('nope', '10.0.0.0/8')", false, false},
+		{"invalid cidr", "in_cidr(request.ip, 'nope')", false, true},
+		{"ordering mixed types", "user.dept < 1", false, true},
+		{"ordering null", "request.missing < 1", false, true},
+		{"in without a list", "user.dept in user.dept", false, true},
+		{"logic on a number", "request.amount && true", false, true},
+		{"not a boolean", "request.amount", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(tt.src, request, user)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate(%q) error = %v, wantErr %v", tt.src, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate(%q) = %v, want %v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalNilAttributes(t *testing.T) {
+	e, err := Parse("request.a == null && user.b == null")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := e.Eval(nil, nil)
+	if err != nil || !got {
+		t.Errorf("Eval(nil, nil) = %v, %v, want true, nil", got, err)
+	}
+}
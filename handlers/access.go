@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/princeparmar/contact_manager/permission"
 	"github.com/princeparmar/contact_manager/repositories"
 	"github.com/princeparmar/go-helpers/clienthelper"
 	"github.com/princeparmar/go-helpers/context"
@@ -59,7 +60,10 @@ func (a *Access) ValidateRequest(ctx context.IContext) error {
 	if a.Name == "" {
 		return errors.New("access name is required")
 	}
-	return nil
+
+	// Access names are structured permissions, e.g. contacts:entry:read or contacts:*:read
+	_, err := permission.Parse(a.Name)
+	return err
 }
 
 // CreateAccessExecutor defines an APIExecutor for creating a new access.
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// AccessDeny defines a struct for a deny entry attached to a role or a user.
+type AccessDeny struct {
+	ID       int
+	RoleID   int
+	UserID   int
+	AccessID int
+	OrgID    int
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the AccessDeny object.
+// All IDs are read from the query; the ones an executor does not use may be omitted.
+func (d *AccessDeny) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	for name, target := range map[string]*int{"id": &d.ID, "role_id": &d.RoleID, "user_id": &d.UserID, "access_id": &d.AccessID} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("invalid " + name + " in query")
+		}
+		*target = i
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	d.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the AccessDeny object and returns any errors that occur during validation.
+func (d *AccessDeny) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// CreateAccessDenyExecutor defines an APIExecutor for denying an access to a role or a user.
+type CreateAccessDenyExecutor struct {
+	AccessDeny
+	clienthelper.BaseAPIExecutor
+	UserRepo   repositories.UserRepository
+	RoleRepo   repositories.RoleRepository
+	AccessRepo repositories.AccessRepository
+	DenyRepo   repositories.AccessDenyRepository
+}
+
+// NewCreateAccessDenyExecutor returns a new instance of CreateAccessDenyExecutor.
+func NewCreateAccessDenyExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, accessRepo repositories.AccessRepository, denyRepo repositories.AccessDenyRepository) clienthelper.APIExecutor {
+	return &CreateAccessDenyExecutor{
+		UserRepo:   userRepo,
+		RoleRepo:   roleRepo,
+		AccessRepo: accessRepo,
+		DenyRepo:   denyRepo,
+	}
+}
+
+// ValidateRequest validates that the deny targets exactly one of a role or a user.
+func (e *CreateAccessDenyExecutor) ValidateRequest(ctx context.IContext) error {
+	if (e.RoleID == 0) == (e.UserID == 0) {
+		return errors.New("exactly one of role_id and user_id is required")
+	}
+
+	if e.AccessID == 0 {
+		return errors.New("access_id is required")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for creating a deny entry and returns the deny
+// and any errors that occur during execution.
+func (e *CreateAccessDenyExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if e.RoleID != 0 {
+		_, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		user, err := e.UserRepo.ForOrg(e.OrgID).Get(e.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, errors.New("user not found")
+		}
+	}
+
+	access, err := getAccess(e.AccessRepo, e.AccessID)
+	if err != nil {
+		return nil, err
+	}
+
+	deny := &repositories.AccessDeny{
+		RoleID:     e.RoleID,
+		UserID:     e.UserID,
+		AccessID:   access.ID,
+		AccessName: access.Name,
+	}
+	err = e.DenyRepo.ForOrg(e.OrgID).Create(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return deny, nil
+}
+
+// DeleteAccessDenyExecutor defines an APIExecutor for removing a deny entry by ID.
+type DeleteAccessDenyExecutor struct {
+	AccessDeny
+	clienthelper.BaseAPIExecutor
+	DenyRepo repositories.AccessDenyRepository
+}
+
+// NewDeleteAccessDenyExecutor returns a new instance of DeleteAccessDenyExecutor.
+func NewDeleteAccessDenyExecutor(repo repositories.AccessDenyRepository) clienthelper.APIExecutor {
+	return &DeleteAccessDenyExecutor{
+		DenyRepo: repo,
+	}
+}
+
+// Controller executes the business logic for removing a deny entry and returns any errors that occur during execution.
+func (e *DeleteAccessDenyExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	denyRepo := e.DenyRepo.ForOrg(e.OrgID)
+	deny, err := denyRepo.Get(e.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("deny not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deny.OrgID != e.OrgID {
+		return nil, errors.New("global denies cannot be removed within an organization")
+	}
+
+	return nil, denyRepo.Delete(e.ID)
+}
+
+// GetAccessDeniesExecutor defines an APIExecutor for listing the deny entries of a role or a user.
+type GetAccessDeniesExecutor struct {
+	AccessDeny
+	clienthelper.BaseAPIExecutor
+	DenyRepo repositories.AccessDenyRepository
+}
+
+// NewGetAccessDeniesExecutor returns a new instance of GetAccessDeniesExecutor.
+func NewGetAccessDeniesExecutor(repo repositories.AccessDenyRepository) clienthelper.APIExecutor {
+	return &GetAccessDeniesExecutor{
+		DenyRepo: repo,
+	}
+}
+
+// ValidateRequest validates that exactly one of a role or a user is given.
+func (e *GetAccessDeniesExecutor) ValidateRequest(ctx context.IContext) error {
+	if (e.RoleID == 0) == (e.UserID == 0) {
+		return errors.New("exactly one of role_id and user_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing deny entries and returns the denies
+// and any errors that occur during execution.
+func (e *GetAccessDeniesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	denyRepo := e.DenyRepo.ForOrg(e.OrgID)
+	if e.RoleID != 0 {
+		return denyRepo.GetForRole(e.RoleID)
+	}
+	return denyRepo.GetForUser(e.UserID)
+}
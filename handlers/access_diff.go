@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/princeparmar/contact_manager/authz"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// AccessDiff defines a struct for comparing the access set of a user with that of a reference user, or of
+// a role with that of a reference role. With Apply, the roles the reference user holds and the user lacks
+// are assigned to the user, which is how the access of a departing employee is cloned for a replacement.
+type AccessDiff struct {
+	UserID          int  `json:"user_id"`
+	ReferenceUserID int  `json:"reference_user_id"`
+	RoleID          int  `json:"role_id"`
+	ReferenceRoleID int  `json:"reference_role_id"`
+	Apply           bool `json:"apply"`
+	OrgID           int  `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the AccessDiff object.
+func (ad *AccessDiff) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the AccessDiff object
+	err = json.Unmarshal(body, ad)
+	if err != nil {
+		return err
+	}
+
+	ad.OrgID, err = orgID(r)
+	return err
+}
+
+// ValidateRequest validates that either two users or two roles are given, and that only user diffs are applied.
+func (ad *AccessDiff) ValidateRequest(ctx context.IContext) error {
+	users := ad.UserID != 0 && ad.ReferenceUserID != 0
+	roles := ad.RoleID != 0 && ad.ReferenceRoleID != 0
+
+	if users == roles || (users && (ad.RoleID != 0 || ad.ReferenceRoleID != 0)) || (roles && (ad.UserID != 0 || ad.ReferenceUserID != 0)) {
+		return errors.New("either user_id and reference_user_id or role_id and reference_role_id are required")
+	}
+
+	if ad.Apply && !users {
+		return errors.New("only a diff between users can be applied")
+	}
+
+	return nil
+}
+
+// AccessDiffResult defines the response of the access diff endpoint. Assigned lists the roles assigned when
+// the diff was applied; the diff is then computed after the assignment, so that Added only lists what the
+// assigned roles did not bring, such as accesses the reference holds through groups.
+type AccessDiffResult struct {
+	*authz.AccessDiff
+	Assigned []*repositories.UserRole `json:"assigned,omitempty"`
+}
+
+// AccessDiffExecutor defines an APIExecutor for comparing access sets and applying the difference.
+type AccessDiffExecutor struct {
+	AccessDiff
+	clienthelper.BaseAPIExecutor
+	UserRepo     repositories.UserRepository
+	RoleRepo     repositories.RoleRepository
+	UserRoleRepo repositories.UserRoleRepository
+	Authorizer   *authz.Authorizer
+}
+
+// NewAccessDiffExecutor returns a new instance of AccessDiffExecutor.
+func NewAccessDiffExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, userRoleRepo repositories.UserRoleRepository, authorizer *authz.Authorizer) clienthelper.APIExecutor {
+	return &AccessDiffExecutor{
+		UserRepo:     userRepo,
+		RoleRepo:     roleRepo,
+		UserRoleRepo: userRoleRepo,
+		Authorizer:   authorizer,
+	}
+}
+
+// Controller executes the business logic for the access diff and returns the added, removed and common
+// accesses and any errors that occur during execution. Applying assigns the missing roles in a single
+// transaction, with the expiry of the reference assignment; if one of them is rejected, for example by a
+// separation of duties constraint, none is assigned.
+func (e *AccessDiffExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	authorizer := e.Authorizer.ForOrg(e.OrgID)
+
+	if e.RoleID != 0 {
+		roleRepo := e.RoleRepo.ForOrg(e.OrgID)
+		for _, id := range []int{e.RoleID, e.ReferenceRoleID} {
+			_, err := getRole(roleRepo, id)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		diff, err := authorizer.DiffRoles(e.RoleID, e.ReferenceRoleID)
+		if err != nil {
+			return nil, err
+		}
+		return &AccessDiffResult{AccessDiff: diff}, nil
+	}
+
+	userRepo := e.UserRepo.ForOrg(e.OrgID)
+	for _, id := range []int{e.UserID, e.ReferenceUserID} {
+		_, err := getMember(userRepo, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &AccessDiffResult{}
+	if e.Apply {
+		missing, err := authorizer.MissingRoles(e.UserID, e.ReferenceUserID)
+		if err != nil {
+			return nil, err
+		}
+
+		assigned := []*repositories.UserRole{}
+		for _, assignment := range missing {
+			assigned = append(assigned, &repositories.UserRole{
+				UserID:     e.UserID,
+				RoleID:     assignment.RoleID,
+				ExpiryDate: assignment.ExpiryDate,
+			})
+		}
+
+		err = e.UserRoleRepo.ForOrg(e.OrgID).CreateAll(assigned)
+		if err != nil {
+			return nil, err
+		}
+		result.Assigned = assigned
+	}
+
+	diff, err := authorizer.DiffUsers(e.UserID, e.ReferenceUserID)
+	if err != nil {
+		return nil, err
+	}
+	result.AccessDiff = diff
+
+	return result, nil
+}
@@ -0,0 +1,547 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/notifier"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Decisions on an access request.
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+)
+
+// AccessRequest defines a struct for the access request workflow. The request ID and the list filters are
+// read from the query, and the rest from the body of POST requests. ActorID is set by the executors to the
+// user the bearer token was issued to.
+type AccessRequest struct {
+	ID            int
+	RoleID        int    `json:"role_id"`
+	Justification string `json:"justification"`
+	DurationHours int    `json:"duration_hours"`
+	Decision      string `json:"decision"`
+	Comment       string `json:"comment"`
+	UserID        int    `json:"-"`
+	State         string `json:"-"`
+	Approver      bool   `json:"-"`
+	ActorID       int    `json:"-"`
+	OrgID         int    `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the AccessRequest object.
+func (ar *AccessRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the AccessRequest object
+		if len(body) > 0 {
+			err = json.Unmarshal(body, ar)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	query := r.URL.Query()
+
+	if id := query.Get("id"); id != "" {
+		i, err := strconv.Atoi(id)
+		if err != nil {
+			return errors.New("invalid id in query")
+		}
+		ar.ID = i
+	}
+
+	if userID := query.Get("user_id"); userID != "" {
+		i, err := strconv.Atoi(userID)
+		if err != nil {
+			return errors.New("invalid user_id in query")
+		}
+		ar.UserID = i
+	}
+
+	if roleID := query.Get("role_id"); roleID != "" {
+		i, err := strconv.Atoi(roleID)
+		if err != nil {
+			return errors.New("invalid role_id in query")
+		}
+		ar.RoleID = i
+	}
+
+	ar.State = query.Get("state")
+	ar.Approver = query.Get("approver") == "true"
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	ar.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the AccessRequest object and returns any errors that occur during validation.
+func (ar *AccessRequest) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// validateID validates that a request ID is given.
+func (ar *AccessRequest) validateID() error {
+	if ar.ID == 0 {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// getAccessRequest retrieves an access request by ID, reporting a missing request as a validation error.
+func getAccessRequest(repo *repositories.AccessRequestRepository, id int) (*repositories.AccessRequest, error) {
+	req, err := repo.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("access request not found")
+	}
+	return req, err
+}
+
+// DefaultMaxRequestHours is the longest duration a role can be requested for unless configured otherwise.
+const DefaultMaxRequestHours = 24 * 90
+
+// CreateAccessRequestExecutor defines an APIExecutor for requesting a role. MaxDurationHours is the
+// longest duration a role can be requested for.
+type CreateAccessRequestExecutor struct {
+	AccessRequest
+	clienthelper.BaseAPIExecutor
+	UserRepo         repositories.UserRepository
+	RoleRepo         repositories.RoleRepository
+	UserRoleRepo     repositories.UserRoleRepository
+	ApproverRepo     repositories.RoleApproverRepository
+	RequestRepo      repositories.AccessRequestRepository
+	Policy           *TokenPolicy
+	MaxDurationHours int
+}
+
+// NewCreateAccessRequestExecutor returns a new instance of CreateAccessRequestExecutor. A maxDurationHours
+// of zero or less uses DefaultMaxRequestHours.
+func NewCreateAccessRequestExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, userRoleRepo repositories.UserRoleRepository, approverRepo repositories.RoleApproverRepository, requestRepo repositories.AccessRequestRepository, policy *TokenPolicy, maxDurationHours int) clienthelper.APIExecutor {
+	if maxDurationHours <= 0 {
+		maxDurationHours = DefaultMaxRequestHours
+	}
+
+	return &CreateAccessRequestExecutor{
+		UserRepo:         userRepo,
+		RoleRepo:         roleRepo,
+		UserRoleRepo:     userRoleRepo,
+		ApproverRepo:     approverRepo,
+		RequestRepo:      requestRepo,
+		Policy:           policy,
+		MaxDurationHours: maxDurationHours,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *CreateAccessRequestExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.AccessRequest.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that a role, a justification and a duration of at most MaxDurationHours are
+// given. Roles are always requested for a limited time; permanent assignments are made by administrators.
+func (e *CreateAccessRequestExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if e.Justification == "" {
+		return errors.New("justification is required")
+	}
+
+	if e.DurationHours <= 0 {
+		return errors.New("duration_hours must be greater than zero")
+	}
+
+	if e.DurationHours > e.MaxDurationHours {
+		return fmt.Errorf("duration_hours must not be greater than %d", e.MaxDurationHours)
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for requesting a role and returns the pending request
+// and any errors that occur during execution. Only roles with approvers can be requested, and a user
+// may not request a role they hold or have a pending request for.
+func (e *CreateAccessRequestExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getMember(e.UserRepo.ForOrg(e.OrgID), e.ActorID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	approvers, err := e.ApproverRepo.ForOrg(e.OrgID).GetForRole(e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	if len(approvers) == 0 {
+		return nil, errors.New("role has no approvers and cannot be requested")
+	}
+
+	_, err = e.UserRoleRepo.ForOrg(e.OrgID).Get(e.ActorID, e.RoleID)
+	if err == nil {
+		return nil, errors.New("role is already assigned to the user")
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	requestRepo := e.RequestRepo.ForOrg(e.OrgID)
+	pending, err := requestRepo.GetAll(repositories.AccessRequestFilter{UserID: e.ActorID, RoleID: e.RoleID, State: repositories.RequestPending})
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		return nil, errors.New("a request for the role is already pending")
+	}
+
+	req := &repositories.AccessRequest{
+		UserID:        e.ActorID,
+		RoleID:        e.RoleID,
+		Justification: e.Justification,
+		DurationHours: e.DurationHours,
+	}
+	err = requestRepo.Create(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return getAccessRequest(requestRepo, req.ID)
+}
+
+// AccessRequestDetail defines the response of the get access request endpoint for a single request.
+type AccessRequestDetail struct {
+	*repositories.AccessRequest
+	Events []*repositories.AccessRequestEvent `json:"events"`
+}
+
+// GetAccessRequestsExecutor defines an APIExecutor for querying access requests.
+type GetAccessRequestsExecutor struct {
+	AccessRequest
+	clienthelper.BaseAPIExecutor
+	ApproverRepo repositories.RoleApproverRepository
+	RequestRepo  repositories.AccessRequestRepository
+	Policy       *TokenPolicy
+}
+
+// NewGetAccessRequestsExecutor returns a new instance of GetAccessRequestsExecutor.
+func NewGetAccessRequestsExecutor(approverRepo repositories.RoleApproverRepository, requestRepo repositories.AccessRequestRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &GetAccessRequestsExecutor{
+		ApproverRepo: approverRepo,
+		RequestRepo:  requestRepo,
+		Policy:       policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *GetAccessRequestsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.AccessRequest.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// Controller executes the business logic for querying access requests and any errors that occur during
+// execution. With an ID, it returns the request with its history of state changes and comments. With
+// approver=true, it returns the pending requests the caller may decide. Otherwise it returns the requests
+// matching the user_id, role_id and state filters, newest first. Only the requester and the approvers of
+// the role of a request can see it.
+func (e *GetAccessRequestsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	requestRepo := e.RequestRepo.ForOrg(e.OrgID)
+	approverRepo := e.ApproverRepo.ForOrg(e.OrgID)
+
+	if e.ID != 0 {
+		req, err := getAccessRequest(requestRepo, e.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed, err := canSeeAccessRequest(approverRepo, req, e.ActorID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errors.New("only the requester and approvers of the role can see the request")
+		}
+
+		events, err := requestRepo.GetEvents(e.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &AccessRequestDetail{AccessRequest: req, Events: events}, nil
+	}
+
+	if e.Approver {
+		return requestRepo.GetPendingForApprover(e.ActorID)
+	}
+
+	reqs, err := requestRepo.GetAll(repositories.AccessRequestFilter{UserID: e.UserID, RoleID: e.RoleID, State: e.State})
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests of other users are listed only for the roles the caller approves
+	approves := map[int]bool{}
+	visible := []*repositories.AccessRequest{}
+	for _, req := range reqs {
+		if req.UserID != e.ActorID {
+			allowed, ok := approves[req.RoleID]
+			if !ok {
+				allowed, err = canSeeAccessRequest(approverRepo, req, e.ActorID)
+				if err != nil {
+					return nil, err
+				}
+				approves[req.RoleID] = allowed
+			}
+			if !allowed {
+				continue
+			}
+		}
+		visible = append(visible, req)
+	}
+
+	return visible, nil
+}
+
+// canSeeAccessRequest reports whether a user may see and comment on a request, being its requester or
+// an approver of its role.
+func canSeeAccessRequest(approverRepo *repositories.RoleApproverRepository, req *repositories.AccessRequest, userID int) (bool, error) {
+	if req.UserID == userID {
+		return true, nil
+	}
+	return approverRepo.IsApprover(req.RoleID, userID)
+}
+
+// DecideAccessRequestExecutor defines an APIExecutor for approving or rejecting an access request.
+type DecideAccessRequestExecutor struct {
+	AccessRequest
+	clienthelper.BaseAPIExecutor
+	ApproverRepo repositories.RoleApproverRepository
+	RequestRepo  repositories.AccessRequestRepository
+	Notifier     notifier.Notifier
+	Policy       *TokenPolicy
+}
+
+// NewDecideAccessRequestExecutor returns a new instance of DecideAccessRequestExecutor. Approvals are
+// reported to the security channel of n, which may be nil.
+func NewDecideAccessRequestExecutor(approverRepo repositories.RoleApproverRepository, requestRepo repositories.AccessRequestRepository, n notifier.Notifier, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &DecideAccessRequestExecutor{
+		ApproverRepo: approverRepo,
+		RequestRepo:  requestRepo,
+		Notifier:     n,
+		Policy:       policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *DecideAccessRequestExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.AccessRequest.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that a request and a decision are given.
+func (e *DecideAccessRequestExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Decision != DecisionApprove && e.Decision != DecisionReject {
+		return errors.New("decision must be approve or reject")
+	}
+	return e.validateID()
+}
+
+// Controller executes the business logic for deciding an access request and returns the decided request
+// and any errors that occur during execution. Only approvers of the role may decide, and never on their
+// own requests. Approval assigns the role to the requester, expiring after the requested duration, and is
+// reported to the security channel like a role activation.
+func (e *DecideAccessRequestExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	requestRepo := e.RequestRepo.ForOrg(e.OrgID)
+	req, err := getAccessRequest(requestRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.UserID == e.ActorID {
+		return nil, errors.New("requesters cannot decide their own requests")
+	}
+
+	approver, err := e.ApproverRepo.ForOrg(e.OrgID).IsApprover(req.RoleID, e.ActorID)
+	if err != nil {
+		return nil, err
+	}
+	if !approver {
+		return nil, errors.New("only approvers of the role can decide the request")
+	}
+
+	if e.Decision == DecisionReject {
+		_, err = requestRepo.Reject(e.ID, e.ActorID, e.Comment)
+		if err != nil {
+			return nil, err
+		}
+		return getAccessRequest(requestRepo, e.ID)
+	}
+
+	approved, err := requestRepo.Approve(e.ID, e.ActorID, e.Comment)
+	if err != nil {
+		return nil, err
+	}
+
+	notifySecurity(e.Notifier, "Role activated", fmt.Sprintf("User %s was granted role %s until %s by approval of request %d: %s", req.UserName, req.RoleName, formatNotificationTime(approved.ExpiryDate), req.ID, req.Justification))
+
+	return getAccessRequest(requestRepo, e.ID)
+}
+
+// CancelAccessRequestExecutor defines an APIExecutor for withdrawing an access request.
+type CancelAccessRequestExecutor struct {
+	AccessRequest
+	clienthelper.BaseAPIExecutor
+	RequestRepo repositories.AccessRequestRepository
+	Policy      *TokenPolicy
+}
+
+// NewCancelAccessRequestExecutor returns a new instance of CancelAccessRequestExecutor.
+func NewCancelAccessRequestExecutor(requestRepo repositories.AccessRequestRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &CancelAccessRequestExecutor{
+		RequestRepo: requestRepo,
+		Policy:      policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *CancelAccessRequestExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.AccessRequest.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that a request is given.
+func (e *CancelAccessRequestExecutor) ValidateRequest(ctx context.IContext) error {
+	return e.validateID()
+}
+
+// Controller executes the business logic for withdrawing an access request and returns the cancelled
+// request and any errors that occur during execution. Only the requester may withdraw a request.
+func (e *CancelAccessRequestExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	requestRepo := e.RequestRepo.ForOrg(e.OrgID)
+	req, err := getAccessRequest(requestRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.UserID != e.ActorID {
+		return nil, errors.New("only the requester can cancel the request")
+	}
+
+	_, err = requestRepo.Cancel(e.ID, e.ActorID, e.Comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return getAccessRequest(requestRepo, e.ID)
+}
+
+// CommentAccessRequestExecutor defines an APIExecutor for commenting on an access request.
+type CommentAccessRequestExecutor struct {
+	AccessRequest
+	clienthelper.BaseAPIExecutor
+	ApproverRepo repositories.RoleApproverRepository
+	RequestRepo  repositories.AccessRequestRepository
+	Policy       *TokenPolicy
+}
+
+// NewCommentAccessRequestExecutor returns a new instance of CommentAccessRequestExecutor.
+func NewCommentAccessRequestExecutor(approverRepo repositories.RoleApproverRepository, requestRepo repositories.AccessRequestRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &CommentAccessRequestExecutor{
+		ApproverRepo: approverRepo,
+		RequestRepo:  requestRepo,
+		Policy:       policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *CommentAccessRequestExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.AccessRequest.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that a request and a comment are given.
+func (e *CommentAccessRequestExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Comment == "" {
+		return errors.New("comment is required")
+	}
+	return e.validateID()
+}
+
+// Controller executes the business logic for commenting on an access request and returns the request with
+// its history and any errors that occur during execution. The requester and the approvers of the role may
+// comment, also after the request is decided.
+func (e *CommentAccessRequestExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	requestRepo := e.RequestRepo.ForOrg(e.OrgID)
+	req, err := getAccessRequest(requestRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := canSeeAccessRequest(e.ApproverRepo.ForOrg(e.OrgID), req, e.ActorID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errors.New("only the requester and approvers of the role can comment")
+	}
+
+	err = requestRepo.Comment(e.ID, e.ActorID, e.Comment)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := requestRepo.GetEvents(e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessRequestDetail{AccessRequest: req, Events: events}, nil
+}
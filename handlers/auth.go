@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/princeparmar/contact_manager/ratelimit"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+	"github.com/princeparmar/go-helpers/utils"
+)
+
+// Authentication method references carried in the "amr" claim.
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+)
+
+// MFAVerifier verifies a second factor code for a user.
+type MFAVerifier interface {
+	Verify(userID int, code string) (bool, error)
+}
+
+// bearerToken returns the token from the Authorization header of the request.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// authenticate verifies the bearer token of the request and returns its claims.
+func authenticate(r *http.Request, policy *TokenPolicy) (jwt.MapClaims, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errors.New("authorization token is required")
+	}
+	return policy.ParseToken(token)
+}
+
+// authenticatedUserID verifies the bearer token of the request and returns the ID of the user it was issued to.
+func authenticatedUserID(r *http.Request, policy *TokenPolicy) (int, error) {
+	claims, err := authenticate(r, policy)
+	if err != nil {
+		return 0, err
+	}
+	return claimsUserID(claims)
+}
+
+// claimsUserID returns the ID of the user the verified claims were issued to.
+func claimsUserID(claims jwt.MapClaims) (int, error) {
+	userID, _ := claims["user_id"].(float64)
+	if userID == 0 {
+		return 0, errors.New("token has no user_id")
+	}
+	return int(userID), nil
+}
+
+// StepUp defines how recently and with which factor the caller of a sensitive operation must
+// have authenticated. A zero MaxAge or empty Factor is not checked.
+type StepUp struct {
+	MaxAge time.Duration
+	Factor string
+}
+
+// StepUpError is returned when the caller has to re-authenticate before retrying the request.
+type StepUpError struct {
+	Reason string
+}
+
+func (e *StepUpError) Error() string {
+	return "re-authentication required: " + e.Reason
+}
+
+// StatusCode returns the HTTP status code for the error.
+func (e *StepUpError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+// Check returns a *StepUpError when the claims do not satisfy the requirement.
+func (s StepUp) Check(claims jwt.MapClaims) error {
+	if s.MaxAge > 0 {
+		authTime, ok := claims["auth_time"].(float64)
+		if !ok {
+			return &StepUpError{Reason: "token has no auth_time"}
+		}
+		if time.Since(time.Unix(int64(authTime), 0)) > s.MaxAge {
+			return &StepUpError{Reason: "authentication is too old"}
+		}
+	}
+
+	if s.Factor != "" && !hasAMR(claims, s.Factor) {
+		return &StepUpError{Reason: fmt.Sprintf("authentication with %s is required", s.Factor)}
+	}
+
+	return nil
+}
+
+// Authorize authenticates the request and checks the requirement. When re-authentication is needed it
+// sets the WWW-Authenticate challenge on the response.
+func (s StepUp) Authorize(w http.ResponseWriter, r *http.Request, policy *TokenPolicy) error {
+	claims, err := authenticate(r, policy)
+	if err != nil {
+		return err
+	}
+
+	err = s.Check(claims)
+	var stepUpErr *StepUpError
+	if errors.As(err, &stepUpErr) {
+		challenge := `Bearer error="insufficient_user_authentication"`
+		if s.MaxAge > 0 {
+			challenge += fmt.Sprintf(", max_age=%d", int(s.MaxAge.Seconds()))
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+
+	return err
+}
+
+// hasAMR reports whether the "amr" claim contains method.
+func hasAMR(claims jwt.MapClaims, method string) bool {
+	amr, _ := claims["amr"].([]interface{})
+	for _, m := range amr {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Reauthenticate defines a struct for re-authenticating within an existing session.
+type Reauthenticate struct {
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+
+	Claims   jwt.MapClaims      `json:"-"`
+	User     *repositories.User `json:"-"`
+	ClientIP string             `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Reauthenticate object.
+func (ra *Reauthenticate) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the Reauthenticate object
+	err = json.Unmarshal(body, ra)
+	if err != nil {
+		return err
+	}
+
+	ra.ClientIP = clientIP(r)
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Reauthenticate object and returns any errors that occur during validation.
+func (ra *Reauthenticate) ValidateRequest(ctx context.IContext) error {
+	if ra.Password == "" {
+		return errors.New("password field is required")
+	}
+	return nil
+}
+
+// ReauthenticateExecutor defines an APIExecutor for refreshing auth_time without starting a new session.
+type ReauthenticateExecutor struct {
+	Reauthenticate
+	clienthelper.BaseAPIExecutor
+	UserRepo repositories.UserRepository
+	Issuer   *TokenIssuer
+	MFA      MFAVerifier
+	Guard    *ratelimit.LoginGuard
+}
+
+// NewReauthenticateExecutor returns a new instance of ReauthenticateExecutor. The MFA verifier
+// may be nil when second factors are not supported. The guard is the one of the login endpoint, so
+// that password guesses share its limits; it may be nil to disable rate limiting.
+func NewReauthenticateExecutor(userRepo repositories.UserRepository, issuer *TokenIssuer, mfa MFAVerifier, guard *ratelimit.LoginGuard) clienthelper.APIExecutor {
+	return &ReauthenticateExecutor{
+		UserRepo: userRepo,
+		Issuer:   issuer,
+		MFA:      mfa,
+		Guard:    guard,
+	}
+}
+
+// ParseRequest parses the request body and the bearer token of the session being re-authenticated,
+// and rejects it with 429 Too Many Requests when the client IP or the account is over its rate limit.
+func (e *ReauthenticateExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.Reauthenticate.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.Claims, err = authenticate(r, e.Issuer.Policy)
+	if err != nil {
+		return err
+	}
+
+	userID, err := claimsUserID(e.Claims)
+	if err != nil {
+		return err
+	}
+
+	e.User, err = e.UserRepo.Get(userID)
+	if err != nil {
+		return err
+	}
+	if e.User == nil {
+		return errors.New("invalid user id")
+	}
+
+	if e.Guard == nil {
+		return nil
+	}
+
+	err = e.Guard.Check(e.ClientIP, e.User.UserName)
+	var limitErr *ratelimit.Error
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(limitErr.RetryAfterSeconds()))
+	}
+
+	return err
+}
+
+// Controller executes the business logic for re-authentication and returns a new access token
+// and any errors that occur during execution.
+func (e *ReauthenticateExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	sessionID, _ := e.Claims["sid"].(string)
+
+	password, err := e.UserRepo.GetPassword(e.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if password != utils.MD5Hash(e.Password) {
+		e.failed()
+		return nil, errors.New("invalid password")
+	}
+
+	amr := []string{AMRPassword}
+	if e.OTP != "" {
+		if e.MFA == nil {
+			return nil, errors.New("second factor is not supported")
+		}
+
+		ok, err := e.MFA.Verify(e.User.ID, e.OTP)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			e.failed()
+			return nil, errors.New("invalid otp")
+		}
+
+		amr = append(amr, AMROTP, AMRMFA)
+	}
+
+	if e.Guard != nil {
+		e.Guard.Succeeded(e.ClientIP, e.User.UserName)
+	}
+
+	return e.Issuer.Reauthenticate(e.User, sessionID, amr)
+}
+
+// failed records a failed re-authentication attempt with the guard.
+func (e *ReauthenticateExecutor) failed() {
+	if e.Guard != nil {
+		e.Guard.Failed(e.ClientIP, e.User.UserName)
+	}
+}
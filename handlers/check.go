@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/authz"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Check defines a struct for an authorization decision request. The principal is given either
+// by user ID or by username; a single access may be given instead of a batch. With a resource, the
+// roles bound to the user on the resource or its ancestors are considered too. Attributes describe the
+// request, such as an amount or the client IP, and are what conditional grants are evaluated against.
+type Check struct {
+	UserID     int                       `json:"user_id"`
+	UserName   string                    `json:"username"`
+	Access     string                    `json:"access"`
+	Accesses   []string                  `json:"accesses"`
+	Resource   *repositories.ResourceRef `json:"resource"`
+	Attributes map[string]interface{}    `json:"attributes"`
+	OrgID      int                       `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Check object.
+func (c *Check) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the Check object
+	err = json.Unmarshal(body, c)
+	if err != nil {
+		return err
+	}
+
+	if c.Access != "" {
+		c.Accesses = append(c.Accesses, c.Access)
+	}
+
+	c.OrgID, err = orgID(r)
+	return err
+}
+
+// ValidateRequest validates the data in the Check object and returns any errors that occur during validation.
+func (c *Check) ValidateRequest(ctx context.IContext) error {
+	if c.UserID == 0 && c.UserName == "" {
+		return errors.New("user_id or username is required")
+	}
+
+	if len(c.Accesses) == 0 {
+		return errors.New("access or accesses is required")
+	}
+
+	return validateResourceRef(c.Resource)
+}
+
+// validateResourceRef validates an optional resource reference.
+func validateResourceRef(ref *repositories.ResourceRef) error {
+	if ref != nil && (ref.Type == "" || ref.ID == "") {
+		return errors.New("resource type and id are required")
+	}
+	return nil
+}
+
+// resolvePrincipal returns the ID of the user named by user ID or username. With a repository scoped
+// to an organization, users outside the organization are not found.
+func resolvePrincipal(repo *repositories.UserRepository, userID int, userName string) (int, error) {
+	if userID == 0 {
+		user, err := repo.GetUserByUserName(userName)
+		if err != nil {
+			return 0, err
+		}
+		userID = user.ID
+	}
+
+	user, err := repo.Get(userID)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, errors.New("user not found")
+	}
+	return user.ID, nil
+}
+
+// CheckResult defines the response of the check endpoint.
+type CheckResult struct {
+	UserID    int                       `json:"user_id"`
+	Resource  *repositories.ResourceRef `json:"resource,omitempty"`
+	Decisions []*authz.Decision         `json:"decisions"`
+}
+
+// CheckExecutor defines an APIExecutor for deciding whether a user holds one or many accesses.
+type CheckExecutor struct {
+	Check
+	clienthelper.BaseAPIExecutor
+	UserRepo   repositories.UserRepository
+	Authorizer *authz.Authorizer
+}
+
+// NewCheckExecutor returns a new instance of CheckExecutor.
+func NewCheckExecutor(userRepo repositories.UserRepository, authorizer *authz.Authorizer) clienthelper.APIExecutor {
+	return &CheckExecutor{
+		UserRepo:   userRepo,
+		Authorizer: authorizer,
+	}
+}
+
+// Controller executes the business logic for the authorization check and returns a decision for each access
+// and any errors that occur during execution.
+func (e *CheckExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	userID, err := resolvePrincipal(e.UserRepo.ForOrg(e.OrgID), e.UserID, e.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions, err := e.Authorizer.ForOrg(e.OrgID).Check(userID, e.Resource, e.Attributes, e.Accesses)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckResult{
+		UserID:    userID,
+		Resource:  e.Resource,
+		Decisions: decisions,
+	}, nil
+}
+
+// Explain defines a struct for a permission explanation request, optionally on a resource and with the
+// request attributes that conditions on the way are evaluated against.
+type Explain struct {
+	UserID     int                       `json:"user_id"`
+	UserName   string                    `json:"username"`
+	Access     string                    `json:"access"`
+	Resource   *repositories.ResourceRef `json:"resource"`
+	Attributes map[string]interface{}    `json:"attributes"`
+	OrgID      int                       `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Explain object.
+func (ex *Explain) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the Explain object
+	err = json.Unmarshal(body, ex)
+	if err != nil {
+		return err
+	}
+
+	ex.OrgID, err = orgID(r)
+	return err
+}
+
+// ValidateRequest validates the data in the Explain object and returns any errors that occur during validation.
+func (ex *Explain) ValidateRequest(ctx context.IContext) error {
+	if ex.UserID == 0 && ex.UserName == "" {
+		return errors.New("user_id or username is required")
+	}
+
+	if ex.Access == "" {
+		return errors.New("access is required")
+	}
+
+	return validateResourceRef(ex.Resource)
+}
+
+// ExplainExecutor defines an APIExecutor for explaining why a user does or does not hold an access.
+type ExplainExecutor struct {
+	Explain
+	clienthelper.BaseAPIExecutor
+	UserRepo   repositories.UserRepository
+	Authorizer *authz.Authorizer
+}
+
+// NewExplainExecutor returns a new instance of ExplainExecutor.
+func NewExplainExecutor(userRepo repositories.UserRepository, authorizer *authz.Authorizer) clienthelper.APIExecutor {
+	return &ExplainExecutor{
+		UserRepo:   userRepo,
+		Authorizer: authorizer,
+	}
+}
+
+// Controller executes the business logic for explaining an access and returns the explanation
+// and any errors that occur during execution.
+func (e *ExplainExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	userID, err := resolvePrincipal(e.UserRepo.ForOrg(e.OrgID), e.UserID, e.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Authorizer.ForOrg(e.OrgID).Explain(userID, e.Resource, e.Attributes, e.Access)
+}
+
+// Page sizes of the access holders endpoint.
+const (
+	DefaultHolderLimit = 50
+	MaxHolderLimit     = 500
+)
+
+// AccessHolders defines a struct for a reverse lookup of the users holding an access. The access and the
+// page are read from the access, offset and limit query parameters.
+type AccessHolders struct {
+	Access string
+	Offset int
+	Limit  int
+	OrgID  int
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the AccessHolders object.
+func (ah *AccessHolders) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	ah.Access = query.Get("access")
+
+	if offset := query.Get("offset"); offset != "" {
+		i, err := strconv.Atoi(offset)
+		if err != nil {
+			return errors.New("invalid offset in query")
+		}
+		ah.Offset = i
+	}
+
+	ah.Limit = DefaultHolderLimit
+	if limit := query.Get("limit"); limit != "" {
+		i, err := strconv.Atoi(limit)
+		if err != nil {
+			return errors.New("invalid limit in query")
+		}
+		ah.Limit = i
+	}
+
+	var err error
+	ah.OrgID, err = orgID(r)
+	return err
+}
+
+// ValidateRequest validates the data in the AccessHolders object and returns any errors that occur during validation.
+func (ah *AccessHolders) ValidateRequest(ctx context.IContext) error {
+	if ah.Access == "" {
+		return errors.New("access is required")
+	}
+
+	if ah.Offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+
+	if ah.Limit <= 0 || ah.Limit > MaxHolderLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxHolderLimit)
+	}
+
+	return nil
+}
+
+// GetAccessHoldersExecutor defines an APIExecutor for listing the users holding an access.
+type GetAccessHoldersExecutor struct {
+	AccessHolders
+	clienthelper.BaseAPIExecutor
+	Authorizer *authz.Authorizer
+}
+
+// NewGetAccessHoldersExecutor returns a new instance of GetAccessHoldersExecutor.
+func NewGetAccessHoldersExecutor(authorizer *authz.Authorizer) clienthelper.APIExecutor {
+	return &GetAccessHoldersExecutor{
+		Authorizer: authorizer,
+	}
+}
+
+// Controller executes the business logic for the reverse lookup and returns a page of the members holding
+// the access, each with the role and group paths granting it, and any errors that occur during execution.
+func (e *GetAccessHoldersExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.Authorizer.ForOrg(e.OrgID).Holders(e.Access, e.Offset, e.Limit)
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/princeparmar/contact_manager/authz"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// ClaimFormat defines how the accesses of a user are encoded in the login token.
+type ClaimFormat string
+
+const (
+	// ClaimFormatFull embeds the access objects in the "access" claim.
+	ClaimFormatFull ClaimFormat = "full"
+	// ClaimFormatScope embeds the access names as a space-separated "scope" claim.
+	ClaimFormatScope ClaimFormat = "scope"
+	// ClaimFormatBitmap embeds a compressed bitmap keyed by access ID in the "access_bitmap" claim,
+	// along with the version of the access mapping it was built from in "access_map_ver".
+	ClaimFormatBitmap ClaimFormat = "bitmap"
+	// ClaimFormatThin embeds no accesses; services resolve them through the introspection endpoint.
+	ClaimFormatThin ClaimFormat = "thin"
+)
+
+// ParseClaimFormat validates a claim format read from configuration. An empty value selects ClaimFormatFull.
+func ParseClaimFormat(s string) (ClaimFormat, error) {
+	switch f := ClaimFormat(s); f {
+	case "":
+		return ClaimFormatFull, nil
+	case ClaimFormatFull, ClaimFormatScope, ClaimFormatBitmap, ClaimFormatThin:
+		return f, nil
+	}
+
+	return "", fmt.Errorf("unknown claim format %q", s)
+}
+
+// accessClaims returns the token claims that carry the given accesses in the given format.
+func accessClaims(format ClaimFormat, accesses []*repositories.Access, accessRepo repositories.AccessRepository) (jwt.MapClaims, error) {
+	switch format {
+	case "", ClaimFormatFull:
+		return jwt.MapClaims{"access": accesses}, nil
+
+	case ClaimFormatScope:
+		names := make([]string, 0, len(accesses))
+		for _, a := range accesses {
+			names = append(names, a.Name)
+		}
+		return jwt.MapClaims{"scope": strings.Join(names, " ")}, nil
+
+	case ClaimFormatBitmap:
+		all, err := accessRepo.GetAll()
+		if err != nil {
+			return nil, err
+		}
+
+		bitmap, err := EncodeAccessBitmap(accesses)
+		if err != nil {
+			return nil, err
+		}
+
+		return jwt.MapClaims{
+			"access_bitmap":  bitmap,
+			"access_map_ver": accessMappingVersion(all),
+		}, nil
+
+	case ClaimFormatThin:
+		return jwt.MapClaims{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown claim format %q", format)
+}
+
+// EncodeAccessBitmap sets bit N for every access with ID N, deflates the bitmap and returns it
+// base64url encoded without padding.
+func EncodeAccessBitmap(accesses []*repositories.Access) (string, error) {
+	max := -1
+	for _, a := range accesses {
+		if a.ID > max {
+			max = a.ID
+		}
+	}
+
+	bitmap := make([]byte, max/8+1)
+	for _, a := range accesses {
+		if a.ID >= 0 {
+			bitmap[a.ID/8] |= 1 << uint(a.ID%8)
+		}
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = fw.Write(bitmap)
+	if err != nil {
+		return "", err
+	}
+
+	err = fw.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeAccessBitmap reverses EncodeAccessBitmap and returns the access IDs set in the bitmap.
+func DecodeAccessBitmap(s string) ([]int, error) {
+	compressed, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	bitmap, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for i, b := range bitmap {
+		for bit := 0; bit < 8; bit++ {
+			if b&(1<<uint(bit)) != 0 {
+				ids = append(ids, i*8+bit)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// accessMappingVersion returns a short hash identifying the current access ID to name mapping.
+func accessMappingVersion(accesses []*repositories.Access) string {
+	sorted := make([]*repositories.Access, len(accesses))
+	copy(sorted, accesses)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	h := sha256.New()
+	for _, a := range sorted {
+		h.Write([]byte(strconv.Itoa(a.ID) + ":" + a.Name + "\n"))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// AccessMapping defines the published mapping used to decode bitmap access claims.
+type AccessMapping struct {
+	Version  string         `json:"version"`
+	Accesses map[int]string `json:"accesses"`
+}
+
+// AccessMappingExecutor defines an APIExecutor for publishing the access ID to name mapping.
+type AccessMappingExecutor struct {
+	clienthelper.BaseAPIExecutor
+	AccessRepo repositories.AccessRepository
+}
+
+// NewAccessMappingExecutor returns a new instance of AccessMappingExecutor.
+func NewAccessMappingExecutor(repo repositories.AccessRepository) clienthelper.APIExecutor {
+	return &AccessMappingExecutor{
+		AccessRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting the access mapping and returns the mapping
+// and any errors that occur during execution.
+func (e *AccessMappingExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	accesses, err := e.AccessRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := &AccessMapping{
+		Version:  accessMappingVersion(accesses),
+		Accesses: map[int]string{},
+	}
+	for _, a := range accesses {
+		mapping.Accesses[a.ID] = a.Name
+	}
+
+	return mapping, nil
+}
+
+// Introspect defines a struct for token introspection.
+type Introspect struct {
+	Token string `json:"token"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Introspect object.
+func (i *Introspect) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the Introspect object
+	return json.Unmarshal(body, i)
+}
+
+// ValidateRequest validates the data in the Introspect object and returns any errors that occur during validation.
+func (i *Introspect) ValidateRequest(ctx context.IContext) error {
+	if i.Token == "" {
+		return errors.New("token field is required")
+	}
+	return nil
+}
+
+// IntrospectionResult defines the response of the introspection endpoint. Deny lists the exceptions carved
+// out of the granted accesses, as in the deny claim of a token, and Conditional the accesses that depend on
+// request attributes and must be decided by the check API.
+type IntrospectionResult struct {
+	Active      bool     `json:"active"`
+	UserID      int      `json:"user_id,omitempty"`
+	UserName    string   `json:"username,omitempty"`
+	OrgID       int      `json:"org_id,omitempty"`
+	Exp         int64    `json:"exp,omitempty"`
+	Access      []string `json:"access,omitempty"`
+	Deny        []string `json:"deny,omitempty"`
+	Conditional []string `json:"conditional,omitempty"`
+}
+
+// IntrospectExecutor defines an APIExecutor for resolving the live accesses behind a token,
+// used by services that receive thin tokens.
+type IntrospectExecutor struct {
+	Introspect
+	clienthelper.BaseAPIExecutor
+	Authorizer *authz.Authorizer
+	Policy     *TokenPolicy
+}
+
+// NewIntrospectExecutor returns a new instance of IntrospectExecutor.
+func NewIntrospectExecutor(authorizer *authz.Authorizer, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &IntrospectExecutor{
+		Authorizer: authorizer,
+		Policy:     policy,
+	}
+}
+
+// Controller executes the business logic for token introspection and returns the introspection result
+// and any errors that occur during execution. Invalid or expired tokens are reported as inactive.
+func (e *IntrospectExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	claims, err := e.Policy.ParseToken(e.Token)
+	if err != nil {
+		return &IntrospectionResult{Active: false}, nil
+	}
+
+	userID, _ := claims["user_id"].(float64)
+	userName, _ := claims["username"].(string)
+	orgID, _ := claims["org_id"].(float64)
+	exp, _ := claims["exp"].(float64)
+
+	result := &IntrospectionResult{
+		Active:   true,
+		UserID:   int(userID),
+		UserName: userName,
+		OrgID:    int(orgID),
+		Exp:      int64(exp),
+		Access:   []string{},
+	}
+
+	authorizer := e.Authorizer.ForOrg(result.OrgID)
+
+	// A user without any access is still an active principal
+	accesses, denies, err := authorizer.EffectiveAccesses(result.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accesses {
+		result.Access = append(result.Access, a.Name)
+	}
+	result.Deny = denies
+
+	result.Conditional, err = authorizer.ConditionalAccesses(result.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
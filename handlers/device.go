@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/princeparmar/contact_manager/notifier"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// DeviceCookie is the name of the long-lived cookie identifying a device. Clients without
+// cookie support send the same value in the X-Device-ID header.
+const DeviceCookie = "device_id"
+
+// deviceCookieMaxAge is how long the device cookie lives in the browser.
+const deviceCookieMaxAge = time.Hour * 24 * 365 * 2
+
+// maxDeviceIDLength is the longest device ID that fits the user_devices table.
+const maxDeviceIDLength = 64
+
+// deviceID returns the device ID sent with the request, issuing a new device cookie when there is none.
+func deviceID(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(DeviceCookie); err == nil && cookie.Value != "" {
+		return validDeviceID(cookie.Value)
+	}
+
+	if id := r.Header.Get("X-Device-ID"); id != "" {
+		return validDeviceID(id)
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(deviceCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return id, nil
+}
+
+// validDeviceID returns id, or an error when it is too long to be stored.
+func validDeviceID(id string) (string, error) {
+	if len(id) > maxDeviceIDLength {
+		return "", fmt.Errorf("device id must not be longer than %d characters", maxDeviceIDLength)
+	}
+	return id, nil
+}
+
+// MFARequiredError is returned by login when a second factor is required for the device.
+type MFARequiredError struct{}
+
+func (e *MFARequiredError) Error() string {
+	return "otp is required to log in from an untrusted device"
+}
+
+// StatusCode returns the HTTP status code for the error.
+func (e *MFARequiredError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+// DeviceTracker keeps the list of devices a user has logged in from, notifies the user about
+// logins from new devices and optionally requires a second factor on untrusted devices.
+type DeviceTracker struct {
+	DeviceRepo repositories.DeviceRepository
+	Notifier   notifier.Notifier
+	MFA        MFAVerifier
+
+	RequireMFAOnUntrusted bool
+}
+
+// NewDeviceTracker returns a new instance of DeviceTracker. The MFA verifier is required when
+// requireMFAOnUntrusted is set.
+func NewDeviceTracker(deviceRepo repositories.DeviceRepository, n notifier.Notifier, mfa MFAVerifier, requireMFAOnUntrusted bool) *DeviceTracker {
+	return &DeviceTracker{
+		DeviceRepo:            deviceRepo,
+		Notifier:              n,
+		MFA:                   mfa,
+		RequireMFAOnUntrusted: requireMFAOnUntrusted,
+	}
+}
+
+// Identify returns the device of user with deviceID and whether the user has logged in from it before.
+// A device is only known when it was last seen with the same user agent; a device cookie presented by a
+// different user agent may have been copied, so it is returned as a new, untrusted device. A new device
+// is returned unsaved; Record saves it once the login has succeeded.
+func (t *DeviceTracker) Identify(user *repositories.User, deviceID, userAgent string) (*repositories.Device, bool, error) {
+	device, err := t.DeviceRepo.Get(user.ID, deviceID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if device != nil && device.UserAgent == userAgent {
+		return device, true, nil
+	}
+
+	return &repositories.Device{
+		ID:        deviceID,
+		Handle:    repositories.DeviceHandle(deviceID),
+		UserID:    user.ID,
+		UserAgent: userAgent,
+	}, false, nil
+}
+
+// VerifySecondFactor checks the otp for a login from device and returns the authentication methods
+// used. It returns *MFARequiredError when the device needs a second factor and none was given. With
+// trustDevice and a valid otp, the device is marked trusted; Record saves it.
+func (t *DeviceTracker) VerifySecondFactor(user *repositories.User, device *repositories.Device, otp string, trustDevice bool) ([]string, error) {
+	amr := []string{AMRPassword}
+
+	if otp == "" {
+		if t.RequireMFAOnUntrusted && !device.Trusted {
+			return nil, &MFARequiredError{}
+		}
+		return amr, nil
+	}
+
+	if t.MFA == nil {
+		return nil, errors.New("second factor is not supported")
+	}
+
+	ok, err := t.MFA.Verify(user.ID, otp)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid otp")
+	}
+
+	if trustDevice {
+		device.Trusted = true
+	}
+
+	return append(amr, AMROTP, AMRMFA), nil
+}
+
+// Record records a successful login of user from device. The user is notified about a device never seen
+// before, and the device is only saved once the notification has gone out, so that a failed notification
+// is retried on the next login instead of being lost.
+func (t *DeviceTracker) Record(user *repositories.User, device *repositories.Device, known bool, clientIP string) error {
+	if known {
+		return t.DeviceRepo.Touch(device)
+	}
+
+	if t.Notifier != nil {
+		err := t.Notifier.Notify(&notifier.Notification{
+			UserID:  user.ID,
+			Subject: "New device login",
+			Message: fmt.Sprintf("Your account %s was used to log in from a new device (%s, %s).", user.UserName, device.UserAgent, clientIP),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.DeviceRepo.Create(device)
+}
+
+// UserDevice defines a struct for a device of the caller, identified by its handle. ActorID is set by the
+// executors to the user the bearer token was issued to, whose devices are managed.
+type UserDevice struct {
+	Handle  string
+	Trusted bool `json:"trusted"`
+	ActorID int  `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the UserDevice object.
+func (d *UserDevice) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the UserDevice object
+		err = json.Unmarshal(body, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.Handle = r.URL.Query().Get("device")
+	return nil
+}
+
+// ValidateRequest validates the data in the UserDevice object and returns any errors that occur during validation.
+func (d *UserDevice) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getDevice retrieves the device of a user with handle, reporting a device the user has never logged in from
+// as a validation error.
+func getDevice(repo repositories.DeviceRepository, userID int, handle string) (*repositories.Device, error) {
+	devices, err := repo.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		if device.Handle == handle {
+			return device, nil
+		}
+	}
+	return nil, errors.New("device not found")
+}
+
+// GetUserDevicesExecutor defines an APIExecutor for listing the devices of the caller.
+type GetUserDevicesExecutor struct {
+	UserDevice
+	clienthelper.BaseAPIExecutor
+	DeviceRepo repositories.DeviceRepository
+	Policy     *TokenPolicy
+}
+
+// NewGetUserDevicesExecutor returns a new instance of GetUserDevicesExecutor.
+func NewGetUserDevicesExecutor(repo repositories.DeviceRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &GetUserDevicesExecutor{
+		DeviceRepo: repo,
+		Policy:     policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *GetUserDevicesExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.UserDevice.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// Controller executes the business logic for listing the devices of the caller and returns the devices
+// and any errors that occur during execution.
+func (e *GetUserDevicesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.DeviceRepo.GetAllForUser(e.ActorID)
+}
+
+// SetDeviceTrustExecutor defines an APIExecutor for trusting or distrusting a device of the caller.
+type SetDeviceTrustExecutor struct {
+	UserDevice
+	clienthelper.BaseAPIExecutor
+	DeviceRepo repositories.DeviceRepository
+	Policy     *TokenPolicy
+	StepUp     StepUp
+}
+
+// NewSetDeviceTrustExecutor returns a new instance of SetDeviceTrustExecutor. Trusting a device lets later
+// logins from it skip the second factor, so the caller must have logged in with AMRMFA, within the MaxAge
+// of the step-up requirement.
+func NewSetDeviceTrustExecutor(repo repositories.DeviceRepository, policy *TokenPolicy, stepUp StepUp) clienthelper.APIExecutor {
+	return &SetDeviceTrustExecutor{
+		DeviceRepo: repo,
+		Policy:     policy,
+		StepUp:     StepUp{MaxAge: stepUp.MaxAge, Factor: AMRMFA},
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller, who must satisfy the step-up
+// requirement to trust a device.
+func (e *SetDeviceTrustExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.UserDevice.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	if e.Trusted {
+		err = e.StepUp.Authorize(w, r, e.Policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that a device is given.
+func (e *SetDeviceTrustExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Handle == "" {
+		return errors.New("device is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for trusting or distrusting a device and returns any errors that
+// occur during execution. Only devices the caller has logged in from can be changed.
+func (e *SetDeviceTrustExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	device, err := getDevice(e.DeviceRepo, e.ActorID, e.Handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.DeviceRepo.SetTrusted(e.ActorID, device.ID, e.Trusted)
+}
+
+// DeleteUserDeviceExecutor defines an APIExecutor for forgetting a device of the caller.
+type DeleteUserDeviceExecutor struct {
+	UserDevice
+	clienthelper.BaseAPIExecutor
+	DeviceRepo repositories.DeviceRepository
+	Policy     *TokenPolicy
+}
+
+// NewDeleteUserDeviceExecutor returns a new instance of DeleteUserDeviceExecutor.
+func NewDeleteUserDeviceExecutor(repo repositories.DeviceRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &DeleteUserDeviceExecutor{
+		DeviceRepo: repo,
+		Policy:     policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *DeleteUserDeviceExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.UserDevice.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that a device is given.
+func (e *DeleteUserDeviceExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Handle == "" {
+		return errors.New("device is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for forgetting a device and returns any errors that occur during execution.
+func (e *DeleteUserDeviceExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	device, err := getDevice(e.DeviceRepo, e.ActorID, e.Handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.DeviceRepo.Delete(e.ActorID, device.ID)
+}
@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/princeparmar/contact_manager/notifier"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// EligibleRole defines a struct for the eligibility of a user to activate a role for up to MaxHours.
+type EligibleRole struct {
+	ID              int
+	UserID          int  `json:"user_id"`
+	RoleID          int  `json:"role_id"`
+	MaxHours        int  `json:"max_hours"`
+	RequireMFA      bool `json:"require_mfa"`
+	RequireApproval bool `json:"require_approval"`
+	OrgID           int  `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the EligibleRole object.
+// The eligibility is read from the body of POST requests; the id and user_id query parameters select
+// eligibilities otherwise.
+func (er *EligibleRole) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the EligibleRole object
+		err = json.Unmarshal(body, er)
+		if err != nil {
+			return err
+		}
+	} else {
+		query := r.URL.Query()
+
+		if id := query.Get("id"); id != "" {
+			i, err := strconv.Atoi(id)
+			if err != nil {
+				return errors.New("invalid id in query")
+			}
+			er.ID = i
+		}
+
+		if userID := query.Get("user_id"); userID != "" {
+			i, err := strconv.Atoi(userID)
+			if err != nil {
+				return errors.New("invalid user_id in query")
+			}
+			er.UserID = i
+		}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	er.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the EligibleRole object and returns any errors that occur during validation.
+func (er *EligibleRole) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// CreateEligibleRoleExecutor defines an APIExecutor for making a user eligible for a role.
+type CreateEligibleRoleExecutor struct {
+	EligibleRole
+	clienthelper.BaseAPIExecutor
+	UserRepo     repositories.UserRepository
+	RoleRepo     repositories.RoleRepository
+	EligibleRepo repositories.EligibleRoleRepository
+}
+
+// NewCreateEligibleRoleExecutor returns a new instance of CreateEligibleRoleExecutor.
+func NewCreateEligibleRoleExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, eligibleRepo repositories.EligibleRoleRepository) clienthelper.APIExecutor {
+	return &CreateEligibleRoleExecutor{
+		UserRepo:     userRepo,
+		RoleRepo:     roleRepo,
+		EligibleRepo: eligibleRepo,
+	}
+}
+
+// ValidateRequest validates that a user, a role and a positive maximum duration are given.
+func (e *CreateEligibleRoleExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if e.MaxHours <= 0 {
+		return errors.New("max_hours must be positive")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for making a user eligible for a role and returns the eligibility
+// and any errors that occur during execution.
+func (e *CreateEligibleRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getMember(e.UserRepo.ForOrg(e.OrgID), e.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	eligibleRepo := e.EligibleRepo.ForOrg(e.OrgID)
+	eligibility := &repositories.EligibleRole{
+		UserID:          e.UserID,
+		RoleID:          e.RoleID,
+		MaxHours:        e.MaxHours,
+		RequireMFA:      e.RequireMFA,
+		RequireApproval: e.RequireApproval,
+	}
+	err = eligibleRepo.Create(eligibility)
+	if err != nil {
+		return nil, err
+	}
+
+	return eligibleRepo.Get(eligibility.ID)
+}
+
+// GetEligibleRolesExecutor defines an APIExecutor for listing eligibilities.
+type GetEligibleRolesExecutor struct {
+	EligibleRole
+	clienthelper.BaseAPIExecutor
+	EligibleRepo repositories.EligibleRoleRepository
+}
+
+// NewGetEligibleRolesExecutor returns a new instance of GetEligibleRolesExecutor.
+func NewGetEligibleRolesExecutor(eligibleRepo repositories.EligibleRoleRepository) clienthelper.APIExecutor {
+	return &GetEligibleRolesExecutor{
+		EligibleRepo: eligibleRepo,
+	}
+}
+
+// Controller executes the business logic for listing eligibilities and returns the eligibilities of the
+// organization, or of the user given by user_id, and any errors that occur during execution.
+func (e *GetEligibleRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.EligibleRepo.ForOrg(e.OrgID).GetAll(e.UserID)
+}
+
+// DeleteEligibleRoleExecutor defines an APIExecutor for removing an eligibility.
+type DeleteEligibleRoleExecutor struct {
+	EligibleRole
+	clienthelper.BaseAPIExecutor
+	EligibleRepo repositories.EligibleRoleRepository
+}
+
+// NewDeleteEligibleRoleExecutor returns a new instance of DeleteEligibleRoleExecutor.
+func NewDeleteEligibleRoleExecutor(eligibleRepo repositories.EligibleRoleRepository) clienthelper.APIExecutor {
+	return &DeleteEligibleRoleExecutor{
+		EligibleRepo: eligibleRepo,
+	}
+}
+
+// ValidateRequest validates that an ID is given.
+func (e *DeleteEligibleRoleExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for removing an eligibility and returns any errors that occur
+// during execution. Roles already activated stay assigned until they expire.
+func (e *DeleteEligibleRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return nil, e.EligibleRepo.ForOrg(e.OrgID).Delete(e.ID)
+}
+
+// RoleActivation defines a struct for the self-service activation of an eligible role. Claims holds the
+// claims of the caller's bearer token.
+type RoleActivation struct {
+	RoleID        int           `json:"role_id"`
+	Justification string        `json:"justification"`
+	Hours         int           `json:"hours"`
+	UserID        int           `json:"-"`
+	ActorID       int           `json:"-"`
+	Claims        jwt.MapClaims `json:"-"`
+	OrgID         int           `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the RoleActivation object.
+// The activation is read from the body of POST requests; the user_id query parameter filters the
+// activation history otherwise.
+func (ra *RoleActivation) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the RoleActivation object
+		err = json.Unmarshal(body, ra)
+		if err != nil {
+			return err
+		}
+	} else if userID := r.URL.Query().Get("user_id"); userID != "" {
+		i, err := strconv.Atoi(userID)
+		if err != nil {
+			return errors.New("invalid user_id in query")
+		}
+		ra.UserID = i
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	ra.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the RoleActivation object and returns any errors that occur during validation.
+func (ra *RoleActivation) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// RoleActivationResult defines the response of the activation endpoint. Activation is set when the role
+// was activated and Request when the activation awaits approval.
+type RoleActivationResult struct {
+	Activation *repositories.RoleActivation `json:"activation,omitempty"`
+	Request    *repositories.AccessRequest  `json:"request,omitempty"`
+}
+
+// ActivateRoleExecutor defines an APIExecutor for activating an eligible role.
+type ActivateRoleExecutor struct {
+	RoleActivation
+	clienthelper.BaseAPIExecutor
+	EligibleRepo repositories.EligibleRoleRepository
+	ApproverRepo repositories.RoleApproverRepository
+	RequestRepo  repositories.AccessRequestRepository
+	Notifier     notifier.Notifier
+	Policy       *TokenPolicy
+	StepUp       StepUp
+}
+
+// NewActivateRoleExecutor returns a new instance of ActivateRoleExecutor. Activations are reported to the
+// security channel of the notifier, which may be nil. Eligibilities requiring MFA are activated only when
+// the caller logged in with the AMRMFA factor and satisfies the step-up requirement.
+func NewActivateRoleExecutor(eligibleRepo repositories.EligibleRoleRepository, approverRepo repositories.RoleApproverRepository, requestRepo repositories.AccessRequestRepository, n notifier.Notifier, policy *TokenPolicy, stepUp StepUp) clienthelper.APIExecutor {
+	return &ActivateRoleExecutor{
+		EligibleRepo: eligibleRepo,
+		ApproverRepo: approverRepo,
+		RequestRepo:  requestRepo,
+		Notifier:     n,
+		Policy:       policy,
+		StepUp:       stepUp,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *ActivateRoleExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.RoleActivation.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.Claims, err = authenticate(r, e.Policy)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = claimsUserID(e.Claims)
+	return err
+}
+
+// ValidateRequest validates that a role, a justification and a positive duration are given.
+func (e *ActivateRoleExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if e.Justification == "" {
+		return errors.New("justification is required")
+	}
+
+	if e.Hours <= 0 {
+		return errors.New("hours must be positive")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for activating a role and returns the activation, or the access
+// request awaiting approval, and any errors that occur during execution. The role is assigned to the caller
+// until the requested number of hours, at most the maximum of the eligibility, has passed.
+func (e *ActivateRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	eligibleRepo := e.EligibleRepo.ForOrg(e.OrgID)
+	eligibility, err := eligibleRepo.GetForUserRole(e.ActorID, e.RoleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("user is not eligible for the role")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Hours > eligibility.MaxHours {
+		return nil, fmt.Errorf("the role can be activated for at most %d hours", eligibility.MaxHours)
+	}
+
+	if eligibility.RequireMFA {
+		if !hasAMR(e.Claims, AMRMFA) {
+			return nil, &StepUpError{Reason: fmt.Sprintf("authentication with %s is required", AMRMFA)}
+		}
+
+		err = e.StepUp.Check(e.Claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if eligibility.RequireApproval {
+		req, err := e.requestApproval(eligibility)
+		if err != nil {
+			return nil, err
+		}
+
+		notifySecurity(e.Notifier, "Role activation requested", fmt.Sprintf("User %s requested activation of role %s for %d hours (request %d): %s", eligibility.UserName, eligibility.RoleName, e.Hours, req.ID, e.Justification))
+
+		return &RoleActivationResult{Request: req}, nil
+	}
+
+	activation, err := eligibleRepo.Activate(eligibility, e.Justification, e.Hours)
+	if err != nil {
+		return nil, err
+	}
+
+	notifySecurity(e.Notifier, "Role activated", fmt.Sprintf("User %s activated role %s until %s: %s", activation.UserName, activation.RoleName, formatNotificationTime(activation.ExpiryDate), activation.Justification))
+
+	return &RoleActivationResult{Activation: activation}, nil
+}
+
+// requestApproval files an access request for the activation, to be decided by the approvers of the role.
+// Approving it assigns the role for the requested hours.
+func (e *ActivateRoleExecutor) requestApproval(eligibility *repositories.EligibleRole) (*repositories.AccessRequest, error) {
+	approvers, err := e.ApproverRepo.ForOrg(e.OrgID).GetForRole(e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	if len(approvers) == 0 {
+		return nil, errors.New("activation requires approval but the role has no approvers")
+	}
+
+	requestRepo := e.RequestRepo.ForOrg(e.OrgID)
+	pending, err := requestRepo.GetAll(repositories.AccessRequestFilter{UserID: e.ActorID, RoleID: e.RoleID, State: repositories.RequestPending})
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		return nil, errors.New("a request for the role is already pending")
+	}
+
+	req := &repositories.AccessRequest{
+		UserID:        eligibility.UserID,
+		RoleID:        eligibility.RoleID,
+		Justification: e.Justification,
+		DurationHours: e.Hours,
+	}
+	err = requestRepo.Create(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return getAccessRequest(requestRepo, req.ID)
+}
+
+// notifySecurity reports an elevation to the security channel, when a notifier is configured. The
+// elevation has already taken effect, so a failed notification is logged instead of failing the request.
+func notifySecurity(n notifier.Notifier, subject, message string) {
+	if n == nil {
+		return
+	}
+
+	err := n.Notify(&notifier.Notification{
+		Subject: subject,
+		Message: message,
+	})
+	if err != nil {
+		log.Printf("security notification %q failed: %v", subject, err)
+	}
+}
+
+// formatNotificationTime formats a time for notifications.
+func formatNotificationTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+// GetRoleActivationsExecutor defines an APIExecutor for auditing role activations.
+type GetRoleActivationsExecutor struct {
+	RoleActivation
+	clienthelper.BaseAPIExecutor
+	EligibleRepo repositories.EligibleRoleRepository
+}
+
+// NewGetRoleActivationsExecutor returns a new instance of GetRoleActivationsExecutor.
+func NewGetRoleActivationsExecutor(eligibleRepo repositories.EligibleRoleRepository) clienthelper.APIExecutor {
+	return &GetRoleActivationsExecutor{
+		EligibleRepo: eligibleRepo,
+	}
+}
+
+// Controller executes the business logic for auditing activations and returns the activations of the
+// organization, or of the user given by user_id, newest first, and any errors that occur during execution.
+func (e *GetRoleActivationsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.EligibleRepo.ForOrg(e.OrgID).GetActivations(e.UserID)
+}
@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Group defines a struct for group data.
+type Group struct {
+	ID    int
+	Name  string `json:"name"`
+	OrgID int    `json:"-"`
+}
+
+// createGroupModel maps Group to Group model.
+func createGroupModel(g *Group) *repositories.Group {
+	return &repositories.Group{
+		ID:   g.ID,
+		Name: g.Name,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Group object.
+func (g *Group) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Group object
+		err = json.Unmarshal(body, g)
+		if err != nil {
+			return err
+		}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	g.OrgID = scope
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	g.ID = i
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Group object and returns any errors that occur during validation.
+func (g *Group) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getGroup retrieves a group by ID, reporting a missing group as a validation error.
+func getGroup(repo *repositories.GroupRepository, id int) (*repositories.Group, error) {
+	group, err := repo.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("group not found")
+	}
+	return group, err
+}
+
+// CreateGroupExecutor defines an APIExecutor for creating a new group.
+type CreateGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewCreateGroupExecutor returns a new instance of CreateGroupExecutor.
+func NewCreateGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &CreateGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// ValidateRequest validates that the group has a name.
+func (e *CreateGroupExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Name == "" {
+		return errors.New("group name is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for creating a new group and returns the created group
+// and any errors that occur during execution.
+func (e *CreateGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	group := createGroupModel(&e.Group)
+	err := e.GroupRepo.ForOrg(e.OrgID).Create(group)
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// UpdateGroupExecutor defines an APIExecutor for updating a group by ID.
+type UpdateGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewUpdateGroupExecutor returns a new instance of UpdateGroupExecutor.
+func NewUpdateGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &UpdateGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// ValidateRequest validates that the group has an ID and a name.
+func (e *UpdateGroupExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+	if e.Name == "" {
+		return errors.New("group name is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for updating a group by ID and returns the updated group
+// and any errors that occur during execution.
+func (e *UpdateGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	group := createGroupModel(&e.Group)
+	err := e.GroupRepo.ForOrg(e.OrgID).Update(group)
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// DeleteGroupExecutor defines an APIExecutor for deleting a group by ID.
+type DeleteGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewDeleteGroupExecutor returns a new instance of DeleteGroupExecutor.
+func NewDeleteGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &DeleteGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for deleting a group by ID and returns any errors that occur during execution.
+func (e *DeleteGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return nil, e.GroupRepo.ForOrg(e.OrgID).Delete(e.ID)
+}
+
+// GetGroupExecutor defines an APIExecutor for getting a group by ID.
+type GetGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewGetGroupExecutor returns a new instance of GetGroupExecutor.
+func NewGetGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &GetGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting a group by ID and returns the group
+// and any errors that occur during execution.
+func (e *GetGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return getGroup(e.GroupRepo.ForOrg(e.OrgID), e.ID)
+}
+
+// GetAllGroupsExecutor defines an APIExecutor for getting all groups.
+type GetAllGroupsExecutor struct {
+	clienthelper.BaseAPIExecutor
+	OrgID     int
+	GroupRepo repositories.GroupRepository
+}
+
+// NewGetAllGroupsExecutor returns a new instance of GetAllGroupsExecutor.
+func NewGetAllGroupsExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &GetAllGroupsExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// ParseRequest reads the organization the request acts in.
+func (e *GetAllGroupsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	e.OrgID = scope
+	return nil
+}
+
+// Controller executes the business logic for getting all groups and returns the groups
+// and any errors that occur during execution.
+func (e *GetAllGroupsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.GroupRepo.ForOrg(e.OrgID).GetAll()
+}
+
+// GroupLink defines a struct for linking a group to a user, a subgroup or a role.
+type GroupLink struct {
+	GroupID       int
+	UserID        int
+	MemberGroupID int
+	RoleID        int
+	ExpiryDate    *time.Time `json:"expiry_date"`
+	OrgID         int        `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the GroupLink object.
+// All IDs are read from the query; the ones an executor does not use may be omitted.
+func (l *GroupLink) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the GroupLink object
+		if len(body) > 0 {
+			err = json.Unmarshal(body, l)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	query := r.URL.Query()
+	for name, target := range map[string]*int{"group_id": &l.GroupID, "user_id": &l.UserID, "member_group_id": &l.MemberGroupID, "role_id": &l.RoleID} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("invalid " + name + " in query")
+		}
+		*target = i
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	l.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the GroupLink object and returns any errors that occur during validation.
+func (l *GroupLink) ValidateRequest(ctx context.IContext) error {
+	if l.GroupID == 0 {
+		return errors.New("group_id is required")
+	}
+
+	if l.ExpiryDate != nil && !l.ExpiryDate.After(time.Now()) {
+		return errors.New("expiry_date must be in the future")
+	}
+
+	return nil
+}
+
+// AddGroupMemberExecutor defines an APIExecutor for adding a user to a group.
+type AddGroupMemberExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	UserRepo  repositories.UserRepository
+	GroupRepo repositories.GroupRepository
+}
+
+// NewAddGroupMemberExecutor returns a new instance of AddGroupMemberExecutor.
+func NewAddGroupMemberExecutor(userRepo repositories.UserRepository, groupRepo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &AddGroupMemberExecutor{
+		UserRepo:  userRepo,
+		GroupRepo: groupRepo,
+	}
+}
+
+// Controller executes the business logic for adding a user to a group and returns any errors that occur during execution.
+func (e *AddGroupMemberExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedGroup(e.GroupRepo.ForOrg(e.OrgID), e.OrgID, e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := e.UserRepo.ForOrg(e.OrgID).Get(e.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	return nil, e.GroupRepo.AddMember(e.GroupID, e.UserID)
+}
+
+// RemoveGroupMemberExecutor defines an APIExecutor for removing a user from a group.
+type RemoveGroupMemberExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewRemoveGroupMemberExecutor returns a new instance of RemoveGroupMemberExecutor.
+func NewRemoveGroupMemberExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &RemoveGroupMemberExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for removing a user from a group and returns any errors that occur during execution.
+func (e *RemoveGroupMemberExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedGroup(e.GroupRepo.ForOrg(e.OrgID), e.OrgID, e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.GroupRepo.RemoveMember(e.GroupID, e.UserID)
+}
+
+// GetGroupMembersExecutor defines an APIExecutor for listing the direct members of a group.
+type GetGroupMembersExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewGetGroupMembersExecutor returns a new instance of GetGroupMembersExecutor.
+func NewGetGroupMembersExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &GetGroupMembersExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the members of a group and returns the users
+// and any errors that occur during execution.
+func (e *GetGroupMembersExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getGroup(e.GroupRepo.ForOrg(e.OrgID), e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.GroupRepo.GetMembers(e.GroupID)
+}
+
+// AddSubgroupExecutor defines an APIExecutor for nesting a group inside another group.
+type AddSubgroupExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewAddSubgroupExecutor returns a new instance of AddSubgroupExecutor.
+func NewAddSubgroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &AddSubgroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for nesting a group and returns any errors that occur during execution.
+func (e *AddSubgroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	// A group of the organization may contain global groups, but not the other way round
+	groupRepo := e.GroupRepo.ForOrg(e.OrgID)
+	_, err := getOwnedGroup(groupRepo, e.OrgID, e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getGroup(groupRepo, e.MemberGroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.GroupRepo.AddSubgroup(e.GroupID, e.MemberGroupID)
+}
+
+// RemoveSubgroupExecutor defines an APIExecutor for removing a nested group from a group.
+type RemoveSubgroupExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewRemoveSubgroupExecutor returns a new instance of RemoveSubgroupExecutor.
+func NewRemoveSubgroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &RemoveSubgroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for removing a nested group and returns any errors that occur during execution.
+func (e *RemoveSubgroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedGroup(e.GroupRepo.ForOrg(e.OrgID), e.OrgID, e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.GroupRepo.RemoveSubgroup(e.GroupID, e.MemberGroupID)
+}
+
+// AssignGroupRoleExecutor defines an APIExecutor for assigning a role to a group with an optional expiry.
+type AssignGroupRoleExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	RoleRepo  repositories.RoleRepository
+	GroupRepo repositories.GroupRepository
+}
+
+// NewAssignGroupRoleExecutor returns a new instance of AssignGroupRoleExecutor.
+func NewAssignGroupRoleExecutor(roleRepo repositories.RoleRepository, groupRepo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &AssignGroupRoleExecutor{
+		RoleRepo:  roleRepo,
+		GroupRepo: groupRepo,
+	}
+}
+
+// Controller executes the business logic for assigning a role to a group and returns the assignment
+// and any errors that occur during execution.
+func (e *AssignGroupRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedGroup(e.GroupRepo.ForOrg(e.OrgID), e.OrgID, e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	groupRole := &repositories.GroupRole{
+		GroupID:  e.GroupID,
+		RoleID:   role.ID,
+		RoleName: role.Name,
+	}
+	if e.ExpiryDate != nil {
+		groupRole.ExpiryDate = *e.ExpiryDate
+	}
+
+	err = e.GroupRepo.AssignRole(groupRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRole, nil
+}
+
+// RevokeGroupRoleExecutor defines an APIExecutor for revoking a role from a group.
+type RevokeGroupRoleExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewRevokeGroupRoleExecutor returns a new instance of RevokeGroupRoleExecutor.
+func NewRevokeGroupRoleExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &RevokeGroupRoleExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for revoking a role from a group and returns any errors that occur during execution.
+func (e *RevokeGroupRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedGroup(e.GroupRepo.ForOrg(e.OrgID), e.OrgID, e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.GroupRepo.RevokeRole(e.GroupID, e.RoleID)
+}
+
+// GetGroupRolesExecutor defines an APIExecutor for listing the roles assigned to a group.
+type GetGroupRolesExecutor struct {
+	GroupLink
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewGetGroupRolesExecutor returns a new instance of GetGroupRolesExecutor.
+func NewGetGroupRolesExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &GetGroupRolesExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the roles of a group and returns the assignments
+// and any errors that occur during execution.
+func (e *GetGroupRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getGroup(e.GroupRepo.ForOrg(e.OrgID), e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.GroupRepo.GetRoles(e.GroupID)
+}
@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	stdcontext "context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// OrgHeader is the request header naming the organization a request acts in. It is optional; when given it
+// must match the org_id claim of the caller's token.
+const OrgHeader = "X-Org-ID"
+
+// GlobalAdminAccess is the access a user must hold to act in the global scope, outside any organization.
+const GlobalAdminAccess = "iam:global:admin"
+
+// claimsKey is the request context key of the claims verified by WithClaims.
+type claimsKey struct{}
+
+// WithClaims verifies the bearer token of each request and makes its claims available to the handlers,
+// which derive the organization a request acts in from them. Requests without a valid token are passed on
+// without claims, and organization-scoped endpoints reject them.
+func WithClaims(policy *TokenPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := bearerToken(r); token != "" {
+			if claims, err := policy.ParseToken(token); err == nil {
+				r = r.WithContext(stdcontext.WithValue(r.Context(), claimsKey{}, claims))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// orgID returns the organization a request acts in, which is the org_id claim of the token verified by
+// WithClaims. A token without the claim acts in the global scope, which requires the global_admin claim.
+func orgID(r *http.Request) (int, error) {
+	claims, ok := r.Context().Value(claimsKey{}).(jwt.MapClaims)
+	if !ok {
+		return 0, errors.New("authorization token is required")
+	}
+
+	claimed, _ := claims["org_id"].(float64)
+	scope := int(claimed)
+
+	if value := r.Header.Get(OrgHeader); value != "" {
+		i, err := strconv.Atoi(value)
+		if err != nil || i != scope {
+			return 0, errors.New(OrgHeader + " header does not match the organization of the token")
+		}
+	}
+
+	if scope == repositories.GlobalOrgID {
+		if admin, _ := claims["global_admin"].(bool); !admin {
+			return 0, errors.New("acting outside an organization requires the " + GlobalAdminAccess + " access")
+		}
+	}
+
+	return scope, nil
+}
+
+// checkOrg reports an organization other than the one a request acts in as missing, so that requests
+// scoped to one organization cannot learn about another. Global requests may act on every organization.
+func checkOrg(scope, target int) error {
+	if scope != repositories.GlobalOrgID && scope != target {
+		return errors.New("organization not found")
+	}
+	return nil
+}
+
+// getOwnedRole retrieves a role that may be modified in the scope of repo. Global roles are visible to
+// every organization but may only be modified globally.
+func getOwnedRole(repo *repositories.RoleRepository, scope, id int) (*repositories.Role, error) {
+	role, err := getRole(repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if role.OrgID != scope {
+		return nil, errors.New("global roles cannot be modified within an organization")
+	}
+
+	return role, nil
+}
+
+// getOwnedGroup retrieves a group that may be modified in the scope of repo. Global groups are visible to
+// every organization but may only be modified globally.
+func getOwnedGroup(repo *repositories.GroupRepository, scope, id int) (*repositories.Group, error) {
+	group, err := getGroup(repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if group.OrgID != scope {
+		return nil, errors.New("global groups cannot be modified within an organization")
+	}
+
+	return group, nil
+}
+
+// Organization defines a struct for organization data.
+type Organization struct {
+	ID    int
+	Name  string `json:"name"`
+	OrgID int    `json:"-"`
+}
+
+// createOrganizationModel maps Organization to Organization model.
+func createOrganizationModel(o *Organization) *repositories.Organization {
+	return &repositories.Organization{
+		ID:   o.ID,
+		Name: o.Name,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Organization object.
+func (o *Organization) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Organization object
+		err = json.Unmarshal(body, o)
+		if err != nil {
+			return err
+		}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	o.OrgID = scope
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	o.ID = i
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Organization object and returns any errors that occur during validation.
+func (o *Organization) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getOrg retrieves an organization by ID, reporting a missing organization as a validation error.
+func getOrg(repo repositories.OrgRepository, id int) (*repositories.Organization, error) {
+	org, err := repo.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("organization not found")
+	}
+	return org, err
+}
+
+// CreateOrganizationExecutor defines an APIExecutor for creating a new organization.
+type CreateOrganizationExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewCreateOrganizationExecutor returns a new instance of CreateOrganizationExecutor.
+func NewCreateOrganizationExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &CreateOrganizationExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// ValidateRequest validates that the organization has a name and is created globally.
+func (e *CreateOrganizationExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.OrgID != repositories.GlobalOrgID {
+		return errors.New("organizations can only be created globally")
+	}
+	if e.Name == "" {
+		return errors.New("organization name is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for creating a new organization and returns the created organization
+// and any errors that occur during execution.
+func (e *CreateOrganizationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	org := createOrganizationModel(&e.Organization)
+	err := e.OrgRepo.Create(org)
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// UpdateOrganizationExecutor defines an APIExecutor for renaming an organization.
+type UpdateOrganizationExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewUpdateOrganizationExecutor returns a new instance of UpdateOrganizationExecutor.
+func NewUpdateOrganizationExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &UpdateOrganizationExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// ValidateRequest validates that the organization has an ID and a name.
+func (e *UpdateOrganizationExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+	if e.Name == "" {
+		return errors.New("organization name is required")
+	}
+	return checkOrg(e.OrgID, e.ID)
+}
+
+// Controller executes the business logic for renaming an organization and returns the updated organization
+// and any errors that occur during execution.
+func (e *UpdateOrganizationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOrg(e.OrgRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	org := createOrganizationModel(&e.Organization)
+	err = e.OrgRepo.Update(org)
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// DeleteOrganizationExecutor defines an APIExecutor for deleting an organization by ID.
+type DeleteOrganizationExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewDeleteOrganizationExecutor returns a new instance of DeleteOrganizationExecutor.
+func NewDeleteOrganizationExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &DeleteOrganizationExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// ValidateRequest validates that the organization is deleted globally.
+func (e *DeleteOrganizationExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.OrgID != repositories.GlobalOrgID {
+		return errors.New("organizations can only be deleted globally")
+	}
+	return nil
+}
+
+// Controller executes the business logic for deleting an organization by ID and returns any errors that occur during execution.
+func (e *DeleteOrganizationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return nil, e.OrgRepo.Delete(e.ID)
+}
+
+// GetOrganizationExecutor defines an APIExecutor for getting an organization by ID.
+type GetOrganizationExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewGetOrganizationExecutor returns a new instance of GetOrganizationExecutor.
+func NewGetOrganizationExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &GetOrganizationExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting an organization by ID and returns the organization
+// and any errors that occur during execution.
+func (e *GetOrganizationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := checkOrg(e.OrgID, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return getOrg(e.OrgRepo, e.ID)
+}
+
+// GetAllOrganizationsExecutor defines an APIExecutor for getting all organizations.
+type GetAllOrganizationsExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewGetAllOrganizationsExecutor returns a new instance of GetAllOrganizationsExecutor.
+func NewGetAllOrganizationsExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &GetAllOrganizationsExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting all organizations and returns the organizations
+// and any errors that occur during execution. A request scoped to an organization only sees that organization.
+func (e *GetAllOrganizationsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if e.OrgID == repositories.GlobalOrgID {
+		return e.OrgRepo.GetAll()
+	}
+
+	org, err := getOrg(e.OrgRepo, e.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*repositories.Organization{org}, nil
+}
+
+// OrgMember defines a struct for the membership of a user in an organization. The organization is
+// read from the org_id query parameter and defaults to the organization the request acts in.
+type OrgMember struct {
+	OrgID  int
+	UserID int
+	Scope  int
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the OrgMember object.
+func (m *OrgMember) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	m.Scope = scope
+	m.OrgID = scope
+
+	query := r.URL.Query()
+	for name, target := range map[string]*int{"org_id": &m.OrgID, "user_id": &m.UserID} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("invalid " + name + " in query")
+		}
+		*target = i
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the OrgMember object and returns any errors that occur during validation.
+func (m *OrgMember) ValidateRequest(ctx context.IContext) error {
+	if m.OrgID == repositories.GlobalOrgID {
+		return errors.New("org_id is required")
+	}
+
+	return checkOrg(m.Scope, m.OrgID)
+}
+
+// AddOrgMemberExecutor defines an APIExecutor for adding a user to an organization.
+type AddOrgMemberExecutor struct {
+	OrgMember
+	clienthelper.BaseAPIExecutor
+	UserRepo repositories.UserRepository
+	OrgRepo  repositories.OrgRepository
+}
+
+// NewAddOrgMemberExecutor returns a new instance of AddOrgMemberExecutor.
+func NewAddOrgMemberExecutor(userRepo repositories.UserRepository, orgRepo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &AddOrgMemberExecutor{
+		UserRepo: userRepo,
+		OrgRepo:  orgRepo,
+	}
+}
+
+// Controller executes the business logic for adding a user to an organization and returns any errors that occur during execution.
+func (e *AddOrgMemberExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOrg(e.OrgRepo, e.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users are global, so any existing user can be invited into an organization
+	user, err := e.UserRepo.Get(e.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	return nil, e.OrgRepo.AddMember(e.OrgID, e.UserID)
+}
+
+// RemoveOrgMemberExecutor defines an APIExecutor for removing a user from an organization.
+type RemoveOrgMemberExecutor struct {
+	OrgMember
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewRemoveOrgMemberExecutor returns a new instance of RemoveOrgMemberExecutor.
+func NewRemoveOrgMemberExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &RemoveOrgMemberExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// Controller executes the business logic for removing a user from an organization, together with the roles
+// the user holds in it, and returns any errors that occur during execution.
+func (e *RemoveOrgMemberExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return nil, e.OrgRepo.RemoveMember(e.OrgID, e.UserID)
+}
+
+// GetOrgMembersExecutor defines an APIExecutor for listing the members of an organization.
+type GetOrgMembersExecutor struct {
+	OrgMember
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewGetOrgMembersExecutor returns a new instance of GetOrgMembersExecutor.
+func NewGetOrgMembersExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &GetOrgMembersExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the members of an organization and returns the users
+// and any errors that occur during execution.
+func (e *GetOrgMembersExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.OrgRepo.GetMembers(e.OrgID)
+}
+
+// GetUserOrgsExecutor defines an APIExecutor for listing the organizations a user belongs to.
+type GetUserOrgsExecutor struct {
+	OrgMember
+	clienthelper.BaseAPIExecutor
+	OrgRepo repositories.OrgRepository
+}
+
+// NewGetUserOrgsExecutor returns a new instance of GetUserOrgsExecutor.
+func NewGetUserOrgsExecutor(repo repositories.OrgRepository) clienthelper.APIExecutor {
+	return &GetUserOrgsExecutor{
+		OrgRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a user is given and that the request is global, since the organizations of
+// a user span tenants.
+func (e *GetUserOrgsExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+	if e.Scope != repositories.GlobalOrgID {
+		return errors.New("the organizations of a user can only be listed globally")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the organizations of a user and returns the organizations
+// and any errors that occur during execution.
+func (e *GetUserOrgsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.OrgRepo.GetOrgsForUser(e.UserID)
+}
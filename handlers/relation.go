@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/princeparmar/contact_manager/authz"
+	"github.com/princeparmar/contact_manager/rebac"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// RelationTuples defines a struct for a write to the tuple store. Tuples are given in their
+// object#relation@subject form, such as "doc:readme#viewer@user:42", and are applied together.
+type RelationTuples struct {
+	Writes  []string `json:"writes"`
+	Deletes []string `json:"deletes"`
+	OrgID   int      `json:"-"`
+
+	writes  []rebac.Tuple
+	deletes []rebac.Tuple
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the RelationTuples object.
+func (rt *RelationTuples) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the RelationTuples object
+	err = json.Unmarshal(body, rt)
+	if err != nil {
+		return err
+	}
+
+	rt.OrgID, err = orgID(r)
+	return err
+}
+
+// ValidateRequest validates the data in the RelationTuples object and returns any errors that occur during validation.
+func (rt *RelationTuples) ValidateRequest(ctx context.IContext) error {
+	if len(rt.Writes) == 0 && len(rt.Deletes) == 0 {
+		return errors.New("writes or deletes is required")
+	}
+
+	var err error
+	rt.writes, err = parseTuples(rt.Writes)
+	if err != nil {
+		return err
+	}
+
+	rt.deletes, err = parseTuples(rt.Deletes)
+	return err
+}
+
+// parseTuples parses tuples from their object#relation@subject form.
+func parseTuples(values []string) ([]rebac.Tuple, error) {
+	tuples := make([]rebac.Tuple, 0, len(values))
+	for _, value := range values {
+		t, err := rebac.ParseTuple(value)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, t)
+	}
+	return tuples, nil
+}
+
+// RelationToken defines the response of endpoints that only return a consistency token.
+type RelationToken struct {
+	Token string `json:"token"`
+}
+
+// WriteRelationTuplesExecutor defines an APIExecutor for writing and deleting relationship tuples.
+type WriteRelationTuplesExecutor struct {
+	RelationTuples
+	clienthelper.BaseAPIExecutor
+	Checker *authz.RelationChecker
+}
+
+// NewWriteRelationTuplesExecutor returns a new instance of WriteRelationTuplesExecutor.
+func NewWriteRelationTuplesExecutor(checker *authz.RelationChecker) clienthelper.APIExecutor {
+	return &WriteRelationTuplesExecutor{
+		Checker: checker,
+	}
+}
+
+// Controller executes the business logic for writing tuples and returns the consistency token of the write
+// and any errors that occur during execution. Clients pass the token to later reads to observe the write.
+func (e *WriteRelationTuplesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	token, err := e.Checker.ForOrg(e.OrgID).Write(e.writes, e.deletes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RelationToken{Token: token}, nil
+}
+
+// RelationQuery defines a struct for a read of the tuple store, read from the query parameters object,
+// relation, subject and token. The token is a consistency token from an earlier response; when given,
+// the read observes everything up to the revision it was issued at.
+type RelationQuery struct {
+	Object   string
+	Relation string
+	Subject  string
+	Token    string
+	OrgID    int
+
+	object  rebac.Object
+	subject rebac.Subject
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the RelationQuery object.
+func (rq *RelationQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	rq.Object = query.Get("object")
+	rq.Relation = query.Get("relation")
+	rq.Subject = query.Get("subject")
+	rq.Token = query.Get("token")
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	rq.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the RelationQuery object and returns any errors that occur during validation.
+func (rq *RelationQuery) ValidateRequest(ctx context.IContext) error {
+	if rq.Object == "" {
+		return errors.New("object is required")
+	}
+
+	var err error
+	rq.object, err = rebac.ParseObject(rq.Object)
+	if err != nil {
+		return err
+	}
+
+	if rq.Token != "" {
+		_, err = rebac.DecodeToken(rq.Token)
+	}
+	return err
+}
+
+// RelationTuplesResult defines the response of the read tuples endpoint.
+type RelationTuplesResult struct {
+	Tuples []string `json:"tuples"`
+	Token  string   `json:"token"`
+}
+
+// ReadRelationTuplesExecutor defines an APIExecutor for listing the tuples of an object.
+type ReadRelationTuplesExecutor struct {
+	RelationQuery
+	clienthelper.BaseAPIExecutor
+	Checker *authz.RelationChecker
+}
+
+// NewReadRelationTuplesExecutor returns a new instance of ReadRelationTuplesExecutor.
+func NewReadRelationTuplesExecutor(checker *authz.RelationChecker) clienthelper.APIExecutor {
+	return &ReadRelationTuplesExecutor{
+		Checker: checker,
+	}
+}
+
+// Controller executes the business logic for listing tuples and returns the tuples of the object, of the
+// relation if one is given, and any errors that occur during execution.
+func (e *ReadRelationTuplesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	tuples, token, err := e.Checker.ForOrg(e.OrgID).Read(e.object, e.Relation, e.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RelationTuplesResult{Tuples: []string{}, Token: token}
+	for _, t := range tuples {
+		result.Tuples = append(result.Tuples, t.String())
+	}
+	return result, nil
+}
+
+// RelationCheckResult defines the response of the relation check endpoint.
+type RelationCheckResult struct {
+	Object   string `json:"object"`
+	Relation string `json:"relation"`
+	Subject  string `json:"subject"`
+	Allowed  bool   `json:"allowed"`
+	Token    string `json:"token"`
+}
+
+// CheckRelationExecutor defines an APIExecutor for deciding whether a subject holds a relation on an object.
+type CheckRelationExecutor struct {
+	RelationQuery
+	clienthelper.BaseAPIExecutor
+	Checker *authz.RelationChecker
+}
+
+// NewCheckRelationExecutor returns a new instance of CheckRelationExecutor.
+func NewCheckRelationExecutor(checker *authz.RelationChecker) clienthelper.APIExecutor {
+	return &CheckRelationExecutor{
+		Checker: checker,
+	}
+}
+
+// ValidateRequest validates that a relation and a subject are given.
+func (e *CheckRelationExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Relation == "" || e.Subject == "" {
+		return errors.New("relation and subject are required")
+	}
+
+	var err error
+	e.subject, err = rebac.ParseSubject(e.Subject)
+	if err != nil {
+		return err
+	}
+
+	return e.RelationQuery.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for the relation check and returns the decision
+// and any errors that occur during execution.
+func (e *CheckRelationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	allowed, token, err := e.Checker.ForOrg(e.OrgID).Check(e.object, e.Relation, e.subject, e.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RelationCheckResult{
+		Object:   e.object.String(),
+		Relation: e.Relation,
+		Subject:  e.subject.String(),
+		Allowed:  allowed,
+		Token:    token,
+	}, nil
+}
+
+// RelationExpandResult defines the response of the relation expand endpoint.
+type RelationExpandResult struct {
+	Tree  *authz.UsersetTree `json:"tree"`
+	Token string             `json:"token"`
+}
+
+// ExpandRelationExecutor defines an APIExecutor for expanding the subjects of a relation on an object.
+type ExpandRelationExecutor struct {
+	RelationQuery
+	clienthelper.BaseAPIExecutor
+	Checker *authz.RelationChecker
+}
+
+// NewExpandRelationExecutor returns a new instance of ExpandRelationExecutor.
+func NewExpandRelationExecutor(checker *authz.RelationChecker) clienthelper.APIExecutor {
+	return &ExpandRelationExecutor{
+		Checker: checker,
+	}
+}
+
+// ValidateRequest validates that a relation is given.
+func (e *ExpandRelationExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Relation == "" {
+		return errors.New("relation is required")
+	}
+	return e.RelationQuery.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for expanding a relation and returns its userset tree
+// and any errors that occur during execution.
+func (e *ExpandRelationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	tree, token, err := e.Checker.ForOrg(e.OrgID).Expand(e.object, e.Relation, e.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RelationExpandResult{Tree: tree, Token: token}, nil
+}
+
+// Namespace defines a struct for a namespace configuration request. The configuration is read from the
+// body of POST requests and the namespace name from the name query parameter otherwise. Namespaces are
+// shared by all organizations, so they can only be changed outside of one.
+type Namespace struct {
+	Config *rebac.NamespaceConfig
+	Name   string
+	OrgID  int
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Namespace object.
+func (n *Namespace) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		n.Config, err = rebac.ParseNamespaceConfig(body)
+		if err != nil {
+			return err
+		}
+		n.Name = n.Config.Name
+	} else {
+		n.Name = r.URL.Query().Get("name")
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	n.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Namespace object and returns any errors that occur during validation.
+func (n *Namespace) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// checkGlobalScope rejects changes to namespaces made within an organization.
+func (n *Namespace) checkGlobalScope() error {
+	if n.OrgID != 0 {
+		return errors.New("namespaces cannot be modified within an organization")
+	}
+	return nil
+}
+
+// SaveNamespaceExecutor defines an APIExecutor for creating or replacing a namespace configuration.
+type SaveNamespaceExecutor struct {
+	Namespace
+	clienthelper.BaseAPIExecutor
+	NamespaceRepo repositories.NamespaceRepository
+}
+
+// NewSaveNamespaceExecutor returns a new instance of SaveNamespaceExecutor.
+func NewSaveNamespaceExecutor(repo repositories.NamespaceRepository) clienthelper.APIExecutor {
+	return &SaveNamespaceExecutor{
+		NamespaceRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a configuration is given outside of an organization.
+func (e *SaveNamespaceExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Config == nil {
+		return errors.New("namespace configuration is required")
+	}
+	return e.checkGlobalScope()
+}
+
+// Controller executes the business logic for saving a namespace configuration and returns the configuration
+// and any errors that occur during execution. Tuples already written for relations the configuration no
+// longer accepts tuples for are kept but ignored by checks.
+func (e *SaveNamespaceExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := e.NamespaceRepo.Save(e.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Config, nil
+}
+
+// GetNamespaceExecutor defines an APIExecutor for retrieving namespace configurations.
+type GetNamespaceExecutor struct {
+	Namespace
+	clienthelper.BaseAPIExecutor
+	NamespaceRepo repositories.NamespaceRepository
+}
+
+// NewGetNamespaceExecutor returns a new instance of GetNamespaceExecutor.
+func NewGetNamespaceExecutor(repo repositories.NamespaceRepository) clienthelper.APIExecutor {
+	return &GetNamespaceExecutor{
+		NamespaceRepo: repo,
+	}
+}
+
+// Controller executes the business logic for retrieving namespace configurations and returns the named
+// configuration, or all of them by name without a name, and any errors that occur during execution.
+func (e *GetNamespaceExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if e.Name == "" {
+		return e.NamespaceRepo.GetAll()
+	}
+
+	config, err := e.NamespaceRepo.Get(e.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("namespace not found")
+	}
+	return config, err
+}
+
+// DeleteNamespaceExecutor defines an APIExecutor for removing a namespace configuration.
+type DeleteNamespaceExecutor struct {
+	Namespace
+	clienthelper.BaseAPIExecutor
+	NamespaceRepo repositories.NamespaceRepository
+}
+
+// NewDeleteNamespaceExecutor returns a new instance of DeleteNamespaceExecutor.
+func NewDeleteNamespaceExecutor(repo repositories.NamespaceRepository) clienthelper.APIExecutor {
+	return &DeleteNamespaceExecutor{
+		NamespaceRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a namespace name is given outside of an organization.
+func (e *DeleteNamespaceExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	return e.checkGlobalScope()
+}
+
+// Controller executes the business logic for removing a namespace configuration and returns any errors
+// that occur during execution.
+func (e *DeleteNamespaceExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return nil, e.NamespaceRepo.Delete(e.Name)
+}
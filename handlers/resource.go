@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Resource defines a struct for a node of the resource tree. Services register their resources with
+// the parent they live in, such as a document in a project, so that roles bound on the parent apply
+// to the children.
+type Resource struct {
+	Type   string                    `json:"type"`
+	ID     string                    `json:"id"`
+	Parent *repositories.ResourceRef `json:"parent"`
+	OrgID  int                       `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Resource object.
+// The resource is read from the body of POST requests and from the type and id query parameters otherwise.
+func (res *Resource) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Resource object
+		err = json.Unmarshal(body, res)
+		if err != nil {
+			return err
+		}
+	} else {
+		query := r.URL.Query()
+		res.Type = query.Get("type")
+		res.ID = query.Get("id")
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	res.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Resource object and returns any errors that occur during validation.
+func (res *Resource) ValidateRequest(ctx context.IContext) error {
+	if res.Type == "" || res.ID == "" {
+		return errors.New("type and id are required")
+	}
+
+	return validateResourceRef(res.Parent)
+}
+
+// ref returns the reference of the requested resource.
+func (res *Resource) ref() repositories.ResourceRef {
+	return repositories.ResourceRef{Type: res.Type, ID: res.ID}
+}
+
+// getResource retrieves a registered resource, reporting an unregistered resource as a validation error.
+func getResource(repo *repositories.ResourceRepository, ref repositories.ResourceRef) (*repositories.Resource, error) {
+	resource, err := repo.Get(ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("resource " + ref.String() + " is not registered")
+	}
+	return resource, err
+}
+
+// RegisterResourceExecutor defines an APIExecutor for registering a resource in the resource tree or moving
+// it under another parent.
+type RegisterResourceExecutor struct {
+	Resource
+	clienthelper.BaseAPIExecutor
+	ResourceRepo repositories.ResourceRepository
+}
+
+// NewRegisterResourceExecutor returns a new instance of RegisterResourceExecutor.
+func NewRegisterResourceExecutor(repo repositories.ResourceRepository) clienthelper.APIExecutor {
+	return &RegisterResourceExecutor{
+		ResourceRepo: repo,
+	}
+}
+
+// Controller executes the business logic for registering a resource and returns the resource
+// and any errors that occur during execution.
+func (e *RegisterResourceExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	resourceRepo := e.ResourceRepo.ForOrg(e.OrgID)
+	if e.Parent != nil {
+		_, err := getResource(resourceRepo, *e.Parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resource := &repositories.Resource{
+		Type:   e.Type,
+		ID:     e.ID,
+		Parent: e.Parent,
+	}
+	err := resourceRepo.Register(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+// ResourceTree defines the response of the get resource endpoint.
+type ResourceTree struct {
+	*repositories.Resource
+	Ancestors []repositories.ResourceRef `json:"ancestors"`
+	Children  []*repositories.Resource   `json:"children"`
+}
+
+// GetResourceExecutor defines an APIExecutor for retrieving a resource with its place in the resource tree.
+type GetResourceExecutor struct {
+	Resource
+	clienthelper.BaseAPIExecutor
+	ResourceRepo repositories.ResourceRepository
+}
+
+// NewGetResourceExecutor returns a new instance of GetResourceExecutor.
+func NewGetResourceExecutor(repo repositories.ResourceRepository) clienthelper.APIExecutor {
+	return &GetResourceExecutor{
+		ResourceRepo: repo,
+	}
+}
+
+// Controller executes the business logic for retrieving a resource and returns the resource with its
+// ancestors, nearest first, and its direct children and any errors that occur during execution.
+func (e *GetResourceExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	resourceRepo := e.ResourceRepo.ForOrg(e.OrgID)
+	resource, err := getResource(resourceRepo, e.ref())
+	if err != nil {
+		return nil, err
+	}
+
+	ancestors, err := resourceRepo.GetAncestors(e.ref())
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := resourceRepo.GetChildren(e.ref())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResourceTree{
+		Resource:  resource,
+		Ancestors: ancestors[1:],
+		Children:  children,
+	}, nil
+}
+
+// DeleteResourceExecutor defines an APIExecutor for removing a resource from the resource tree.
+type DeleteResourceExecutor struct {
+	Resource
+	clienthelper.BaseAPIExecutor
+	ResourceRepo repositories.ResourceRepository
+}
+
+// NewDeleteResourceExecutor returns a new instance of DeleteResourceExecutor.
+func NewDeleteResourceExecutor(repo repositories.ResourceRepository) clienthelper.APIExecutor {
+	return &DeleteResourceExecutor{
+		ResourceRepo: repo,
+	}
+}
+
+// Controller executes the business logic for removing a resource and returns any errors that occur during execution.
+// The children of the resource become roots; bindings on the resource are kept and still apply to it.
+func (e *DeleteResourceExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	resourceRepo := e.ResourceRepo.ForOrg(e.OrgID)
+	resource, err := getResource(resourceRepo, e.ref())
+	if err != nil {
+		return nil, err
+	}
+
+	if resource.OrgID != e.OrgID {
+		return nil, errors.New("global resources cannot be modified within an organization")
+	}
+
+	return nil, resourceRepo.Delete(e.ref())
+}
+
+// RoleBinding defines a struct for a binding of a role to a user on a resource. A nil ExpiryDate means
+// the binding does not expire.
+type RoleBinding struct {
+	ID         int
+	UserID     int                       `json:"user_id"`
+	RoleID     int                       `json:"role_id"`
+	Resource   *repositories.ResourceRef `json:"resource"`
+	ExpiryDate *time.Time                `json:"expiry_date"`
+	OrgID      int                       `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the RoleBinding object.
+// POST requests carry the binding in the body; the id, user_id, resource_type and resource_id query
+// parameters select bindings otherwise.
+func (rb *RoleBinding) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the RoleBinding object
+		err = json.Unmarshal(body, rb)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := r.URL.Query()
+
+	for name, target := range map[string]*int{"id": &rb.ID, "user_id": &rb.UserID} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("invalid " + name + " in query")
+		}
+		*target = i
+	}
+
+	if resourceType := query.Get("resource_type"); resourceType != "" {
+		rb.Resource = &repositories.ResourceRef{Type: resourceType, ID: query.Get("resource_id")}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	rb.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the RoleBinding object and returns any errors that occur during validation.
+func (rb *RoleBinding) ValidateRequest(ctx context.IContext) error {
+	return validateResourceRef(rb.Resource)
+}
+
+// CreateRoleBindingExecutor defines an APIExecutor for binding a role to a user on a resource.
+type CreateRoleBindingExecutor struct {
+	RoleBinding
+	clienthelper.BaseAPIExecutor
+	UserRepo     repositories.UserRepository
+	RoleRepo     repositories.RoleRepository
+	ResourceRepo repositories.ResourceRepository
+	BindingRepo  repositories.RoleBindingRepository
+}
+
+// NewCreateRoleBindingExecutor returns a new instance of CreateRoleBindingExecutor.
+func NewCreateRoleBindingExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, resourceRepo repositories.ResourceRepository, bindingRepo repositories.RoleBindingRepository) clienthelper.APIExecutor {
+	return &CreateRoleBindingExecutor{
+		UserRepo:     userRepo,
+		RoleRepo:     roleRepo,
+		ResourceRepo: resourceRepo,
+		BindingRepo:  bindingRepo,
+	}
+}
+
+// ValidateRequest validates that a user, a role and a resource are given.
+func (e *CreateRoleBindingExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if e.Resource == nil {
+		return errors.New("resource is required")
+	}
+
+	if e.ExpiryDate != nil && !e.ExpiryDate.After(time.Now()) {
+		return errors.New("expiry_date must be in the future")
+	}
+
+	return e.RoleBinding.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for binding a role on a resource and returns the binding
+// and any errors that occur during execution.
+func (e *CreateRoleBindingExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	user, err := e.UserRepo.ForOrg(e.OrgID).Get(e.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	role, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getResource(e.ResourceRepo.ForOrg(e.OrgID), *e.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	binding := &repositories.RoleBinding{
+		UserID:   e.UserID,
+		RoleID:   role.ID,
+		RoleName: role.Name,
+		Resource: *e.Resource,
+	}
+	if e.ExpiryDate != nil {
+		binding.ExpiryDate = *e.ExpiryDate
+	}
+
+	err = e.BindingRepo.ForOrg(e.OrgID).Create(binding)
+	if err != nil {
+		return nil, err
+	}
+
+	return binding, nil
+}
+
+// DeleteRoleBindingExecutor defines an APIExecutor for removing a role binding by ID.
+type DeleteRoleBindingExecutor struct {
+	RoleBinding
+	clienthelper.BaseAPIExecutor
+	BindingRepo repositories.RoleBindingRepository
+}
+
+// NewDeleteRoleBindingExecutor returns a new instance of DeleteRoleBindingExecutor.
+func NewDeleteRoleBindingExecutor(repo repositories.RoleBindingRepository) clienthelper.APIExecutor {
+	return &DeleteRoleBindingExecutor{
+		BindingRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a binding is given.
+func (e *DeleteRoleBindingExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for removing a role binding and returns any errors that occur during execution.
+func (e *DeleteRoleBindingExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	bindingRepo := e.BindingRepo.ForOrg(e.OrgID)
+	binding, err := bindingRepo.Get(e.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("role binding not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if binding.OrgID != e.OrgID {
+		return nil, errors.New("global role bindings cannot be modified within an organization")
+	}
+
+	return nil, bindingRepo.Delete(e.ID)
+}
+
+// GetRoleBindingsExecutor defines an APIExecutor for listing the role bindings of a user or the bindings
+// made directly on a resource.
+type GetRoleBindingsExecutor struct {
+	RoleBinding
+	clienthelper.BaseAPIExecutor
+	BindingRepo repositories.RoleBindingRepository
+}
+
+// NewGetRoleBindingsExecutor returns a new instance of GetRoleBindingsExecutor.
+func NewGetRoleBindingsExecutor(repo repositories.RoleBindingRepository) clienthelper.APIExecutor {
+	return &GetRoleBindingsExecutor{
+		BindingRepo: repo,
+	}
+}
+
+// ValidateRequest validates that exactly one of a user and a resource is given.
+func (e *GetRoleBindingsExecutor) ValidateRequest(ctx context.IContext) error {
+	if (e.UserID == 0) == (e.Resource == nil) {
+		return errors.New("exactly one of user_id and resource_type is required")
+	}
+
+	return e.RoleBinding.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for listing role bindings and returns the bindings
+// and any errors that occur during execution.
+func (e *GetRoleBindingsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	bindingRepo := e.BindingRepo.ForOrg(e.OrgID)
+	if e.UserID != 0 {
+		return bindingRepo.GetForUser(e.UserID)
+	}
+
+	return bindingRepo.GetForResource(*e.Resource)
+}
@@ -0,0 +1,598 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// ReviewCampaign defines a struct for an access review campaign. ScopeID is the role or the manager
+// reviewed for the role and manager scopes. ReviewerID reviews every item, or with ReviewByManager only
+// those of users without a recorded manager.
+type ReviewCampaign struct {
+	ID              int
+	Name            string     `json:"name"`
+	Scope           string     `json:"scope"`
+	ScopeID         int        `json:"scope_id"`
+	ReviewerID      int        `json:"reviewer_id"`
+	ReviewByManager bool       `json:"review_by_manager"`
+	Deadline        *time.Time `json:"deadline"`
+	AutoRevoke      bool       `json:"auto_revoke"`
+	ActorID         int        `json:"-"`
+	OrgID           int        `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the ReviewCampaign object.
+func (rc *ReviewCampaign) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the ReviewCampaign object
+		err = json.Unmarshal(body, rc)
+		if err != nil {
+			return err
+		}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	rc.OrgID = scope
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	rc.ID = i
+
+	return nil
+}
+
+// ValidateRequest validates the data in the ReviewCampaign object and returns any errors that occur during validation.
+func (rc *ReviewCampaign) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getCampaign retrieves a review campaign by ID, reporting a missing campaign as a validation error.
+func getCampaign(repo *repositories.ReviewCampaignRepository, id int) (*repositories.ReviewCampaign, error) {
+	campaign, err := repo.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("review campaign not found")
+	}
+	return campaign, err
+}
+
+// ReviewCampaignDetail defines the response of the review campaign endpoints for a single campaign.
+type ReviewCampaignDetail struct {
+	*repositories.ReviewCampaign
+	Items []*repositories.ReviewItem `json:"items"`
+}
+
+// CreateReviewCampaignExecutor defines an APIExecutor for launching an access review campaign.
+type CreateReviewCampaignExecutor struct {
+	ReviewCampaign
+	clienthelper.BaseAPIExecutor
+	UserRepo     repositories.UserRepository
+	RoleRepo     repositories.RoleRepository
+	CampaignRepo repositories.ReviewCampaignRepository
+	Policy       *TokenPolicy
+}
+
+// NewCreateReviewCampaignExecutor returns a new instance of CreateReviewCampaignExecutor.
+func NewCreateReviewCampaignExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, campaignRepo repositories.ReviewCampaignRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &CreateReviewCampaignExecutor{
+		UserRepo:     userRepo,
+		RoleRepo:     roleRepo,
+		CampaignRepo: campaignRepo,
+		Policy:       policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller, who is recorded as the creator.
+func (e *CreateReviewCampaignExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.ReviewCampaign.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that the campaign has a name, a known scope, a reviewer and a future deadline.
+func (e *CreateReviewCampaignExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Name == "" {
+		return errors.New("campaign name is required")
+	}
+
+	switch e.Scope {
+	case repositories.ReviewScopeRole, repositories.ReviewScopeManager:
+		if e.ScopeID == 0 {
+			return errors.New("scope_id is required for the " + e.Scope + " scope")
+		}
+	case repositories.ReviewScopeOrg:
+		e.ScopeID = 0
+	default:
+		return errors.New("scope must be role, manager or org")
+	}
+
+	if e.ReviewerID == 0 {
+		return errors.New("reviewer_id is required")
+	}
+
+	if e.Deadline == nil || !e.Deadline.After(time.Now()) {
+		return errors.New("deadline must be in the future")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for launching a campaign and returns the campaign with its items
+// and any errors that occur during execution. The items are the assignments in scope at launch; later
+// assignments are left to the next campaign.
+func (e *CreateReviewCampaignExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	userRepo := e.UserRepo.ForOrg(e.OrgID)
+	_, err := getMember(userRepo, e.ReviewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Scope {
+	case repositories.ReviewScopeRole:
+		_, err = getRole(e.RoleRepo.ForOrg(e.OrgID), e.ScopeID)
+	case repositories.ReviewScopeManager:
+		_, err = getMember(userRepo, e.ScopeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	campaignRepo := e.CampaignRepo.ForOrg(e.OrgID)
+	campaign := &repositories.ReviewCampaign{
+		Name:            e.Name,
+		Scope:           e.Scope,
+		ScopeID:         e.ScopeID,
+		ReviewerID:      e.ReviewerID,
+		ReviewByManager: e.ReviewByManager,
+		Deadline:        *e.Deadline,
+		AutoRevoke:      e.AutoRevoke,
+		CreatedBy:       e.ActorID,
+	}
+	_, err = campaignRepo.Create(campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := campaignRepo.GetItems(campaign.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReviewCampaignDetail{ReviewCampaign: campaign, Items: items}, nil
+}
+
+// GetReviewCampaignsExecutor defines an APIExecutor for retrieving access review campaigns.
+type GetReviewCampaignsExecutor struct {
+	ReviewCampaign
+	clienthelper.BaseAPIExecutor
+	CampaignRepo repositories.ReviewCampaignRepository
+}
+
+// NewGetReviewCampaignsExecutor returns a new instance of GetReviewCampaignsExecutor.
+func NewGetReviewCampaignsExecutor(campaignRepo repositories.ReviewCampaignRepository) clienthelper.APIExecutor {
+	return &GetReviewCampaignsExecutor{
+		CampaignRepo: campaignRepo,
+	}
+}
+
+// Controller executes the business logic for retrieving campaigns and returns the campaign with the given
+// ID and its items, or all campaigns of the organization without one, and any errors that occur during execution.
+func (e *GetReviewCampaignsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	campaignRepo := e.CampaignRepo.ForOrg(e.OrgID)
+	if e.ID == 0 {
+		return campaignRepo.GetAll()
+	}
+
+	campaign, err := getCampaign(campaignRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := campaignRepo.GetItems(e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReviewCampaignDetail{ReviewCampaign: campaign, Items: items}, nil
+}
+
+// CampaignExport defines the response of the review campaign export endpoint: a CSV evidence file with a
+// header row and one row per item.
+type CampaignExport struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
+}
+
+// ExportReviewCampaignExecutor defines an APIExecutor for exporting a completed campaign as evidence.
+type ExportReviewCampaignExecutor struct {
+	ReviewCampaign
+	clienthelper.BaseAPIExecutor
+	CampaignRepo repositories.ReviewCampaignRepository
+}
+
+// NewExportReviewCampaignExecutor returns a new instance of ExportReviewCampaignExecutor.
+func NewExportReviewCampaignExecutor(campaignRepo repositories.ReviewCampaignRepository) clienthelper.APIExecutor {
+	return &ExportReviewCampaignExecutor{
+		CampaignRepo: campaignRepo,
+	}
+}
+
+// ValidateRequest validates that an ID is given.
+func (e *ExportReviewCampaignExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for exporting a campaign and returns the CSV evidence file
+// and any errors that occur during execution. Only completed campaigns can be exported, so that the
+// evidence does not change after it is produced.
+func (e *ExportReviewCampaignExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	campaignRepo := e.CampaignRepo.ForOrg(e.OrgID)
+	campaign, err := getCampaign(campaignRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if campaign.State != repositories.CampaignCompleted {
+		return nil, errors.New("only completed campaigns can be exported")
+	}
+
+	items, err := campaignRepo.GetItems(e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := campaignCSV(campaign, items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CampaignExport{
+		FileName:    fmt.Sprintf("access-review-%d.csv", campaign.ID),
+		ContentType: "text/csv",
+		Content:     content,
+	}, nil
+}
+
+// campaignCSV renders the items of a campaign as CSV. Items decided automatically are attributed to
+// "system"; items that were never decided have an empty decision.
+func campaignCSV(campaign *repositories.ReviewCampaign, items []*repositories.ReviewItem) (string, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	header := []string{"campaign_id", "campaign_name", "deadline", "completed_date", "user_id", "user_name", "role_id", "role_name", "org_id", "assigned_date", "expiry_date", "reviewer", "decision", "decided_by", "decided_date", "comment"}
+	err := writer.Write(header)
+	if err != nil {
+		return "", err
+	}
+
+	for _, item := range items {
+		decidedBy := ""
+		if item.Decision != "" {
+			decidedBy = "system"
+			if item.DecidedBy != 0 {
+				decidedBy = strconv.Itoa(item.DecidedBy)
+			}
+		}
+
+		err = writer.Write([]string{
+			strconv.Itoa(campaign.ID),
+			csvCell(campaign.Name),
+			formatCSVTime(campaign.Deadline),
+			formatCSVTime(campaign.CompletedDate),
+			strconv.Itoa(item.UserID),
+			csvCell(item.UserName),
+			strconv.Itoa(item.RoleID),
+			csvCell(item.RoleName),
+			strconv.Itoa(item.OrgID),
+			formatCSVTime(item.AssignedDate),
+			formatCSVTime(item.ExpiryDate),
+			csvCell(item.ReviewerName),
+			item.Decision,
+			decidedBy,
+			formatCSVTime(item.DecidedDate),
+			csvCell(item.Comment),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	writer.Flush()
+	return buf.String(), writer.Error()
+}
+
+// csvCell neutralises a user-controlled value that a spreadsheet would otherwise evaluate as a formula,
+// by prefixing values starting with =, +, -, @, a tab or a carriage return with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// formatCSVTime formats a time for the CSV export, leaving zero times empty.
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ReviewItem defines a struct for a decision on an item of a review campaign. The item is read from the
+// id query parameter and the decision, comment or new reviewer from the body.
+type ReviewItem struct {
+	ID         int
+	Decision   string `json:"decision"`
+	Comment    string `json:"comment"`
+	ReviewerID int    `json:"reviewer_id"`
+	ActorID    int    `json:"-"`
+	OrgID      int    `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the ReviewItem object.
+func (ri *ReviewItem) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the ReviewItem object
+		err = json.Unmarshal(body, ri)
+		if err != nil {
+			return err
+		}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	ri.OrgID = scope
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	ri.ID = i
+
+	return nil
+}
+
+// ValidateRequest validates the data in the ReviewItem object and returns any errors that occur during validation.
+func (ri *ReviewItem) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getReviewItem retrieves a review item by ID, reporting a missing item as a validation error.
+func getReviewItem(repo *repositories.ReviewCampaignRepository, id int) (*repositories.ReviewItem, error) {
+	item, err := repo.GetItem(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("review item not found")
+	}
+	return item, err
+}
+
+// GetMyReviewItemsExecutor defines an APIExecutor for listing the items the caller has to review.
+type GetMyReviewItemsExecutor struct {
+	ReviewItem
+	clienthelper.BaseAPIExecutor
+	CampaignRepo repositories.ReviewCampaignRepository
+	Policy       *TokenPolicy
+}
+
+// NewGetMyReviewItemsExecutor returns a new instance of GetMyReviewItemsExecutor.
+func NewGetMyReviewItemsExecutor(campaignRepo repositories.ReviewCampaignRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &GetMyReviewItemsExecutor{
+		CampaignRepo: campaignRepo,
+		Policy:       policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *GetMyReviewItemsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.ReviewItem.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// Controller executes the business logic for listing the caller's review items and returns the undecided
+// items of active campaigns, nearest deadline first, and any errors that occur during execution.
+func (e *GetMyReviewItemsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.CampaignRepo.ForOrg(e.OrgID).GetPendingForReviewer(e.ActorID)
+}
+
+// DecideReviewItemExecutor defines an APIExecutor for approving or revoking an item of a review campaign.
+type DecideReviewItemExecutor struct {
+	ReviewItem
+	clienthelper.BaseAPIExecutor
+	CampaignRepo repositories.ReviewCampaignRepository
+	Policy       *TokenPolicy
+}
+
+// NewDecideReviewItemExecutor returns a new instance of DecideReviewItemExecutor.
+func NewDecideReviewItemExecutor(campaignRepo repositories.ReviewCampaignRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &DecideReviewItemExecutor{
+		CampaignRepo: campaignRepo,
+		Policy:       policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *DecideReviewItemExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.ReviewItem.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that an item and a decision are given.
+func (e *DecideReviewItemExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+
+	if e.Decision != repositories.ReviewApproved && e.Decision != repositories.ReviewRevoked {
+		return errors.New("decision must be approved or revoked")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for deciding a review item and returns the decided item
+// and any errors that occur during execution. Only the reviewer of an item may decide it, and never
+// on their own assignment. Revoking removes the assignment immediately.
+func (e *DecideReviewItemExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	campaignRepo := e.CampaignRepo.ForOrg(e.OrgID)
+	item, err := getReviewItem(campaignRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.ReviewerID != e.ActorID {
+		return nil, errors.New("only the reviewer of the item can decide it")
+	}
+
+	if item.UserID == e.ActorID {
+		return nil, errors.New("reviewers cannot review their own assignments; reassign the item")
+	}
+
+	err = campaignRepo.Decide(e.ID, e.ActorID, e.Decision, e.Comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return getReviewItem(campaignRepo, e.ID)
+}
+
+// ReassignReviewItemExecutor defines an APIExecutor for handing a review item over to another reviewer.
+type ReassignReviewItemExecutor struct {
+	ReviewItem
+	clienthelper.BaseAPIExecutor
+	UserRepo     repositories.UserRepository
+	CampaignRepo repositories.ReviewCampaignRepository
+	Policy       *TokenPolicy
+}
+
+// NewReassignReviewItemExecutor returns a new instance of ReassignReviewItemExecutor.
+func NewReassignReviewItemExecutor(userRepo repositories.UserRepository, campaignRepo repositories.ReviewCampaignRepository, policy *TokenPolicy) clienthelper.APIExecutor {
+	return &ReassignReviewItemExecutor{
+		UserRepo:     userRepo,
+		CampaignRepo: campaignRepo,
+		Policy:       policy,
+	}
+}
+
+// ParseRequest parses the HTTP request and authenticates the caller.
+func (e *ReassignReviewItemExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.ReviewItem.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	e.ActorID, err = authenticatedUserID(r, e.Policy)
+	return err
+}
+
+// ValidateRequest validates that an item and a reviewer are given.
+func (e *ReassignReviewItemExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+
+	if e.ReviewerID == 0 {
+		return errors.New("reviewer_id is required")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for reassigning a review item and returns the item
+// and any errors that occur during execution. Only the current reviewer of the item and the creator
+// of its campaign may reassign it, and never to the user whose assignment is under review.
+func (e *ReassignReviewItemExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getMember(e.UserRepo.ForOrg(e.OrgID), e.ReviewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	campaignRepo := e.CampaignRepo.ForOrg(e.OrgID)
+	item, err := getReviewItem(campaignRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.ReviewerID != e.ActorID {
+		campaign, err := getCampaign(campaignRepo, item.CampaignID)
+		if err != nil {
+			return nil, err
+		}
+		if campaign.CreatedBy != e.ActorID {
+			return nil, errors.New("only the reviewer of the item or the creator of the campaign can reassign it")
+		}
+	}
+
+	if item.UserID == e.ReviewerID {
+		return nil, errors.New("reviewers cannot review their own assignments")
+	}
+
+	err = campaignRepo.Reassign(e.ID, e.ReviewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return getReviewItem(campaignRepo, e.ID)
+}
@@ -14,8 +14,9 @@ import (
 
 // Role defines a struct for role data.
 type Role struct {
-	ID   int
-	Name string `json:"name"`
+	ID    int
+	Name  string `json:"name"`
+	OrgID int    `json:"-"`
 }
 
 // createRoleModel maps Role to Role model.
@@ -42,6 +43,12 @@ func (r *Role) ParseRequest(ctx context.IContext, w http.ResponseWriter, req *ht
 		}
 	}
 
+	scope, err := orgID(req)
+	if err != nil {
+		return err
+	}
+	r.OrgID = scope
+
 	// Parse ID from the query parameter
 	id := req.URL.Query().Get("id")
 	i, err := strconv.Atoi(id)
@@ -78,7 +85,7 @@ func NewCreateRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExe
 // and any errors that occur during execution.
 func (e *CreateRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	role := createRoleModel(&e.Role)
-	err := e.RoleRepo.Create(role)
+	err := e.RoleRepo.ForOrg(e.OrgID).Create(role)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +110,7 @@ func NewDeleteRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExe
 // Controller executes the business logic for deleting a role by ID and returns any errors that occur during execution.
 func (e *DeleteRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	id := e.Role.ID
-	err := e.RoleRepo.Delete(id)
+	err := e.RoleRepo.ForOrg(e.OrgID).Delete(id)
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +136,7 @@ func NewUpdateRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExe
 // and any errors that occur during execution.
 func (e *UpdateRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	role := createRoleModel(&e.Role)
-	err := e.RoleRepo.Update(role)
+	err := e.RoleRepo.ForOrg(e.OrgID).Update(role)
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +162,7 @@ func NewGetRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExecut
 // and any errors that occur during execution.
 func (e *GetRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	id := e.Role.ID
-	role, err := e.RoleRepo.Get(id)
+	role, err := e.RoleRepo.ForOrg(e.OrgID).Get(id)
 	if err != nil {
 		return nil, err
 	}
@@ -166,6 +173,7 @@ func (e *GetRoleExecutor) Controller(ctx context.IContext) (interface{}, error)
 // GetAllRolesExecutor defines an APIExecutor for getting all roles.
 type GetAllRolesExecutor struct {
 	clienthelper.BaseAPIExecutor
+	OrgID    int
 	RoleRepo repositories.RoleRepository
 }
 
@@ -176,8 +184,18 @@ func NewGetAllRolesExecutor(repo repositories.RoleRepository) clienthelper.APIEx
 	}
 }
 
+// ParseRequest reads the organization the request acts in.
+func (e *GetAllRolesExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	e.OrgID = scope
+	return nil
+}
+
 // Controller executes the business logic for getting all roles and returns the roles
 // and any errors that occur during execution.
 func (e *GetAllRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	return e.RoleRepo.GetAll()
+	return e.RoleRepo.ForOrg(e.OrgID).GetAll()
 }
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// RoleAccess defines a struct for a grant of an access to a role. A Condition restricts the grant to
+// requests whose attributes satisfy it.
+type RoleAccess struct {
+	RoleID    int    `json:"-"`
+	AccessID  int    `json:"-"`
+	Condition string `json:"condition"`
+	OrgID     int    `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the RoleAccess object.
+// Both IDs are read from the query; the ones an executor does not use may be omitted. POST requests
+// may carry a condition in the body.
+func (ra *RoleAccess) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the RoleAccess object
+		if len(body) > 0 {
+			err = json.Unmarshal(body, ra)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	query := r.URL.Query()
+
+	if roleID := query.Get("role_id"); roleID != "" {
+		i, err := strconv.Atoi(roleID)
+		if err != nil {
+			return errors.New("invalid role_id in query")
+		}
+		ra.RoleID = i
+	}
+
+	if accessID := query.Get("access_id"); accessID != "" {
+		i, err := strconv.Atoi(accessID)
+		if err != nil {
+			return errors.New("invalid access_id in query")
+		}
+		ra.AccessID = i
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	ra.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the RoleAccess object and returns any errors that occur during validation.
+func (ra *RoleAccess) ValidateRequest(ctx context.IContext) error {
+	if ra.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if ra.AccessID == 0 {
+		return errors.New("access_id is required")
+	}
+
+	return validateCondition(&ra.Condition)
+}
+
+// getRole retrieves a role by ID, reporting a missing role as a validation error.
+func getRole(repo *repositories.RoleRepository, id int) (*repositories.Role, error) {
+	role, err := repo.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("role not found")
+	}
+	return role, err
+}
+
+// getAccess retrieves an access by ID, reporting a missing access as a validation error.
+func getAccess(repo repositories.AccessRepository, id int) (*repositories.Access, error) {
+	access, err := repo.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("access not found")
+	}
+	return access, err
+}
+
+// GrantRoleAccessExecutor defines an APIExecutor for granting an access to a role.
+type GrantRoleAccessExecutor struct {
+	RoleAccess
+	clienthelper.BaseAPIExecutor
+	RoleRepo       repositories.RoleRepository
+	AccessRepo     repositories.AccessRepository
+	RoleAccessRepo repositories.RoleAccessRepository
+}
+
+// NewGrantRoleAccessExecutor returns a new instance of GrantRoleAccessExecutor.
+func NewGrantRoleAccessExecutor(roleRepo repositories.RoleRepository, accessRepo repositories.AccessRepository, roleAccessRepo repositories.RoleAccessRepository) clienthelper.APIExecutor {
+	return &GrantRoleAccessExecutor{
+		RoleRepo:       roleRepo,
+		AccessRepo:     accessRepo,
+		RoleAccessRepo: roleAccessRepo,
+	}
+}
+
+// Controller executes the business logic for granting an access to a role and returns the grant
+// and any errors that occur during execution.
+func (e *GrantRoleAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedRole(e.RoleRepo.ForOrg(e.OrgID), e.OrgID, e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getAccess(e.AccessRepo, e.AccessID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = e.RoleAccessRepo.Get(e.RoleID, e.AccessID)
+	if err == nil {
+		return nil, errors.New("access is already granted to role")
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	roleAccess := &repositories.RoleAccess{
+		RoleID:    e.RoleID,
+		AccessID:  e.AccessID,
+		Condition: e.Condition,
+	}
+	err = e.RoleAccessRepo.Create(roleAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	return roleAccess, nil
+}
+
+// RevokeRoleAccessExecutor defines an APIExecutor for revoking an access from a role.
+type RevokeRoleAccessExecutor struct {
+	RoleAccess
+	clienthelper.BaseAPIExecutor
+	RoleRepo       repositories.RoleRepository
+	RoleAccessRepo repositories.RoleAccessRepository
+}
+
+// NewRevokeRoleAccessExecutor returns a new instance of RevokeRoleAccessExecutor.
+func NewRevokeRoleAccessExecutor(roleRepo repositories.RoleRepository, roleAccessRepo repositories.RoleAccessRepository) clienthelper.APIExecutor {
+	return &RevokeRoleAccessExecutor{
+		RoleRepo:       roleRepo,
+		RoleAccessRepo: roleAccessRepo,
+	}
+}
+
+// Controller executes the business logic for revoking an access from a role and returns any errors that occur during execution.
+func (e *RevokeRoleAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedRole(e.RoleRepo.ForOrg(e.OrgID), e.OrgID, e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = e.RoleAccessRepo.Get(e.RoleID, e.AccessID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("access is not granted to role")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.RoleAccessRepo.Delete(e.RoleID, e.AccessID)
+}
+
+// GetRoleAccessesExecutor defines an APIExecutor for listing the accesses granted to a role.
+type GetRoleAccessesExecutor struct {
+	RoleAccess
+	clienthelper.BaseAPIExecutor
+	RoleRepo repositories.RoleRepository
+}
+
+// NewGetRoleAccessesExecutor returns a new instance of GetRoleAccessesExecutor.
+func NewGetRoleAccessesExecutor(repo repositories.RoleRepository) clienthelper.APIExecutor {
+	return &GetRoleAccessesExecutor{
+		RoleRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a role is given.
+func (e *GetRoleAccessesExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the accesses of a role and returns the accesses
+// and any errors that occur during execution.
+func (e *GetRoleAccessesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.RoleRepo.GetAccessesForRole(e.RoleID)
+}
+
+// GetAccessRolesExecutor defines an APIExecutor for listing the roles holding an access.
+type GetAccessRolesExecutor struct {
+	RoleAccess
+	clienthelper.BaseAPIExecutor
+	AccessRepo     repositories.AccessRepository
+	RoleAccessRepo repositories.RoleAccessRepository
+}
+
+// NewGetAccessRolesExecutor returns a new instance of GetAccessRolesExecutor.
+func NewGetAccessRolesExecutor(accessRepo repositories.AccessRepository, roleAccessRepo repositories.RoleAccessRepository) clienthelper.APIExecutor {
+	return &GetAccessRolesExecutor{
+		AccessRepo:     accessRepo,
+		RoleAccessRepo: roleAccessRepo,
+	}
+}
+
+// ValidateRequest validates that an access is given.
+func (e *GetAccessRolesExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.AccessID == 0 {
+		return errors.New("access_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the roles holding an access and returns the roles
+// and any errors that occur during execution.
+func (e *GetAccessRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getAccess(e.AccessRepo, e.AccessID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.RoleAccessRepo.ForOrg(e.OrgID).GetRolesForAccess(e.AccessID)
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// RoleApprover defines a struct for an approver of access requests for a role: either a user, or
+// every user holding ApproverRoleID.
+type RoleApprover struct {
+	ID             int
+	RoleID         int `json:"role_id"`
+	UserID         int `json:"user_id"`
+	ApproverRoleID int `json:"approver_role_id"`
+	OrgID          int `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the RoleApprover object.
+// The approver is read from the body of POST requests; the id and role_id query parameters select
+// approvers otherwise.
+func (ra *RoleApprover) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the RoleApprover object
+		err = json.Unmarshal(body, ra)
+		if err != nil {
+			return err
+		}
+	} else {
+		query := r.URL.Query()
+
+		if id := query.Get("id"); id != "" {
+			i, err := strconv.Atoi(id)
+			if err != nil {
+				return errors.New("invalid id in query")
+			}
+			ra.ID = i
+		}
+
+		if roleID := query.Get("role_id"); roleID != "" {
+			i, err := strconv.Atoi(roleID)
+			if err != nil {
+				return errors.New("invalid role_id in query")
+			}
+			ra.RoleID = i
+		}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	ra.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the RoleApprover object and returns any errors that occur during validation.
+func (ra *RoleApprover) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// CreateRoleApproverExecutor defines an APIExecutor for designating an approver of a role.
+type CreateRoleApproverExecutor struct {
+	RoleApprover
+	clienthelper.BaseAPIExecutor
+	UserRepo     repositories.UserRepository
+	RoleRepo     repositories.RoleRepository
+	ApproverRepo repositories.RoleApproverRepository
+}
+
+// NewCreateRoleApproverExecutor returns a new instance of CreateRoleApproverExecutor.
+func NewCreateRoleApproverExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, approverRepo repositories.RoleApproverRepository) clienthelper.APIExecutor {
+	return &CreateRoleApproverExecutor{
+		UserRepo:     userRepo,
+		RoleRepo:     roleRepo,
+		ApproverRepo: approverRepo,
+	}
+}
+
+// ValidateRequest validates that a role and exactly one of a user and an approving role are given.
+func (e *CreateRoleApproverExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if (e.UserID == 0) == (e.ApproverRoleID == 0) {
+		return errors.New("exactly one of user_id and approver_role_id is required")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for designating an approver and returns the approver
+// and any errors that occur during execution. Organizations may designate approvers for global roles.
+func (e *CreateRoleApproverExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	roleRepo := e.RoleRepo.ForOrg(e.OrgID)
+	_, err := getRole(roleRepo, e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.UserID != 0 {
+		_, err = getMember(e.UserRepo.ForOrg(e.OrgID), e.UserID)
+	} else {
+		_, err = getRole(roleRepo, e.ApproverRoleID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	approverRepo := e.ApproverRepo.ForOrg(e.OrgID)
+	approver := &repositories.RoleApprover{
+		RoleID:         e.RoleID,
+		UserID:         e.UserID,
+		ApproverRoleID: e.ApproverRoleID,
+	}
+	err = approverRepo.Create(approver)
+	if err != nil {
+		return nil, err
+	}
+
+	return approverRepo.Get(approver.ID)
+}
+
+// GetRoleApproversExecutor defines an APIExecutor for listing the approvers of a role.
+type GetRoleApproversExecutor struct {
+	RoleApprover
+	clienthelper.BaseAPIExecutor
+	ApproverRepo repositories.RoleApproverRepository
+}
+
+// NewGetRoleApproversExecutor returns a new instance of GetRoleApproversExecutor.
+func NewGetRoleApproversExecutor(approverRepo repositories.RoleApproverRepository) clienthelper.APIExecutor {
+	return &GetRoleApproversExecutor{
+		ApproverRepo: approverRepo,
+	}
+}
+
+// ValidateRequest validates that a role is given.
+func (e *GetRoleApproversExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the approvers of a role and returns the approvers
+// and any errors that occur during execution.
+func (e *GetRoleApproversExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.ApproverRepo.ForOrg(e.OrgID).GetForRole(e.RoleID)
+}
+
+// DeleteRoleApproverExecutor defines an APIExecutor for removing an approver of a role.
+type DeleteRoleApproverExecutor struct {
+	RoleApprover
+	clienthelper.BaseAPIExecutor
+	ApproverRepo repositories.RoleApproverRepository
+}
+
+// NewDeleteRoleApproverExecutor returns a new instance of DeleteRoleApproverExecutor.
+func NewDeleteRoleApproverExecutor(approverRepo repositories.RoleApproverRepository) clienthelper.APIExecutor {
+	return &DeleteRoleApproverExecutor{
+		ApproverRepo: approverRepo,
+	}
+}
+
+// ValidateRequest validates that an ID is given.
+func (e *DeleteRoleApproverExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for removing an approver and returns any errors that occur during
+// execution. Pending requests are then decided by the remaining approvers.
+func (e *DeleteRoleApproverExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	approverRepo := e.ApproverRepo.ForOrg(e.OrgID)
+	approver, err := approverRepo.Get(e.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("approver not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if approver.OrgID != e.OrgID {
+		return nil, errors.New("global approvers cannot be modified within an organization")
+	}
+
+	return nil, approverRepo.Delete(e.ID)
+}
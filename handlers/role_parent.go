@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// RoleParent defines a struct for a parent link between two roles.
+type RoleParent struct {
+	RoleID       int
+	ParentRoleID int
+	OrgID        int
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the RoleParent object.
+// Both IDs are read from the query; the ones an executor does not use may be omitted.
+func (rp *RoleParent) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	if roleID := query.Get("role_id"); roleID != "" {
+		i, err := strconv.Atoi(roleID)
+		if err != nil {
+			return errors.New("invalid role_id in query")
+		}
+		rp.RoleID = i
+	}
+
+	if parentRoleID := query.Get("parent_role_id"); parentRoleID != "" {
+		i, err := strconv.Atoi(parentRoleID)
+		if err != nil {
+			return errors.New("invalid parent_role_id in query")
+		}
+		rp.ParentRoleID = i
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	rp.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the RoleParent object and returns any errors that occur during validation.
+func (rp *RoleParent) ValidateRequest(ctx context.IContext) error {
+	if rp.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if rp.ParentRoleID == 0 {
+		return errors.New("parent_role_id is required")
+	}
+
+	if rp.RoleID == rp.ParentRoleID {
+		return errors.New("a role cannot be its own parent")
+	}
+
+	return nil
+}
+
+// AddRoleParentExecutor defines an APIExecutor for making a role inherit the accesses of a parent role.
+type AddRoleParentExecutor struct {
+	RoleParent
+	clienthelper.BaseAPIExecutor
+	RoleRepo       repositories.RoleRepository
+	RoleParentRepo repositories.RoleParentRepository
+}
+
+// NewAddRoleParentExecutor returns a new instance of AddRoleParentExecutor.
+func NewAddRoleParentExecutor(roleRepo repositories.RoleRepository, roleParentRepo repositories.RoleParentRepository) clienthelper.APIExecutor {
+	return &AddRoleParentExecutor{
+		RoleRepo:       roleRepo,
+		RoleParentRepo: roleParentRepo,
+	}
+}
+
+// Controller executes the business logic for adding a parent role and returns the link
+// and any errors that occur during execution.
+func (e *AddRoleParentExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	// A role of the organization may inherit from a global role, but not the other way round
+	roleRepo := e.RoleRepo.ForOrg(e.OrgID)
+	_, err := getOwnedRole(roleRepo, e.OrgID, e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = getRole(roleRepo, e.ParentRoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	roleParent := &repositories.RoleParent{
+		RoleID:       e.RoleID,
+		ParentRoleID: e.ParentRoleID,
+	}
+	err = e.RoleParentRepo.Create(roleParent)
+	if err != nil {
+		return nil, err
+	}
+
+	return roleParent, nil
+}
+
+// RemoveRoleParentExecutor defines an APIExecutor for removing a parent role.
+type RemoveRoleParentExecutor struct {
+	RoleParent
+	clienthelper.BaseAPIExecutor
+	RoleRepo       repositories.RoleRepository
+	RoleParentRepo repositories.RoleParentRepository
+}
+
+// NewRemoveRoleParentExecutor returns a new instance of RemoveRoleParentExecutor.
+func NewRemoveRoleParentExecutor(roleRepo repositories.RoleRepository, roleParentRepo repositories.RoleParentRepository) clienthelper.APIExecutor {
+	return &RemoveRoleParentExecutor{
+		RoleRepo:       roleRepo,
+		RoleParentRepo: roleParentRepo,
+	}
+}
+
+// Controller executes the business logic for removing a parent role and returns any errors that occur during execution.
+func (e *RemoveRoleParentExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getOwnedRole(e.RoleRepo.ForOrg(e.OrgID), e.OrgID, e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = e.RoleParentRepo.Get(e.RoleID, e.ParentRoleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("role does not inherit from parent role")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.RoleParentRepo.Delete(e.RoleID, e.ParentRoleID)
+}
+
+// GetRoleParentsExecutor defines an APIExecutor for listing the direct parents of a role.
+type GetRoleParentsExecutor struct {
+	RoleParent
+	clienthelper.BaseAPIExecutor
+	RoleRepo       repositories.RoleRepository
+	RoleParentRepo repositories.RoleParentRepository
+}
+
+// NewGetRoleParentsExecutor returns a new instance of GetRoleParentsExecutor.
+func NewGetRoleParentsExecutor(roleRepo repositories.RoleRepository, roleParentRepo repositories.RoleParentRepository) clienthelper.APIExecutor {
+	return &GetRoleParentsExecutor{
+		RoleRepo:       roleRepo,
+		RoleParentRepo: roleParentRepo,
+	}
+}
+
+// ValidateRequest validates that a role is given.
+func (e *GetRoleParentsExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the parents of a role and returns the roles
+// and any errors that occur during execution.
+func (e *GetRoleParentsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.RoleParentRepo.GetParents(e.RoleID)
+}
+
+// GetRoleInheritedAccessesExecutor defines an APIExecutor for listing the effective accesses of a role,
+// marking which are granted directly and which are inherited.
+type GetRoleInheritedAccessesExecutor struct {
+	RoleParent
+	clienthelper.BaseAPIExecutor
+	RoleRepo repositories.RoleRepository
+}
+
+// NewGetRoleInheritedAccessesExecutor returns a new instance of GetRoleInheritedAccessesExecutor.
+func NewGetRoleInheritedAccessesExecutor(repo repositories.RoleRepository) clienthelper.APIExecutor {
+	return &GetRoleInheritedAccessesExecutor{
+		RoleRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a role is given.
+func (e *GetRoleInheritedAccessesExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the effective accesses of a role and returns the accesses
+// with the role each one comes from and any errors that occur during execution.
+func (e *GetRoleInheritedAccessesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.RoleRepo.GetInheritedAccesses(e.RoleID)
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// SoDConstraint defines a struct for a separation of duties constraint: a set of mutually exclusive
+// roles, of which a user may hold at most one.
+type SoDConstraint struct {
+	ID      int
+	Name    string `json:"name"`
+	RoleIDs []int  `json:"role_ids"`
+	OrgID   int    `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the SoDConstraint object.
+func (c *SoDConstraint) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the SoDConstraint object
+		err = json.Unmarshal(body, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	c.OrgID = scope
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	c.ID = i
+
+	return nil
+}
+
+// ValidateRequest validates the data in the SoDConstraint object and returns any errors that occur during validation.
+func (c *SoDConstraint) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getConstraint retrieves a constraint by ID, reporting a missing constraint as a validation error.
+func getConstraint(repo *repositories.SoDRepository, id int) (*repositories.SoDConstraint, error) {
+	constraint, err := repo.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("constraint not found")
+	}
+	return constraint, err
+}
+
+// CreateSoDConstraintExecutor defines an APIExecutor for declaring a separation of duties constraint.
+type CreateSoDConstraintExecutor struct {
+	SoDConstraint
+	clienthelper.BaseAPIExecutor
+	RoleRepo repositories.RoleRepository
+	SoDRepo  repositories.SoDRepository
+}
+
+// NewCreateSoDConstraintExecutor returns a new instance of CreateSoDConstraintExecutor.
+func NewCreateSoDConstraintExecutor(roleRepo repositories.RoleRepository, sodRepo repositories.SoDRepository) clienthelper.APIExecutor {
+	return &CreateSoDConstraintExecutor{
+		RoleRepo: roleRepo,
+		SoDRepo:  sodRepo,
+	}
+}
+
+// ValidateRequest validates that the constraint has a name and at least two distinct roles.
+func (e *CreateSoDConstraintExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Name == "" {
+		return errors.New("constraint name is required")
+	}
+
+	seen := map[int]bool{}
+	for _, roleID := range e.RoleIDs {
+		if seen[roleID] {
+			return errors.New("role_ids must not contain duplicates")
+		}
+		seen[roleID] = true
+	}
+
+	if len(e.RoleIDs) < 2 {
+		return errors.New("at least two role_ids are required")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for declaring a constraint and returns the created constraint
+// and any errors that occur during execution. Users already holding several of the roles are not changed;
+// they are listed by the violations report.
+func (e *CreateSoDConstraintExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	roleRepo := e.RoleRepo.ForOrg(e.OrgID)
+	for _, roleID := range e.RoleIDs {
+		_, err := getRole(roleRepo, roleID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	constraint := &repositories.SoDConstraint{
+		Name:    e.Name,
+		RoleIDs: e.RoleIDs,
+	}
+	err := e.SoDRepo.ForOrg(e.OrgID).Create(constraint)
+	if err != nil {
+		return nil, err
+	}
+
+	return constraint, nil
+}
+
+// GetSoDConstraintExecutor defines an APIExecutor for retrieving separation of duties constraints.
+type GetSoDConstraintExecutor struct {
+	SoDConstraint
+	clienthelper.BaseAPIExecutor
+	SoDRepo repositories.SoDRepository
+}
+
+// NewGetSoDConstraintExecutor returns a new instance of GetSoDConstraintExecutor.
+func NewGetSoDConstraintExecutor(repo repositories.SoDRepository) clienthelper.APIExecutor {
+	return &GetSoDConstraintExecutor{
+		SoDRepo: repo,
+	}
+}
+
+// Controller executes the business logic for retrieving constraints and returns the constraint with the
+// given ID, or all visible constraints without one, and any errors that occur during execution.
+func (e *GetSoDConstraintExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	sodRepo := e.SoDRepo.ForOrg(e.OrgID)
+	if e.ID == 0 {
+		return sodRepo.GetAll()
+	}
+
+	return getConstraint(sodRepo, e.ID)
+}
+
+// DeleteSoDConstraintExecutor defines an APIExecutor for removing a separation of duties constraint.
+type DeleteSoDConstraintExecutor struct {
+	SoDConstraint
+	clienthelper.BaseAPIExecutor
+	SoDRepo repositories.SoDRepository
+}
+
+// NewDeleteSoDConstraintExecutor returns a new instance of DeleteSoDConstraintExecutor.
+func NewDeleteSoDConstraintExecutor(repo repositories.SoDRepository) clienthelper.APIExecutor {
+	return &DeleteSoDConstraintExecutor{
+		SoDRepo: repo,
+	}
+}
+
+// ValidateRequest validates that an ID is given.
+func (e *DeleteSoDConstraintExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.ID == 0 {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for removing a constraint and returns any errors that occur during execution.
+func (e *DeleteSoDConstraintExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	sodRepo := e.SoDRepo.ForOrg(e.OrgID)
+	constraint, err := getConstraint(sodRepo, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if constraint.OrgID != e.OrgID {
+		return nil, errors.New("global constraints cannot be modified within an organization")
+	}
+
+	return nil, sodRepo.Delete(e.ID)
+}
+
+// GetSoDViolationsExecutor defines an APIExecutor for listing the users that violate separation of
+// duties constraints.
+type GetSoDViolationsExecutor struct {
+	SoDConstraint
+	clienthelper.BaseAPIExecutor
+	SoDRepo repositories.SoDRepository
+}
+
+// NewGetSoDViolationsExecutor returns a new instance of GetSoDViolationsExecutor.
+func NewGetSoDViolationsExecutor(repo repositories.SoDRepository) clienthelper.APIExecutor {
+	return &GetSoDViolationsExecutor{
+		SoDRepo: repo,
+	}
+}
+
+// Controller executes the business logic for the violations report and returns, for each constraint and
+// user holding more than one of its roles, the roles held, and any errors that occur during execution.
+// Violations that predate a constraint are included, as are those created through groups or role
+// inheritance, which assignments to users do not check.
+func (e *GetSoDViolationsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.SoDRepo.ForOrg(e.OrgID).GetViolations()
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/princeparmar/contact_manager/authz"
+	"github.com/princeparmar/contact_manager/permission"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+	"github.com/princeparmar/go-helpers/utils"
+)
+
+// TokenPolicy defines the lifetime of access tokens and sessions and the claims that identify
+// the deployment issuing them. It is built once at startup and must pass Validate.
+type TokenPolicy struct {
+	SecretKey   string
+	Issuer      string
+	Audience    string
+	ClaimFormat ClaimFormat
+
+	// AccessTokenTTL is the lifetime of an access token unless a role or client TTL is shorter.
+	AccessTokenTTL time.Duration
+	// RoleTTLs and ClientTTLs override AccessTokenTTL for users holding a role or logging in
+	// through a client, keyed by role name and client ID. The shortest applicable TTL wins.
+	RoleTTLs   map[string]time.Duration
+	ClientTTLs map[string]time.Duration
+
+	// MaxSessionAge is the absolute lifetime of a session, after which the user must log in again.
+	MaxSessionAge time.Duration
+	// IdleTimeout ends a session that has not been refreshed for the given duration.
+	IdleTimeout time.Duration
+}
+
+// DefaultTokenPolicy returns a TokenPolicy with 24 hour access tokens and 30 day sessions.
+func DefaultTokenPolicy(secretKey string) *TokenPolicy {
+	return &TokenPolicy{
+		SecretKey:      secretKey,
+		ClaimFormat:    ClaimFormatFull,
+		AccessTokenTTL: time.Hour * 24,
+		MaxSessionAge:  time.Hour * 24 * 30,
+		IdleTimeout:    time.Hour * 24 * 7,
+	}
+}
+
+// Validate checks the policy for configuration errors.
+func (p *TokenPolicy) Validate() error {
+	if p.SecretKey == "" {
+		return errors.New("token policy: secret key is required")
+	}
+
+	if _, err := ParseClaimFormat(string(p.ClaimFormat)); err != nil {
+		return fmt.Errorf("token policy: %v", err)
+	}
+
+	if p.AccessTokenTTL <= 0 {
+		return errors.New("token policy: access token ttl must be positive")
+	}
+
+	for role, ttl := range p.RoleTTLs {
+		if ttl <= 0 {
+			return fmt.Errorf("token policy: ttl for role %q must be positive", role)
+		}
+	}
+
+	for client, ttl := range p.ClientTTLs {
+		if ttl <= 0 {
+			return fmt.Errorf("token policy: ttl for client %q must be positive", client)
+		}
+	}
+
+	if p.MaxSessionAge <= 0 {
+		return errors.New("token policy: max session age must be positive")
+	}
+
+	if p.IdleTimeout <= 0 || p.IdleTimeout > p.MaxSessionAge {
+		return errors.New("token policy: idle timeout must be positive and not exceed max session age")
+	}
+
+	return nil
+}
+
+// accessTokenTTL returns the shortest TTL that applies to a user holding roles and logging in through clientID.
+func (p *TokenPolicy) accessTokenTTL(roles []*repositories.Role, clientID string) time.Duration {
+	ttl := p.AccessTokenTTL
+
+	for _, role := range roles {
+		if t, ok := p.RoleTTLs[role.Name]; ok && t < ttl {
+			ttl = t
+		}
+	}
+
+	if t, ok := p.ClientTTLs[clientID]; ok && t < ttl {
+		ttl = t
+	}
+
+	return ttl
+}
+
+// ParseToken verifies the signature, expiry, issuer and audience of a token and returns its claims.
+func (p *TokenPolicy) ParseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(p.SecretKey), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if p.Issuer != "" && !claims.VerifyIssuer(p.Issuer, true) {
+		return nil, errors.New("invalid token issuer")
+	}
+
+	if p.Audience != "" && !claims.VerifyAudience(p.Audience, true) {
+		return nil, errors.New("invalid token audience")
+	}
+
+	return claims, nil
+}
+
+// TokenResponse defines the tokens returned by login and refresh.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// TokenIssuer creates sessions and signs access tokens according to a TokenPolicy.
+type TokenIssuer struct {
+	Policy       *TokenPolicy
+	UserRepo     repositories.UserRepository
+	UserRoleRepo repositories.UserRoleRepository
+	AccessRepo   repositories.AccessRepository
+	SessionRepo  repositories.SessionRepository
+	OrgRepo      repositories.OrgRepository
+	Authorizer   *authz.Authorizer
+}
+
+// NewTokenIssuer returns a new instance of TokenIssuer.
+func NewTokenIssuer(policy *TokenPolicy, userRepo repositories.UserRepository, userRoleRepo repositories.UserRoleRepository, accessRepo repositories.AccessRepository, sessionRepo repositories.SessionRepository, orgRepo repositories.OrgRepository, authorizer *authz.Authorizer) *TokenIssuer {
+	return &TokenIssuer{
+		Policy:       policy,
+		UserRepo:     userRepo,
+		UserRoleRepo: userRoleRepo,
+		AccessRepo:   accessRepo,
+		SessionRepo:  sessionRepo,
+		OrgRepo:      orgRepo,
+		Authorizer:   authorizer,
+	}
+}
+
+// StartSession creates a new session for user, who authenticated with the methods in amr, acting in
+// organization orgID, and returns its first access and refresh tokens. The user must belong to the organization.
+func (i *TokenIssuer) StartSession(user *repositories.User, clientID string, orgID int, amr []string) (*TokenResponse, error) {
+	member, err := i.OrgRepo.IsMember(orgID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, errors.New("user does not belong to the organization")
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &repositories.Session{
+		ID:               id,
+		UserID:           user.ID,
+		ClientID:         clientID,
+		OrgID:            orgID,
+		RefreshTokenHash: hashToken(refreshToken),
+		AuthDate:         now,
+		AMR:              amr,
+		CreatedDate:      now,
+		LastActivityDate: now,
+	}
+
+	err = i.SessionRepo.Create(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.issue(user, session, refreshToken)
+}
+
+// Refresh exchanges a refresh token for new tokens, ending the session when it has exceeded
+// the absolute session age or idle timeout.
+func (i *TokenIssuer) Refresh(refreshToken string) (*TokenResponse, error) {
+	session, err := i.SessionRepo.GetByRefreshTokenHash(hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = i.checkSessionAge(session, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// A user removed from the organization can no longer refresh a session in it
+	user, err := i.UserRepo.ForOrg(session.OrgID).Get(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("invalid user id")
+	}
+
+	// Rotate the refresh token so that a leaked one can only be used once
+	refreshToken, err = randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	previousHash := session.RefreshTokenHash
+	session.RefreshTokenHash = hashToken(refreshToken)
+	session.LastActivityDate = now
+	err = i.SessionRepo.Touch(session, previousHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.issue(user, session, refreshToken)
+}
+
+// Reauthenticate records a fresh authentication with the methods in amr for an existing session and
+// returns an access token carrying the new auth_time. The session and its refresh token are kept.
+func (i *TokenIssuer) Reauthenticate(user *repositories.User, sessionID string, amr []string) (*TokenResponse, error) {
+	session, err := i.SessionRepo.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.UserID != user.ID {
+		return nil, errors.New("session does not belong to user")
+	}
+
+	now := time.Now()
+	err = i.checkSessionAge(session, now)
+	if err != nil {
+		return nil, err
+	}
+
+	session.AuthDate = now
+	session.AMR = amr
+	err = i.SessionRepo.UpdateAuthentication(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.issue(user, session, "")
+}
+
+// checkSessionAge ends the session and returns an error when it has exceeded the absolute session age
+// or idle timeout.
+func (i *TokenIssuer) checkSessionAge(session *repositories.Session, now time.Time) error {
+	if now.Sub(session.CreatedDate) > i.Policy.MaxSessionAge || now.Sub(session.LastActivityDate) > i.Policy.IdleTimeout {
+		_ = i.SessionRepo.Revoke(session.ID)
+		return errors.New("session expired")
+	}
+	return nil
+}
+
+// issue signs an access token for user within session.
+func (i *TokenIssuer) issue(user *repositories.User, session *repositories.Session, refreshToken string) (*TokenResponse, error) {
+	// Get the user's access in the session's organization from the database, with denies applied
+	access, denies, err := i.Authorizer.ForOrg(session.OrgID).EffectiveAccesses(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := i.UserRoleRepo.ForOrg(session.OrgID).GetRolesForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode the access in the configured claim format
+	claims, err := accessClaims(i.Policy.ClaimFormat, access, i.AccessRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Denies that carve exceptions out of a wildcard grant travel with the grants
+	if len(denies) > 0 && i.Policy.ClaimFormat != ClaimFormatThin {
+		claims["deny"] = denies
+	}
+
+	// Accesses that depend on request attributes are not granted by the token; listing them tells
+	// services to ask the check API instead of rejecting the request
+	conditional, err := i.Authorizer.ForOrg(session.OrgID).ConditionalAccesses(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(conditional) > 0 && i.Policy.ClaimFormat != ClaimFormatThin {
+		claims["conditional"] = conditional
+	}
+
+	// The token never outlives the session it belongs to
+	now := time.Now()
+	exp := now.Add(i.Policy.accessTokenTTL(roles, session.ClientID))
+	if sessionEnd := session.CreatedDate.Add(i.Policy.MaxSessionAge); exp.After(sessionEnd) {
+		exp = sessionEnd
+	}
+
+	claims["user_id"] = user.ID
+	claims["username"] = user.UserName
+	claims["sid"] = session.ID
+	claims["auth_time"] = session.AuthDate.Unix()
+	claims["amr"] = session.AMR
+	if session.OrgID != repositories.GlobalOrgID {
+		claims["org_id"] = session.OrgID
+	} else if globalAdmin(access, denies) {
+		claims["global_admin"] = true
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
+	if i.Policy.Issuer != "" {
+		claims["iss"] = i.Policy.Issuer
+	}
+	if i.Policy.Audience != "" {
+		claims["aud"] = i.Policy.Audience
+	}
+
+	// Sign the token with the secret key
+	tokenString, err := utils.CreateJWT(i.Policy.SecretKey, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(exp.Sub(now).Seconds()),
+	}, nil
+}
+
+// randomToken returns a random hex encoded 256 bit token.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hash under which a refresh token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Refresh defines a struct for refreshing a session.
+type Refresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Refresh object.
+func (rf *Refresh) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the Refresh object
+	return json.Unmarshal(body, rf)
+}
+
+// ValidateRequest validates the data in the Refresh object and returns any errors that occur during validation.
+func (rf *Refresh) ValidateRequest(ctx context.IContext) error {
+	if rf.RefreshToken == "" {
+		return errors.New("refresh_token field is required")
+	}
+	return nil
+}
+
+// RefreshExecutor defines an APIExecutor for exchanging a refresh token for new tokens.
+type RefreshExecutor struct {
+	Refresh
+	clienthelper.BaseAPIExecutor
+	Issuer *TokenIssuer
+}
+
+// NewRefreshExecutor returns a new instance of RefreshExecutor.
+func NewRefreshExecutor(issuer *TokenIssuer) clienthelper.APIExecutor {
+	return &RefreshExecutor{
+		Issuer: issuer,
+	}
+}
+
+// Controller executes the business logic for refreshing a session and returns the new tokens
+// and any errors that occur during execution.
+func (e *RefreshExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.Issuer.Refresh(e.RefreshToken)
+}
+
+// globalAdmin reports whether the effective accesses and deny exceptions of a user grant GlobalAdminAccess.
+func globalAdmin(access []*repositories.Access, denies []string) bool {
+	names := []string{}
+	for _, a := range access {
+		names = append(names, a.Name)
+	}
+
+	set := permission.NewSet(names)
+	set.Deny(denies)
+	return set.Allows(GlobalAdminAccess)
+}
@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
-	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/princeparmar/contact_manager/ratelimit"
 	"github.com/princeparmar/contact_manager/repositories"
 	"github.com/princeparmar/go-helpers/clienthelper"
 	"github.com/princeparmar/go-helpers/context"
@@ -21,6 +21,7 @@ type User struct {
 	Name   string `json:"name"`
 	Email  string `json:"email"`
 	Mobile string `json:"mobile"`
+	OrgID  int    `json:"-"`
 }
 
 // createUserModel maps User to User model.
@@ -50,6 +51,12 @@ func (u *User) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http
 		}
 	}
 
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	u.OrgID = scope
+
 	// Parse ID from the query parameter
 	id := r.URL.Query().Get("id")
 	i, err := strconv.Atoi(id)
@@ -97,7 +104,7 @@ func NewCreateUserExecutor(repo repositories.UserRepository) clienthelper.APIExe
 // and any errors that occur during execution.
 func (e *CreateUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	user := createUserModel(&e.User)
-	err := e.UserRepo.Create(user)
+	err := e.UserRepo.ForOrg(e.OrgID).Create(user)
 	if err != nil {
 		return nil, err
 	}
@@ -110,19 +117,34 @@ type DeleteUserExecutor struct {
 	User
 	clienthelper.BaseAPIExecutor
 	UserRepo repositories.UserRepository
+	Policy   *TokenPolicy
+	StepUp   StepUp
 }
 
-// NewDeleteUserExecutor returns a new instance of DeleteUserExecutor.
-func NewDeleteUserExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+// NewDeleteUserExecutor returns a new instance of DeleteUserExecutor. The caller must satisfy
+// the step-up requirement.
+func NewDeleteUserExecutor(repo repositories.UserRepository, policy *TokenPolicy, stepUp StepUp) clienthelper.APIExecutor {
 	return &DeleteUserExecutor{
 		UserRepo: repo,
+		Policy:   policy,
+		StepUp:   stepUp,
+	}
+}
+
+// ParseRequest parses the HTTP request and checks that the caller authenticated recently enough.
+func (e *DeleteUserExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.User.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
 	}
+
+	return e.StepUp.Authorize(w, r, e.Policy)
 }
 
 // Controller executes the business logic for deleting a user by ID and returns any errors that occur during execution.
 func (e *DeleteUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	id := e.User.ID
-	err := e.UserRepo.Delete(id)
+	err := e.UserRepo.ForOrg(e.OrgID).Delete(id)
 	if err != nil {
 		return nil, err
 	}
@@ -148,7 +170,7 @@ func NewUpdateUserExecutor(repo repositories.UserRepository) clienthelper.APIExe
 // and any errors that occur during execution.
 func (e *UpdateUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	user := createUserModel(&e.User)
-	err := e.UserRepo.Update(user)
+	err := e.UserRepo.ForOrg(e.OrgID).Update(user)
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +196,7 @@ func NewGetUserExecutor(repo repositories.UserRepository) clienthelper.APIExecut
 // and any errors that occur during execution.
 func (e *GetUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	id := e.User.ID
-	user, err := e.UserRepo.Get(id)
+	user, err := e.UserRepo.ForOrg(e.OrgID).Get(id)
 	if err != nil {
 		return nil, err
 	}
@@ -185,6 +207,7 @@ func (e *GetUserExecutor) Controller(ctx context.IContext) (interface{}, error)
 // GetAllUsersExecutor defines an APIExecutor for getting all users.
 type GetAllUsersExecutor struct {
 	clienthelper.BaseAPIExecutor
+	OrgID    int
 	UserRepo repositories.UserRepository
 }
 
@@ -195,10 +218,20 @@ func NewGetAllUsersExecutor(repo repositories.UserRepository) clienthelper.APIEx
 	}
 }
 
+// ParseRequest reads the organization the request acts in.
+func (e *GetAllUsersExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	e.OrgID = scope
+	return nil
+}
+
 // Controller executes the business logic for getting all users and returns the users
 // and any errors that occur during execution.
 func (e *GetAllUsersExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	return e.UserRepo.GetAll()
+	return e.UserRepo.ForOrg(e.OrgID).GetAll()
 }
 
 // UserAccessExecutor defines an APIExecutor for getting a list of accesses based on the user ID.
@@ -218,7 +251,7 @@ func NewUserAccessExecutor(repo repositories.UserRoleRepository) clienthelper.AP
 // Controller executes the business logic for getting a list of accesses based on the user ID and returns the accesses
 // and any errors that occur during execution.
 func (e *UserAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	accesses, err := e.userRoleRepository.GetAllAccess(e.User.ID)
+	accesses, err := e.userRoleRepository.ForOrg(e.OrgID).GetAllAccess(e.User.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -234,6 +267,7 @@ type UserPassword struct {
 	ID          int
 	OldPassword string `json:"old_password"`
 	Password    string `json:"password"`
+	OrgID       int    `json:"-"`
 }
 
 // ParseRequest parses the HTTP request and extracts any relevant data into the UserPassword object.
@@ -247,6 +281,11 @@ func (u *UserPassword) ParseRequest(ctx context.IContext, w http.ResponseWriter,
 
 	u.ID = i
 
+	u.OrgID, err = orgID(r)
+	if err != nil {
+		return err
+	}
+
 	// Read the request body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -277,18 +316,41 @@ type UpdateUserPasswordExecutor struct {
 	UserPassword
 	clienthelper.BaseAPIExecutor
 	UserRepo repositories.UserRepository
+	Policy   *TokenPolicy
+	StepUp   StepUp
 }
 
-// NewUpdateUserPasswordExecutor returns a new instance of UpdateUserPasswordExecutor.
-func NewUpdateUserPasswordExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+// NewUpdateUserPasswordExecutor returns a new instance of UpdateUserPasswordExecutor. The caller must
+// satisfy the step-up requirement.
+func NewUpdateUserPasswordExecutor(repo repositories.UserRepository, policy *TokenPolicy, stepUp StepUp) clienthelper.APIExecutor {
 	return &UpdateUserPasswordExecutor{
 		UserRepo: repo,
+		Policy:   policy,
+		StepUp:   stepUp,
 	}
 }
 
+// ParseRequest parses the HTTP request and checks that the caller authenticated recently enough.
+func (e *UpdateUserPasswordExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.UserPassword.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	return e.StepUp.Authorize(w, r, e.Policy)
+}
+
 // Controller executes the business logic for updating a user's password by ID and returns the updated user
 // and any errors that occur during execution.
 func (e *UpdateUserPasswordExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	user, err := e.UserRepo.ForOrg(e.OrgID).Get(e.UserPassword.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("invalid user id")
+	}
+
 	password, err := e.UserRepo.GetPassword(e.UserPassword.ID)
 	if err != nil {
 		return nil, err
@@ -307,8 +369,15 @@ func (e *UpdateUserPasswordExecutor) Controller(ctx context.IContext) (interface
 
 // Login defines a struct for user login.
 type Login struct {
-	UserName string `json:"username"`
-	Password string `json:"password"`
+	UserName    string `json:"username"`
+	Password    string `json:"password"`
+	ClientID    string `json:"client_id"`
+	OrgID       int    `json:"org_id"`
+	OTP         string `json:"otp"`
+	TrustDevice bool   `json:"trust_device"`
+	ClientIP    string `json:"-"`
+	UserAgent   string `json:"-"`
+	DeviceID    string `json:"-"`
 }
 
 // ParseRequest parses the HTTP request and extracts any relevant data into the Login object.
@@ -320,7 +389,25 @@ func (l *Login) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *htt
 	}
 
 	// Unmarshal the request body into the Login object
-	return json.Unmarshal(body, l)
+	err = json.Unmarshal(body, l)
+	if err != nil {
+		return err
+	}
+
+	l.ClientIP = clientIP(r)
+	l.UserAgent = r.UserAgent()
+
+	return nil
+}
+
+// clientIP returns the IP address of the client that sent the request. Deployments behind a
+// proxy are expected to rewrite RemoteAddr before the request reaches the executors.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 // ValidateRequest validates the data in the Login object and returns any errors that occur during validation.
@@ -342,29 +429,58 @@ func (l *Login) ValidateRequest(ctx context.IContext) error {
 type LoginExecutor struct {
 	Login
 	clienthelper.BaseAPIExecutor
-	UserRepo     repositories.UserRepository
-	UserRoleRepo repositories.UserRoleRepository
-	AccessRepo   repositories.AccessRepository
-
-	SecretKey string
+	UserRepo repositories.UserRepository
+	Issuer   *TokenIssuer
+	Guard    *ratelimit.LoginGuard
+	Devices  *DeviceTracker
 }
 
-// NewLoginExecutor returns a new instance of LoginExecutor.
-func NewLoginExecutor(userRepo repositories.UserRepository, userRoleRepo repositories.UserRoleRepository, accessRepo repositories.AccessRepository, secretKey string) clienthelper.APIExecutor {
+// NewLoginExecutor returns a new instance of LoginExecutor. The issuer, guard and device tracker are
+// shared between requests; the guard and device tracker may be nil to disable rate limiting and device tracking.
+func NewLoginExecutor(userRepo repositories.UserRepository, issuer *TokenIssuer, guard *ratelimit.LoginGuard, devices *DeviceTracker) clienthelper.APIExecutor {
 	return &LoginExecutor{
-		UserRepo:     userRepo,
-		UserRoleRepo: userRoleRepo,
-		AccessRepo:   accessRepo,
-		SecretKey:    secretKey,
+		UserRepo: userRepo,
+		Issuer:   issuer,
+		Guard:    guard,
+		Devices:  devices,
 	}
 }
 
-// Controller executes the business logic for user login and returns a JWT token containing user information
-// and any errors that occur during execution.
+// ParseRequest parses the login request and rejects it with 429 Too Many Requests
+// when the client IP or the account is over its rate limit.
+func (e *LoginExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	err := e.Login.ParseRequest(ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	if e.Devices != nil {
+		e.DeviceID, err = deviceID(w, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.Guard == nil {
+		return nil
+	}
+
+	err = e.Guard.Check(e.ClientIP, e.UserName)
+	var limitErr *ratelimit.Error
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(limitErr.RetryAfterSeconds()))
+	}
+
+	return err
+}
+
+// Controller executes the business logic for user login and returns a JWT token containing user information,
+// a refresh token for the new session and any errors that occur during execution.
 func (e *LoginExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	// Get the user from the database
 	user, err := e.UserRepo.GetUserByUserName(e.UserName)
 	if err != nil {
+		e.loginFailed()
 		return nil, err
 	}
 
@@ -376,23 +492,44 @@ func (e *LoginExecutor) Controller(ctx context.IContext) (interface{}, error) {
 
 	// Validate the password
 	if password != utils.MD5Hash(e.Password) {
+		e.loginFailed()
 		return nil, errors.New("invalid username or password")
 	}
 
-	// Get the user's access from the database
-	access, _ := e.UserRoleRepo.GetAllAccess(user.ID)
+	amr := []string{AMRPassword}
+	if e.Devices != nil {
+		device, known, err := e.Devices.Identify(user, e.DeviceID, e.UserAgent)
+		if err != nil {
+			return nil, err
+		}
 
-	// Sign the token with the secret key
-	tokenString, err := utils.CreateJWT(e.SecretKey, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.UserName,
-		"access":   access,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // Set token expiration time to 24 hours
-	})
-	if err != nil {
-		return nil, err
+		amr, err = e.Devices.VerifySecondFactor(user, device, e.OTP, e.TrustDevice)
+		if err != nil {
+			var mfaErr *MFARequiredError
+			if !errors.As(err, &mfaErr) {
+				e.loginFailed()
+			}
+			return nil, err
+		}
+
+		// Only logins that passed every check are recorded, and new devices are announced to the user
+		err = e.Devices.Record(user, device, known, e.ClientIP)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Return the JWT token
-	return map[string]string{"token": tokenString}, nil
+	if e.Guard != nil {
+		e.Guard.Succeeded(e.ClientIP, e.UserName)
+	}
+
+	// Start a new session in the selected organization and return its tokens
+	return e.Issuer.StartSession(user, e.ClientID, e.OrgID, amr)
+}
+
+// loginFailed records a failed login attempt with the guard.
+func (e *LoginExecutor) loginFailed() {
+	if e.Guard != nil {
+		e.Guard.Failed(e.ClientIP, e.UserName)
+	}
 }
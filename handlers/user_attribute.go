@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// UserAttribute defines a struct for an attribute of a user that conditional grants are evaluated
+// against, available to conditions as user.<name>.
+type UserAttribute struct {
+	UserID int         `json:"-"`
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	OrgID  int         `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the UserAttribute object.
+// The user is read from the query; the attribute is read from the body of POST requests and from the
+// name query parameter otherwise.
+func (ua *UserAttribute) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the UserAttribute object
+		err = json.Unmarshal(body, ua)
+		if err != nil {
+			return err
+		}
+	} else {
+		ua.Name = r.URL.Query().Get("name")
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		return errors.New("invalid user_id in query")
+	}
+	ua.UserID = userID
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	ua.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the UserAttribute object and returns any errors that occur during validation.
+func (ua *UserAttribute) ValidateRequest(ctx context.IContext) error {
+	if ua.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+
+	if ua.Name == "id" {
+		return errors.New("id is a reserved attribute name")
+	}
+
+	return nil
+}
+
+// getMember retrieves a user of the organization a request acts in, reporting other users as missing.
+func getMember(repo *repositories.UserRepository, id int) (*repositories.User, error) {
+	user, err := repo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+// SetUserAttributeExecutor defines an APIExecutor for setting an attribute of a user.
+type SetUserAttributeExecutor struct {
+	UserAttribute
+	clienthelper.BaseAPIExecutor
+	UserRepo      repositories.UserRepository
+	AttributeRepo repositories.UserAttributeRepository
+}
+
+// NewSetUserAttributeExecutor returns a new instance of SetUserAttributeExecutor.
+func NewSetUserAttributeExecutor(userRepo repositories.UserRepository, attributeRepo repositories.UserAttributeRepository) clienthelper.APIExecutor {
+	return &SetUserAttributeExecutor{
+		UserRepo:      userRepo,
+		AttributeRepo: attributeRepo,
+	}
+}
+
+// ValidateRequest validates that an attribute name is given.
+func (e *SetUserAttributeExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	return e.UserAttribute.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for setting a user attribute and returns the attribute
+// and any errors that occur during execution.
+func (e *SetUserAttributeExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getMember(e.UserRepo.ForOrg(e.OrgID), e.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.AttributeRepo.Set(e.UserID, e.Name, e.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e.UserAttribute, nil
+}
+
+// DeleteUserAttributeExecutor defines an APIExecutor for removing an attribute of a user.
+type DeleteUserAttributeExecutor struct {
+	UserAttribute
+	clienthelper.BaseAPIExecutor
+	UserRepo      repositories.UserRepository
+	AttributeRepo repositories.UserAttributeRepository
+}
+
+// NewDeleteUserAttributeExecutor returns a new instance of DeleteUserAttributeExecutor.
+func NewDeleteUserAttributeExecutor(userRepo repositories.UserRepository, attributeRepo repositories.UserAttributeRepository) clienthelper.APIExecutor {
+	return &DeleteUserAttributeExecutor{
+		UserRepo:      userRepo,
+		AttributeRepo: attributeRepo,
+	}
+}
+
+// ValidateRequest validates that an attribute name is given.
+func (e *DeleteUserAttributeExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	return e.UserAttribute.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for removing a user attribute and returns any errors that occur during execution.
+func (e *DeleteUserAttributeExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getMember(e.UserRepo.ForOrg(e.OrgID), e.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, e.AttributeRepo.Delete(e.UserID, e.Name)
+}
+
+// GetUserAttributesExecutor defines an APIExecutor for listing the attributes of a user.
+type GetUserAttributesExecutor struct {
+	UserAttribute
+	clienthelper.BaseAPIExecutor
+	UserRepo      repositories.UserRepository
+	AttributeRepo repositories.UserAttributeRepository
+}
+
+// NewGetUserAttributesExecutor returns a new instance of GetUserAttributesExecutor.
+func NewGetUserAttributesExecutor(userRepo repositories.UserRepository, attributeRepo repositories.UserAttributeRepository) clienthelper.APIExecutor {
+	return &GetUserAttributesExecutor{
+		UserRepo:      userRepo,
+		AttributeRepo: attributeRepo,
+	}
+}
+
+// Controller executes the business logic for listing user attributes and returns the attributes by name
+// and any errors that occur during execution.
+func (e *GetUserAttributesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getMember(e.UserRepo.ForOrg(e.OrgID), e.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.AttributeRepo.GetForUser(e.UserID)
+}
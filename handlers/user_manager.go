@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// UserManager defines a struct for the manager of a user, who reviews the user's role assignments in
+// review campaigns that review by manager.
+type UserManager struct {
+	UserID    int `json:"-"`
+	ManagerID int `json:"manager_id"`
+	OrgID     int `json:"-"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the UserManager object.
+// The user is read from the user_id query parameter and the manager from the body of POST requests.
+func (um *UserManager) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the UserManager object
+		err = json.Unmarshal(body, um)
+		if err != nil {
+			return err
+		}
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		return errors.New("invalid user_id in query")
+	}
+	um.UserID = userID
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	um.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the UserManager object and returns any errors that occur during validation.
+func (um *UserManager) ValidateRequest(ctx context.IContext) error {
+	if um.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+
+	if um.ManagerID == um.UserID {
+		return errors.New("users cannot manage themselves")
+	}
+
+	return nil
+}
+
+// SetUserManagerExecutor defines an APIExecutor for setting or removing the manager of a user.
+type SetUserManagerExecutor struct {
+	UserManager
+	clienthelper.BaseAPIExecutor
+	UserRepo repositories.UserRepository
+}
+
+// NewSetUserManagerExecutor returns a new instance of SetUserManagerExecutor.
+func NewSetUserManagerExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+	return &SetUserManagerExecutor{
+		UserRepo: repo,
+	}
+}
+
+// Controller executes the business logic for setting the manager of a user and returns the manager link
+// and any errors that occur during execution. A zero manager_id removes the manager.
+func (e *SetUserManagerExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	userRepo := e.UserRepo.ForOrg(e.OrgID)
+	if e.ManagerID != 0 {
+		_, err := getMember(userRepo, e.ManagerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := userRepo.SetManager(e.UserID, e.ManagerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e.UserManager, nil
+}
+
+// GetUserReportsExecutor defines an APIExecutor for listing the users a user manages.
+type GetUserReportsExecutor struct {
+	UserManager
+	clienthelper.BaseAPIExecutor
+	UserRepo repositories.UserRepository
+}
+
+// NewGetUserReportsExecutor returns a new instance of GetUserReportsExecutor.
+func NewGetUserReportsExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+	return &GetUserReportsExecutor{
+		UserRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the direct reports of a user and returns the reports
+// and any errors that occur during execution.
+func (e *GetUserReportsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	userRepo := e.UserRepo.ForOrg(e.OrgID)
+	_, err := getMember(userRepo, e.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return userRepo.GetReports(e.UserID)
+}
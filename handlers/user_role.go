@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/condition"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// UserRole defines a struct for an assignment of a role to a user. A nil StartDate means the
+// assignment is effective immediately and a nil ExpiryDate means it does not expire. A Condition
+// restricts the assignment to requests whose attributes satisfy it; an empty one removes it.
+type UserRole struct {
+	UserID     int
+	RoleID     int
+	StartDate  *time.Time `json:"start_date"`
+	ExpiryDate *time.Time `json:"expiry_date"`
+	Condition  *string    `json:"condition"`
+	OrgID      int        `json:"-"`
+}
+
+// createUserRoleModel maps UserRole to UserRole model.
+func createUserRoleModel(ur *UserRole) *repositories.UserRole {
+	userRole := &repositories.UserRole{
+		UserID: ur.UserID,
+		RoleID: ur.RoleID,
+	}
+	if ur.StartDate != nil {
+		userRole.StartDate = *ur.StartDate
+	}
+	if ur.ExpiryDate != nil {
+		userRole.ExpiryDate = *ur.ExpiryDate
+	}
+	if ur.Condition != nil {
+		userRole.Condition = *ur.Condition
+	}
+	return userRole
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the UserRole object.
+// Both IDs are read from the query; the ones an executor does not use may be omitted.
+func (ur *UserRole) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the UserRole object
+		if len(body) > 0 {
+			err = json.Unmarshal(body, ur)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	query := r.URL.Query()
+
+	if userID := query.Get("user_id"); userID != "" {
+		i, err := strconv.Atoi(userID)
+		if err != nil {
+			return errors.New("invalid user_id in query")
+		}
+		ur.UserID = i
+	}
+
+	if roleID := query.Get("role_id"); roleID != "" {
+		i, err := strconv.Atoi(roleID)
+		if err != nil {
+			return errors.New("invalid role_id in query")
+		}
+		ur.RoleID = i
+	}
+
+	scope, err := orgID(r)
+	if err != nil {
+		return err
+	}
+	ur.OrgID = scope
+
+	return nil
+}
+
+// ValidateRequest validates the data in the UserRole object and returns any errors that occur during validation.
+func (ur *UserRole) ValidateRequest(ctx context.IContext) error {
+	if ur.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+
+	if ur.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+
+	if ur.ExpiryDate != nil && !ur.ExpiryDate.After(time.Now()) {
+		return errors.New("expiry_date must be in the future")
+	}
+
+	if ur.StartDate != nil && ur.ExpiryDate != nil && !ur.ExpiryDate.After(*ur.StartDate) {
+		return errors.New("expiry_date must be after start_date")
+	}
+
+	return validateCondition(ur.Condition)
+}
+
+// validateCondition validates an optional condition expression. An empty expression means no condition.
+func validateCondition(expr *string) error {
+	if expr == nil || *expr == "" {
+		return nil
+	}
+
+	_, err := condition.Parse(*expr)
+	if err != nil {
+		return errors.New("invalid condition: " + err.Error())
+	}
+	return nil
+}
+
+// getUserRole retrieves the assignment of a role to a user, reporting a missing assignment as a validation error.
+func getUserRole(repo repositories.UserRoleRepository, userID, roleID int) (*repositories.UserRole, error) {
+	userRole, err := repo.Get(userID, roleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("role is not assigned to user")
+	}
+	return userRole, err
+}
+
+// AssignUserRoleExecutor defines an APIExecutor for assigning a role to a user.
+type AssignUserRoleExecutor struct {
+	UserRole
+	clienthelper.BaseAPIExecutor
+	UserRepo     repositories.UserRepository
+	RoleRepo     repositories.RoleRepository
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewAssignUserRoleExecutor returns a new instance of AssignUserRoleExecutor.
+func NewAssignUserRoleExecutor(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, userRoleRepo repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &AssignUserRoleExecutor{
+		UserRepo:     userRepo,
+		RoleRepo:     roleRepo,
+		UserRoleRepo: userRoleRepo,
+	}
+}
+
+// Controller executes the business logic for assigning a role to a user and returns the assignment
+// and any errors that occur during execution.
+func (e *AssignUserRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	// Roles are only assigned to members of the organization, and only roles it can see
+	user, err := e.UserRepo.ForOrg(e.OrgID).Get(e.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	_, err = getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	userRoleRepo := e.UserRoleRepo.ForOrg(e.OrgID)
+	_, err = userRoleRepo.Get(e.UserID, e.RoleID)
+	if err == nil {
+		return nil, errors.New("role is already assigned to user")
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	userRole := createUserRoleModel(&e.UserRole)
+	err = userRoleRepo.Create(userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return userRole, nil
+}
+
+// UpdateUserRoleExpiryExecutor defines an APIExecutor for extending or shortening the expiry of a role assignment.
+type UpdateUserRoleExpiryExecutor struct {
+	UserRole
+	clienthelper.BaseAPIExecutor
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewUpdateUserRoleExpiryExecutor returns a new instance of UpdateUserRoleExpiryExecutor.
+func NewUpdateUserRoleExpiryExecutor(repo repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &UpdateUserRoleExpiryExecutor{
+		UserRoleRepo: repo,
+	}
+}
+
+// Controller executes the business logic for updating the expiry of a role assignment and returns the assignment
+// and any errors that occur during execution. A null expiry_date makes the assignment permanent; the start
+// date and the condition are kept unless new ones are given.
+func (e *UpdateUserRoleExpiryExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	userRoleRepo := e.UserRoleRepo.ForOrg(e.OrgID)
+	existing, err := getUserRole(userRoleRepo, e.UserID, e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	userRole := createUserRoleModel(&e.UserRole)
+	if e.StartDate == nil {
+		userRole.StartDate = existing.StartDate
+	}
+	if e.Condition == nil {
+		userRole.Condition = existing.Condition
+	}
+	if !userRole.StartDate.IsZero() && !userRole.ExpiryDate.IsZero() && !userRole.ExpiryDate.After(userRole.StartDate) {
+		return nil, errors.New("expiry_date must be after start_date")
+	}
+
+	err = userRoleRepo.Update(userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return userRole, nil
+}
+
+// RevokeUserRoleExecutor defines an APIExecutor for revoking a role from a user.
+type RevokeUserRoleExecutor struct {
+	UserRole
+	clienthelper.BaseAPIExecutor
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewRevokeUserRoleExecutor returns a new instance of RevokeUserRoleExecutor.
+func NewRevokeUserRoleExecutor(repo repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &RevokeUserRoleExecutor{
+		UserRoleRepo: repo,
+	}
+}
+
+// Controller executes the business logic for revoking a role from a user and returns any errors that occur during execution.
+func (e *RevokeUserRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	userRoleRepo := e.UserRoleRepo.ForOrg(e.OrgID)
+	_, err := getUserRole(userRoleRepo, e.UserID, e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, userRoleRepo.Delete(e.UserID, e.RoleID)
+}
+
+// GetUserRolesExecutor defines an APIExecutor for listing the roles of a user with their expiry.
+type GetUserRolesExecutor struct {
+	UserRole
+	clienthelper.BaseAPIExecutor
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewGetUserRolesExecutor returns a new instance of GetUserRolesExecutor.
+func NewGetUserRolesExecutor(repo repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &GetUserRolesExecutor{
+		UserRoleRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a user is given.
+func (e *GetUserRolesExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the roles of a user and returns the assignments
+// and any errors that occur during execution.
+func (e *GetUserRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.UserRoleRepo.ForOrg(e.OrgID).GetAssignmentsForUser(e.UserID)
+}
+
+// GetRoleMembersExecutor defines an APIExecutor for listing the users holding a role.
+type GetRoleMembersExecutor struct {
+	UserRole
+	clienthelper.BaseAPIExecutor
+	RoleRepo     repositories.RoleRepository
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewGetRoleMembersExecutor returns a new instance of GetRoleMembersExecutor.
+func NewGetRoleMembersExecutor(roleRepo repositories.RoleRepository, userRoleRepo repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &GetRoleMembersExecutor{
+		RoleRepo:     roleRepo,
+		UserRoleRepo: userRoleRepo,
+	}
+}
+
+// ValidateRequest validates that a role is given.
+func (e *GetRoleMembersExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the members of a role and returns the assignments
+// and any errors that occur during execution.
+func (e *GetRoleMembersExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.UserRoleRepo.ForOrg(e.OrgID).GetAssignmentsForRole(e.RoleID)
+}
+
+// GetUpcomingUserRolesExecutor defines an APIExecutor for listing the assignments of a user that have not started yet.
+type GetUpcomingUserRolesExecutor struct {
+	UserRole
+	clienthelper.BaseAPIExecutor
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewGetUpcomingUserRolesExecutor returns a new instance of GetUpcomingUserRolesExecutor.
+func NewGetUpcomingUserRolesExecutor(repo repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &GetUpcomingUserRolesExecutor{
+		UserRoleRepo: repo,
+	}
+}
+
+// ValidateRequest validates that a user is given.
+func (e *GetUpcomingUserRolesExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.UserID == 0 {
+		return errors.New("user_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the upcoming assignments of a user and returns the assignments
+// and any errors that occur during execution.
+func (e *GetUpcomingUserRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.UserRoleRepo.ForOrg(e.OrgID).GetUpcomingForUser(e.UserID)
+}
+
+// GetUpcomingRoleMembersExecutor defines an APIExecutor for listing the assignments of a role that have not started yet.
+type GetUpcomingRoleMembersExecutor struct {
+	UserRole
+	clienthelper.BaseAPIExecutor
+	RoleRepo     repositories.RoleRepository
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewGetUpcomingRoleMembersExecutor returns a new instance of GetUpcomingRoleMembersExecutor.
+func NewGetUpcomingRoleMembersExecutor(roleRepo repositories.RoleRepository, userRoleRepo repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &GetUpcomingRoleMembersExecutor{
+		RoleRepo:     roleRepo,
+		UserRoleRepo: userRoleRepo,
+	}
+}
+
+// ValidateRequest validates that a role is given.
+func (e *GetUpcomingRoleMembersExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.RoleID == 0 {
+		return errors.New("role_id is required")
+	}
+	return nil
+}
+
+// Controller executes the business logic for listing the upcoming assignments of a role and returns the assignments
+// and any errors that occur during execution.
+func (e *GetUpcomingRoleMembersExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := getRole(e.RoleRepo.ForOrg(e.OrgID), e.RoleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.UserRoleRepo.ForOrg(e.OrgID).GetUpcomingForRole(e.RoleID)
+}
@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/princeparmar/contact_manager/notifier"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// ReviewDeadlineSweeper periodically closes the access review campaigns past their deadline, revoking
+// the undecided items of campaigns configured to auto-revoke.
+type ReviewDeadlineSweeper struct {
+	CampaignRepo repositories.ReviewCampaignRepository
+	Notifier     notifier.Notifier
+
+	// Interval is the time between two sweeps.
+	Interval time.Duration
+}
+
+// NewReviewDeadlineSweeper returns a new instance of ReviewDeadlineSweeper.
+func NewReviewDeadlineSweeper(campaignRepo repositories.ReviewCampaignRepository, n notifier.Notifier, interval time.Duration) *ReviewDeadlineSweeper {
+	return &ReviewDeadlineSweeper{
+		CampaignRepo: campaignRepo,
+		Notifier:     n,
+		Interval:     interval,
+	}
+}
+
+// Run sweeps immediately and then every Interval until ctx is done.
+func (s *ReviewDeadlineSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sweep(); err != nil {
+			log.Printf("review deadline sweep failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep closes the overdue campaigns and notifies the holders of the revoked assignments. Notifications
+// that fail are logged and not retried.
+func (s *ReviewDeadlineSweeper) Sweep() error {
+	campaigns, err := s.CampaignRepo.GetOverdue()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range campaigns {
+		revoked, err := s.CampaignRepo.ForOrg(c.OrgID).Close(c.ID)
+		if errors.Is(err, repositories.ErrCampaignCompleted) {
+			// Completed by its last decision since it was listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Printf("closed review campaign %d, revoked %d unreviewed assignments", c.ID, len(revoked))
+
+		if s.Notifier == nil {
+			continue
+		}
+
+		for _, item := range revoked {
+			err = s.Notifier.Notify(&notifier.Notification{
+				UserID:  item.UserID,
+				Subject: "Role assignment revoked",
+				Message: fmt.Sprintf("Your role %s was revoked because it was not reviewed in the access review %s.", item.RoleName, c.Name),
+			})
+			// The assignment is already revoked; a lost notification must not hold up the other campaigns
+			if err != nil {
+				log.Printf("notifying user %d of revoked role %d failed: %v", item.UserID, item.RoleID, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/princeparmar/contact_manager/notifier"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// RoleExpirySweeper periodically archives expired role assignments and warns users whose
+// assignments are about to expire.
+type RoleExpirySweeper struct {
+	UserRoleRepo repositories.UserRoleRepository
+	Notifier     notifier.Notifier
+
+	// Interval is the time between two sweeps.
+	Interval time.Duration
+	// WarnBefore is how long before expiry the holder is warned. Zero disables warnings.
+	WarnBefore time.Duration
+}
+
+// NewRoleExpirySweeper returns a new instance of RoleExpirySweeper.
+func NewRoleExpirySweeper(userRoleRepo repositories.UserRoleRepository, n notifier.Notifier, interval, warnBefore time.Duration) *RoleExpirySweeper {
+	return &RoleExpirySweeper{
+		UserRoleRepo: userRoleRepo,
+		Notifier:     n,
+		Interval:     interval,
+		WarnBefore:   warnBefore,
+	}
+}
+
+// Run sweeps immediately and then every Interval until ctx is done.
+func (s *RoleExpirySweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sweep(); err != nil {
+			log.Printf("role expiry sweep failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep sends the pending expiry warnings and archives the expired assignments.
+func (s *RoleExpirySweeper) Sweep() error {
+	if s.WarnBefore > 0 && s.Notifier != nil {
+		err := s.warn()
+		if err != nil {
+			return err
+		}
+	}
+
+	archived, err := s.UserRoleRepo.ArchiveExpired()
+	if err != nil {
+		return err
+	}
+
+	if archived > 0 {
+		log.Printf("archived %d expired role assignments", archived)
+	}
+
+	return nil
+}
+
+// warn notifies the holders of assignments expiring within WarnBefore. A failed notification is logged and
+// does not stop the sweep; the assignment is not marked as warned, so the warning is retried next sweep.
+func (s *RoleExpirySweeper) warn() error {
+	assignments, err := s.UserRoleRepo.GetExpiringAssignments(time.Now().Add(s.WarnBefore))
+	if err != nil {
+		return err
+	}
+
+	for _, a := range assignments {
+		err = s.Notifier.Notify(&notifier.Notification{
+			UserID:  a.UserID,
+			Subject: "Role assignment expiring",
+			Message: fmt.Sprintf("Your role %s expires on %s.", a.RoleName, a.ExpiryDate.Format(time.RFC1123)),
+		})
+		if err != nil {
+			log.Printf("warning user %d of expiring role %d failed: %v", a.UserID, a.RoleID, err)
+			continue
+		}
+
+		err = s.UserRoleRepo.MarkExpiryWarned(a)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
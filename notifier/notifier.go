@@ -0,0 +1,32 @@
+package notifier
+
+import (
+	"log"
+)
+
+// Notification defines a message sent to a user or, when UserID is zero, to the channel the
+// notifier is configured for.
+type Notification struct {
+	UserID  int
+	Subject string
+	Message string
+}
+
+// Notifier delivers notifications. Implementations may send email, push messages or chat messages.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// LogNotifier is a Notifier that writes notifications to the standard logger.
+type LogNotifier struct{}
+
+// NewLogNotifier returns a new instance of LogNotifier.
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+// Notify implements Notifier.
+func (n *LogNotifier) Notify(notification *Notification) error {
+	log.Printf("notification for user %d: %s: %s", notification.UserID, notification.Subject, notification.Message)
+	return nil
+}
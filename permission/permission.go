@@ -0,0 +1,163 @@
+package permission
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Wildcard matches any value of a segment.
+const Wildcard = "*"
+
+// LegacyNamespace and LegacyAction are used for free-text access names that predate structured permissions.
+const (
+	LegacyNamespace = "legacy"
+	LegacyAction    = "access"
+)
+
+var segmentPattern = regexp.MustCompile(`^(\*|[a-z0-9][a-z0-9_.-]*)$`)
+
+// Permission is an access expressed as namespace:resource:action, e.g. "contacts:entry:read".
+// Any segment of a granted permission may be "*" to match every value, e.g. "contacts:*:read".
+type Permission struct {
+	Namespace string
+	Resource  string
+	Action    string
+}
+
+// Parse parses a permission from its "namespace:resource:action" form.
+func Parse(name string) (Permission, error) {
+	parts := strings.Split(name, ":")
+	if len(parts) != 3 {
+		return Permission{}, fmt.Errorf("permission %q must have the form namespace:resource:action", name)
+	}
+
+	for _, part := range parts {
+		if !segmentPattern.MatchString(part) {
+			return Permission{}, fmt.Errorf("permission %q has an invalid segment %q", name, part)
+		}
+	}
+
+	return Permission{Namespace: parts[0], Resource: parts[1], Action: parts[2]}, nil
+}
+
+// FromLegacyName converts a free-text access name into a Permission. Names that already parse are
+// returned unchanged; others become legacy:<normalised name>:access.
+func FromLegacyName(name string) Permission {
+	if p, err := Parse(name); err == nil {
+		return p
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	resource := strings.TrimLeft(b.String(), "_.-")
+	if resource == "" {
+		resource = "unnamed"
+	}
+
+	return Permission{Namespace: LegacyNamespace, Resource: resource, Action: LegacyAction}
+}
+
+// String returns the "namespace:resource:action" form of the permission.
+func (p Permission) String() string {
+	return p.Namespace + ":" + p.Resource + ":" + p.Action
+}
+
+// Matches reports whether p, as a grant, covers the required permission.
+func (p Permission) Matches(required Permission) bool {
+	return matchSegment(p.Namespace, required.Namespace) &&
+		matchSegment(p.Resource, required.Resource) &&
+		matchSegment(p.Action, required.Action)
+}
+
+func matchSegment(granted, required string) bool {
+	return granted == Wildcard || granted == required
+}
+
+// Intersects reports whether some permission is matched by both p and other, that is whether every
+// segment of the two is equal or "*" on either side.
+func (p Permission) Intersects(other Permission) bool {
+	return intersectSegment(p.Namespace, other.Namespace) &&
+		intersectSegment(p.Resource, other.Resource) &&
+		intersectSegment(p.Action, other.Action)
+}
+
+func intersectSegment(a, b string) bool {
+	return a == Wildcard || b == Wildcard || a == b
+}
+
+// Set is a set of granted and denied permissions used to answer permission checks.
+// Denies override grants.
+type Set struct {
+	grants []Permission
+	denies []Permission
+}
+
+// NewSet builds a Set from granted access names. Names that are not structured permissions are
+// converted with FromLegacyName.
+func NewSet(names []string) *Set {
+	s := &Set{}
+	for _, name := range names {
+		s.grants = append(s.grants, FromLegacyName(name))
+	}
+	return s
+}
+
+// Deny adds denied access names to the set.
+func (s *Set) Deny(names []string) {
+	for _, name := range names {
+		s.denies = append(s.denies, FromLegacyName(name))
+	}
+}
+
+// Allows reports whether any grant and no deny in the set matches the required access name.
+func (s *Set) Allows(required string) bool {
+	_, ok := s.Match(required)
+	return ok
+}
+
+// Match returns the first grant in the set that matches the required access name. It reports
+// false when no grant matches or when a deny matches.
+func (s *Set) Match(required string) (Permission, bool) {
+	if _, denied := s.DeniedBy(required); denied {
+		return Permission{}, false
+	}
+
+	req := parseRequired(required)
+	for _, grant := range s.grants {
+		if grant.Matches(req) {
+			return grant, true
+		}
+	}
+
+	return Permission{}, false
+}
+
+// DeniedBy returns the first deny in the set that matches the required access name.
+func (s *Set) DeniedBy(required string) (Permission, bool) {
+	req := parseRequired(required)
+	for _, deny := range s.denies {
+		if deny.Matches(req) {
+			return deny, true
+		}
+	}
+
+	return Permission{}, false
+}
+
+// parseRequired parses a required access name, accepting free-text names as legacy permissions.
+func parseRequired(required string) Permission {
+	req, err := Parse(required)
+	if err != nil {
+		return FromLegacyName(required)
+	}
+	return req
+}
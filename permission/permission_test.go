@@ -0,0 +1,147 @@
+package permission
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Permission
+		wantErr bool
+	}{
+		{"structured", "contacts:entry:read", Permission{"contacts", "entry", "read"}, false},
+		{"wildcard", "contacts:*:read", Permission{"contacts", "*", "read"}, false},
+		{"punctuation", "app.v2:my-res_1:write", Permission{"app.v2", "my-res_1", "write"}, false},
+		{"two segments", "contacts:read", Permission{}, true},
+		{"four segments", "a:b:c:d", Permission{}, true},
+		{"empty segment", "contacts::read", Permission{}, true},
+		{"upper case", "Contacts:entry:read", Permission{}, true},
+		{"partial wildcard", "contacts:ent*:read", Permission{}, true},
+		{"free text", "User Admin", Permission{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromLegacyName(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"structured unchanged", "contacts:entry:read", "contacts:entry:read"},
+		{"spaces", "User Admin", "legacy:user_admin:access"},
+		{"underscores", "user_admin", "legacy:user_admin:access"},
+		{"surrounding space", "  Reports  ", "legacy:reports:access"},
+		{"leading punctuation", "--export", "legacy:export:access"},
+		{"colons", "a:b", "legacy:a_b:access"},
+		{"nothing left", "!!!", "legacy:unnamed:access"},
+		{"empty", "", "legacy:unnamed:access"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromLegacyName(tt.input)
+			if got.String() != tt.want {
+				t.Errorf("FromLegacyName(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if _, err := Parse(got.String()); err != nil {
+				t.Errorf("FromLegacyName(%q) = %q does not parse: %v", tt.input, got, err)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		granted  string
+		required string
+		want     bool
+	}{
+		{"contacts:entry:read", "contacts:entry:read", true},
+		{"contacts:entry:read", "contacts:entry:write", false},
+		{"contacts:*:read", "contacts:entry:read", true},
+		{"contacts:*:read", "contacts:entry:write", false},
+		{"*:*:*", "billing:invoice:delete", true},
+		{"contacts:entry:read", "contacts:*:read", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.granted+" "+tt.required, func(t *testing.T) {
+			granted, required := FromLegacyName(tt.granted), FromLegacyName(tt.required)
+			if got := granted.Matches(required); got != tt.want {
+				t.Errorf("%q.Matches(%q) = %v, want %v", tt.granted, tt.required, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		grants     []string
+		denies     []string
+		required   string
+		wantAllow  bool
+		wantDenied bool
+	}{
+		{"granted", []string{"contacts:entry:read"}, nil, "contacts:entry:read", true, false},
+		{"not granted", []string{"contacts:entry:read"}, nil, "contacts:entry:write", false, false},
+		{"wildcard grant", []string{"contacts:*:*"}, nil, "contacts:entry:write", true, false},
+		{"deny overrides", []string{"contacts:*:*"}, []string{"contacts:entry:delete"}, "contacts:entry:delete", false, true},
+		{"deny leaves the rest", []string{"contacts:*:*"}, []string{"contacts:entry:delete"}, "contacts:entry:read", true, false},
+		{"legacy grant", []string{"User Admin"}, nil, "user_admin", true, false},
+		{"legacy deny", []string{"User Admin"}, []string{"user admin"}, "User Admin", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSet(tt.grants)
+			s.Deny(tt.denies)
+
+			if got := s.Allows(tt.required); got != tt.wantAllow {
+				t.Errorf("Allows(%q) = %v, want %v", tt.required, got, tt.wantAllow)
+			}
+			if _, denied := s.DeniedBy(tt.required); denied != tt.wantDenied {
+				t.Errorf("DeniedBy(%q) = %v, want %v", tt.required, denied, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestIntersects(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"contacts:entry:read", "contacts:entry:read", true},
+		{"contacts:entry:read", "contacts:entry:write", false},
+		{"contacts:entry:*", "contacts:*:read", true},
+		{"contacts:*:read", "contacts:entry:*", true},
+		{"contacts:entry:delete", "contacts:*:*", true},
+		{"contacts:*:delete", "billing:*:*", false},
+		{"*:*:*", "billing:invoice:read", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			a, b := FromLegacyName(tt.a), FromLegacyName(tt.b)
+			if got := a.Intersects(b); got != tt.want {
+				t.Errorf("%q.Intersects(%q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := b.Intersects(a); got != tt.want {
+				t.Errorf("%q.Intersects(%q) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+// LoginGuard combines the per-IP and per-account limiters with the spray detector
+// for the login endpoint. Any of its parts may be nil to disable it.
+type LoginGuard struct {
+	PerIP      *Limiter
+	PerAccount *Limiter
+	Spray      *SprayDetector
+}
+
+// NewLoginGuard returns a new instance of LoginGuard.
+func NewLoginGuard(perIP, perAccount *Limiter, spray *SprayDetector) *LoginGuard {
+	return &LoginGuard{
+		PerIP:      perIP,
+		PerAccount: perAccount,
+		Spray:      spray,
+	}
+}
+
+// Check consumes a login attempt for ip and username and returns an *Error
+// when the attempt must be rejected.
+func (g *LoginGuard) Check(ip, username string) error {
+	if g.Spray != nil {
+		if wait, blocked := g.Spray.Blocked(ip); blocked {
+			return &Error{Reason: "ip temporarily blocked", RetryAfter: wait}
+		}
+	}
+
+	if g.PerIP != nil {
+		ok, wait, err := g.PerIP.Allow(ip)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &Error{Reason: "too many login attempts from this ip", RetryAfter: wait}
+		}
+	}
+
+	if g.PerAccount != nil {
+		ok, wait, err := g.PerAccount.Allow(username)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &Error{Reason: "too many login attempts for this account", RetryAfter: wait}
+		}
+	}
+
+	return nil
+}
+
+// Failed records a failed login attempt.
+func (g *LoginGuard) Failed(ip, username string) {
+	if g.Spray != nil {
+		g.Spray.RecordFailure(ip, username)
+	}
+}
+
+// Succeeded records a successful login attempt.
+func (g *LoginGuard) Succeeded(ip, username string) {
+	if g.Spray != nil {
+		g.Spray.RecordSuccess(ip, username)
+	}
+}
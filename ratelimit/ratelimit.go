@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket that holds at most Burst tokens and refills
+// at Rate tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Validate reports an error when the limit never refills or never allows a request.
+func (l Limit) Validate() error {
+	if l.Rate <= 0 {
+		return errors.New("rate limit must refill at a positive rate")
+	}
+	if l.Burst < 1 {
+		return errors.New("rate limit burst must be at least 1")
+	}
+	return nil
+}
+
+// Every returns a Limit that refills one token per interval.
+func Every(interval time.Duration, burst int) Limit {
+	return Limit{Rate: 1 / interval.Seconds(), Burst: burst}
+}
+
+// Store persists token buckets. The in-memory store is used by default; a
+// shared implementation (e.g. backed by Redis) lets replicas enforce a single
+// limit together.
+type Store interface {
+	// Take consumes one token from the bucket identified by key. When no token
+	// is available it returns false and the time until the next one. The limit
+	// must be valid; see Limit.Validate.
+	Take(key string, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+// pruneInterval is how often the in-memory state is swept for entries that no longer matter.
+const pruneInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled completely; it can be forgotten from then on
+	full time.Time
+}
+
+// MemoryStore is a Store that keeps buckets in process memory. Buckets are evicted lazily once
+// they have refilled, so that keys chosen by clients do not grow the store without bound.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// NewMemoryStore returns a new instance of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) >= pruneInterval {
+		s.prune(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	// Refill the bucket for the time elapsed since the last request
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second)))
+
+	if allowed {
+		return true, 0, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// Prune removes the buckets that have refilled completely by now.
+func (s *MemoryStore) Prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(now)
+}
+
+// prune removes the refilled buckets; s.mu must be held.
+func (s *MemoryStore) prune(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastPrune = now
+}
+
+// Limiter applies a single Limit to buckets stored under a key prefix.
+type Limiter struct {
+	store  Store
+	prefix string
+	limit  Limit
+}
+
+// NewLimiter returns a new instance of Limiter, or an error when the limit is invalid.
+func NewLimiter(store Store, prefix string, limit Limit) (*Limiter, error) {
+	err := limit.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Limiter{
+		store:  store,
+		prefix: prefix,
+		limit:  limit,
+	}, nil
+}
+
+// Allow consumes a token for key and reports how long to wait when the limit is exceeded.
+func (l *Limiter) Allow(key string) (bool, time.Duration, error) {
+	return l.store.Take(l.prefix+":"+key, l.limit, time.Now())
+}
+
+// Error is returned when a request is rejected by a limiter or the spray detector.
+type Error struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("too many requests: %s", e.Reason)
+}
+
+// StatusCode returns the HTTP status code for the error.
+func (e *Error) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+// RetryAfterSeconds returns the value for the Retry-After header, rounded up.
+func (e *Error) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
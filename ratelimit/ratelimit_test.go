@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   Limit
+		wantErr bool
+	}{
+		{"valid", Limit{Rate: 1, Burst: 1}, false},
+		{"every", Every(time.Minute, 5), false},
+		{"zero rate", Limit{Rate: 0, Burst: 5}, true},
+		{"negative rate", Limit{Rate: -1, Burst: 5}, true},
+		{"zero burst", Limit{Rate: 1, Burst: 0}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limit.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewLimiterRejectsInvalidLimit(t *testing.T) {
+	_, err := NewLimiter(NewMemoryStore(), "ip", Limit{Rate: 0, Burst: 1})
+	if err == nil {
+		t.Fatal("NewLimiter() accepted a limit that never refills")
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := Every(time.Second, 2)
+
+	tests := []struct {
+		name     string
+		at       time.Duration
+		wantOK   bool
+		wantWait time.Duration
+	}{
+		{"first token", 0, true, 0},
+		{"second token", 0, true, 0},
+		{"burst exhausted", 0, false, time.Second},
+		{"half refilled", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"refilled", time.Second, true, 0},
+		{"empty again", time.Second, false, time.Second},
+	}
+
+	store := NewMemoryStore()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, wait, err := store.Take("key", limit, start.Add(tt.at))
+			if err != nil {
+				t.Fatalf("Take() error = %v", err)
+			}
+			if ok != tt.wantOK || wait != tt.wantWait {
+				t.Errorf("Take() = %v, %v, want %v, %v", ok, wait, tt.wantOK, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := Every(time.Second, 2)
+
+	tests := []struct {
+		name    string
+		after   time.Duration
+		wantLen int
+	}{
+		{"still refilling", 500 * time.Millisecond, 1},
+		{"refilled", 2 * time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			_, _, _ = store.Take("a", limit, start)
+			_, _, _ = store.Take("a", limit, start)
+
+			store.Prune(start.Add(tt.after))
+			if len(store.buckets) != tt.wantLen {
+				t.Errorf("buckets after Prune() = %d, want %d", len(store.buckets), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreEvictsLazily(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := Every(time.Second, 1)
+
+	store := NewMemoryStore()
+	for _, key := range []string{"a", "b", "c"} {
+		_, _, _ = store.Take(key, limit, start)
+	}
+
+	_, _, _ = store.Take("d", limit, start.Add(pruneInterval))
+	if len(store.buckets) != 1 {
+		t.Errorf("buckets = %d, want only the bucket taken after the prune interval", len(store.buckets))
+	}
+}
+
+func TestSprayDetector(t *testing.T) {
+	type attempt struct {
+		username string
+		success  bool
+	}
+
+	tests := []struct {
+		name        string
+		attempts    []attempt
+		wantBlocked bool
+	}{
+		{
+			name:     "below threshold",
+			attempts: []attempt{{"a", false}, {"b", false}},
+		},
+		{
+			name:        "spraying",
+			attempts:    []attempt{{"a", false}, {"b", false}, {"c", false}},
+			wantBlocked: true,
+		},
+		{
+			name:     "same username repeated",
+			attempts: []attempt{{"a", false}, {"a", false}, {"a", false}},
+		},
+		{
+			name:        "success on another account does not reset",
+			attempts:    []attempt{{"a", false}, {"b", false}, {"mine", true}, {"c", false}},
+			wantBlocked: true,
+		},
+		{
+			name:     "success on a failed account forgets it",
+			attempts: []attempt{{"a", false}, {"b", false}, {"b", true}, {"c", false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewSprayDetector(2, time.Hour, time.Hour)
+			for _, a := range tt.attempts {
+				if a.success {
+					d.RecordSuccess("10.0.0.1", a.username)
+				} else {
+					d.RecordFailure("10.0.0.1", a.username)
+				}
+			}
+
+			if _, blocked := d.Blocked("10.0.0.1"); blocked != tt.wantBlocked {
+				t.Errorf("Blocked() = %v, want %v", blocked, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestSprayDetectorPrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   bool
+		after   time.Duration
+		wantLen int
+	}{
+		{"recent failure kept", false, time.Minute, 1},
+		{"failure out of window", false, 2 * time.Hour, 0},
+		{"block kept", true, 2 * time.Hour, 1},
+		{"block expired", true, 4 * time.Hour, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewSprayDetector(1, time.Hour, 3*time.Hour)
+			d.RecordFailure("10.0.0.1", "a")
+			if tt.block {
+				d.RecordFailure("10.0.0.1", "b")
+			}
+
+			d.Prune(time.Now().Add(tt.after))
+			if len(d.ips) != tt.wantLen {
+				t.Errorf("ips after Prune() = %d, want %d", len(d.ips), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestLoginGuardCheck(t *testing.T) {
+	perIP, err := NewLimiter(NewMemoryStore(), "ip", Every(time.Hour, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard := NewLoginGuard(perIP, nil, nil)
+
+	for i, want := range []bool{true, true, false} {
+		err := guard.Check("10.0.0.1", "a")
+		if (err == nil) != want {
+			t.Errorf("attempt %d: Check() error = %v, want allowed %v", i+1, err, want)
+		}
+		if limitErr, ok := err.(*Error); ok && limitErr.RetryAfterSeconds() <= 0 {
+			t.Errorf("attempt %d: RetryAfterSeconds() = %d, want positive", i+1, limitErr.RetryAfterSeconds())
+		}
+	}
+}
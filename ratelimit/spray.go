@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// SprayDetector detects password spraying, where a single IP tries a few
+// passwords against many different usernames, and blocks the IP for a while
+// once it is detected.
+type SprayDetector struct {
+	MaxUsernames int
+	Window       time.Duration
+	BlockFor     time.Duration
+
+	mu        sync.Mutex
+	ips       map[string]*sprayState
+	lastPrune time.Time
+}
+
+type sprayState struct {
+	usernames    map[string]time.Time
+	blockedUntil time.Time
+}
+
+// NewSprayDetector returns a new instance of SprayDetector that blocks an IP for blockFor
+// once it has failed logins for more than maxUsernames distinct usernames within window.
+func NewSprayDetector(maxUsernames int, window, blockFor time.Duration) *SprayDetector {
+	return &SprayDetector{
+		MaxUsernames: maxUsernames,
+		Window:       window,
+		BlockFor:     blockFor,
+		ips:          map[string]*sprayState{},
+	}
+}
+
+// Blocked reports whether ip is currently blocked and for how long.
+func (d *SprayDetector) Blocked(ip string) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.ips[ip]
+	if !ok {
+		return 0, false
+	}
+
+	remaining := time.Until(state.blockedUntil)
+	if remaining <= 0 {
+		return 0, false
+	}
+
+	return remaining, true
+}
+
+// RecordFailure records a failed login for username from ip and reports whether
+// the IP has been blocked as a result.
+func (d *SprayDetector) RecordFailure(ip, username string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.lastPrune) >= pruneInterval {
+		d.prune(now)
+	}
+
+	state, ok := d.ips[ip]
+	if !ok {
+		state = &sprayState{usernames: map[string]time.Time{}}
+		d.ips[ip] = state
+	}
+	d.forget(state, now)
+
+	state.usernames[username] = now
+	if len(state.usernames) > d.MaxUsernames {
+		state.blockedUntil = now.Add(d.BlockFor)
+		state.usernames = map[string]time.Time{}
+		return true
+	}
+
+	return false
+}
+
+// RecordSuccess forgets the failures recorded for username from ip. Failures for other usernames are
+// kept, so that an attacker holding one valid account cannot reset the detector by logging into it.
+func (d *SprayDetector) RecordSuccess(ip, username string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.ips[ip]
+	if !ok {
+		return
+	}
+
+	delete(state.usernames, username)
+	if len(state.usernames) == 0 && time.Now().After(state.blockedUntil) {
+		delete(d.ips, ip)
+	}
+}
+
+// Prune removes the IPs that are neither blocked nor have failures within the window.
+func (d *SprayDetector) Prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(now)
+}
+
+// prune removes the IPs that no longer matter; d.mu must be held.
+func (d *SprayDetector) prune(now time.Time) {
+	for ip, state := range d.ips {
+		d.forget(state, now)
+		if len(state.usernames) == 0 && !now.Before(state.blockedUntil) {
+			delete(d.ips, ip)
+		}
+	}
+	d.lastPrune = now
+}
+
+// forget removes the usernames of state that fell out of the window.
+func (d *SprayDetector) forget(state *sprayState, now time.Time) {
+	for name, seen := range state.usernames {
+		if now.Sub(seen) > d.Window {
+			delete(state.usernames, name)
+		}
+	}
+}
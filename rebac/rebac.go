@@ -0,0 +1,277 @@
+// Package rebac defines relationship tuples and namespace configurations for relationship-based
+// authorization, modelled on Google's Zanzibar.
+//
+// A tuple "doc:readme#viewer@user:42" states that user 42 is a viewer of doc readme. The subject of a
+// tuple may also be a userset, as in "doc:readme#viewer@group:eng#member", which makes every member of
+// group eng a viewer. Namespace configurations describe how relations derive from one another, e.g. that
+// every editor is a viewer, or that the viewers of a folder view the documents in it.
+package rebac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+	idPattern   = regexp.MustCompile(`^[A-Za-z0-9_.|=+/-]{1,255}$`)
+)
+
+// Object identifies an object in a namespace, written namespace:id.
+type Object struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+}
+
+// String returns the namespace:id form of the object.
+func (o Object) String() string {
+	return o.Namespace + ":" + o.ID
+}
+
+// ParseObject parses an object from its namespace:id form.
+func ParseObject(s string) (Object, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || !namePattern.MatchString(parts[0]) || !idPattern.MatchString(parts[1]) {
+		return Object{}, fmt.Errorf("object %q must have the form namespace:id", s)
+	}
+	return Object{Namespace: parts[0], ID: parts[1]}, nil
+}
+
+// Subject is who a tuple relates an object to: either an object such as user:42, or the userset of
+// objects holding a relation to an object, such as group:eng#member.
+type Subject struct {
+	Object
+	Relation string `json:"relation,omitempty"`
+}
+
+// String returns the namespace:id or namespace:id#relation form of the subject.
+func (s Subject) String() string {
+	if s.Relation == "" {
+		return s.Object.String()
+	}
+	return s.Object.String() + "#" + s.Relation
+}
+
+// IsUserset reports whether the subject is a userset rather than a single object.
+func (s Subject) IsUserset() bool {
+	return s.Relation != ""
+}
+
+// ParseSubject parses a subject from its namespace:id or namespace:id#relation form.
+func ParseSubject(s string) (Subject, error) {
+	objectPart, relation := s, ""
+	if i := strings.Index(s, "#"); i >= 0 {
+		objectPart, relation = s[:i], s[i+1:]
+		if !namePattern.MatchString(relation) {
+			return Subject{}, fmt.Errorf("subject %q has an invalid relation", s)
+		}
+	}
+
+	object, err := ParseObject(objectPart)
+	if err != nil {
+		return Subject{}, err
+	}
+	return Subject{Object: object, Relation: relation}, nil
+}
+
+// Tuple relates an object to a subject, written object#relation@subject.
+type Tuple struct {
+	Object   Object  `json:"object"`
+	Relation string  `json:"relation"`
+	Subject  Subject `json:"subject"`
+}
+
+// String returns the object#relation@subject form of the tuple.
+func (t Tuple) String() string {
+	return t.Object.String() + "#" + t.Relation + "@" + t.Subject.String()
+}
+
+// ParseTuple parses a tuple from its object#relation@subject form.
+func ParseTuple(s string) (Tuple, error) {
+	at := strings.Index(s, "@")
+	if at < 0 {
+		return Tuple{}, fmt.Errorf("tuple %q must have the form object#relation@subject", s)
+	}
+
+	hash := strings.Index(s[:at], "#")
+	if hash < 0 {
+		return Tuple{}, fmt.Errorf("tuple %q must have the form object#relation@subject", s)
+	}
+
+	object, err := ParseObject(s[:hash])
+	if err != nil {
+		return Tuple{}, err
+	}
+
+	relation := s[hash+1 : at]
+	if !namePattern.MatchString(relation) {
+		return Tuple{}, fmt.Errorf("tuple %q has an invalid relation", s)
+	}
+
+	subject, err := ParseSubject(s[at+1:])
+	if err != nil {
+		return Tuple{}, err
+	}
+
+	return Tuple{Object: object, Relation: relation, Subject: subject}, nil
+}
+
+// Rewrite defines how the subjects of a relation are computed. Exactly one field is set:
+//
+//   - This: the subjects written in tuples for the relation itself
+//   - ComputedUserset: the subjects of another relation on the same object, e.g. every editor is a viewer
+//   - TupleToUserset: the subjects of a relation on the objects found through another relation, e.g.
+//     the viewers of a document include the viewers of its parent folder
+//   - Union: the subjects of any of the rewrites
+type Rewrite struct {
+	This            *This            `json:"this,omitempty"`
+	ComputedUserset *ComputedUserset `json:"computed_userset,omitempty"`
+	TupleToUserset  *TupleToUserset  `json:"tuple_to_userset,omitempty"`
+	Union           []*Rewrite       `json:"union,omitempty"`
+}
+
+// This selects the subjects written directly for a relation.
+type This struct{}
+
+// ComputedUserset selects the subjects of another relation on the same object.
+type ComputedUserset struct {
+	Relation string `json:"relation"`
+}
+
+// TupleToUserset follows the tuples of Tupleset on an object to other objects and selects the subjects
+// of ComputedUserset on each of them.
+type TupleToUserset struct {
+	Tupleset        string `json:"tupleset"`
+	ComputedUserset string `json:"computed_userset"`
+}
+
+// NamespaceConfig defines the relations of the objects of a namespace. A relation without a rewrite
+// holds the subjects written directly for it.
+type NamespaceConfig struct {
+	Name      string              `json:"name"`
+	Relations map[string]*Rewrite `json:"relations"`
+}
+
+// ParseNamespaceConfig decodes and validates a namespace configuration.
+func ParseNamespaceConfig(data []byte) (*NamespaceConfig, error) {
+	config := &NamespaceConfig{}
+	err := json.Unmarshal(data, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+// Validate checks that the configuration is well formed and that its rewrites only refer to relations
+// it defines. Relations computed on other objects through a TupleToUserset are checked at evaluation.
+func (c *NamespaceConfig) Validate() error {
+	if !namePattern.MatchString(c.Name) {
+		return fmt.Errorf("namespace name %q is invalid", c.Name)
+	}
+
+	if len(c.Relations) == 0 {
+		return fmt.Errorf("namespace %s defines no relations", c.Name)
+	}
+
+	for name, rewrite := range c.Relations {
+		if !namePattern.MatchString(name) {
+			return fmt.Errorf("relation name %q is invalid", name)
+		}
+		if rewrite != nil {
+			if err := c.validateRewrite(name, rewrite); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *NamespaceConfig) validateRewrite(relation string, r *Rewrite) error {
+	set := 0
+	if r.This != nil {
+		set++
+	}
+	if r.ComputedUserset != nil {
+		set++
+		if _, ok := c.Relations[r.ComputedUserset.Relation]; !ok {
+			return fmt.Errorf("relation %s refers to undefined relation %q", relation, r.ComputedUserset.Relation)
+		}
+	}
+	if r.TupleToUserset != nil {
+		set++
+		if _, ok := c.Relations[r.TupleToUserset.Tupleset]; !ok {
+			return fmt.Errorf("relation %s refers to undefined relation %q", relation, r.TupleToUserset.Tupleset)
+		}
+		if !namePattern.MatchString(r.TupleToUserset.ComputedUserset) {
+			return fmt.Errorf("relation %s has an invalid computed userset", relation)
+		}
+	}
+	if r.Union != nil {
+		set++
+		if len(r.Union) == 0 {
+			return fmt.Errorf("relation %s has an empty union", relation)
+		}
+		for _, child := range r.Union {
+			if child == nil {
+				return fmt.Errorf("relation %s has an empty rewrite in its union", relation)
+			}
+			if err := c.validateRewrite(relation, child); err != nil {
+				return err
+			}
+		}
+	}
+
+	if set != 1 {
+		return fmt.Errorf("every rewrite of relation %s must set exactly one of this, computed_userset, tuple_to_userset and union", relation)
+	}
+	return nil
+}
+
+// AllowsTuples reports whether tuples may be written for a relation: a relation without a rewrite, or
+// one whose rewrite includes This.
+func (c *NamespaceConfig) AllowsTuples(relation string) bool {
+	rewrite, ok := c.Relations[relation]
+	if !ok {
+		return false
+	}
+	return rewrite == nil || includesThis(rewrite)
+}
+
+func includesThis(r *Rewrite) bool {
+	if r.This != nil {
+		return true
+	}
+	for _, child := range r.Union {
+		if includesThis(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenPrefix marks consistency tokens, so that other values are not mistaken for one.
+const tokenPrefix = "zk1."
+
+// EncodeToken returns the consistency token of a revision of the tuple store. Clients keep the token
+// returned by a write and pass it to later reads, which are then evaluated at that revision or a later one.
+func EncodeToken(revision int64) string {
+	return tokenPrefix + strconv.FormatInt(revision, 36)
+}
+
+// DecodeToken returns the revision of a consistency token.
+func DecodeToken(token string) (int64, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return 0, errors.New("invalid consistency token")
+	}
+
+	revision, err := strconv.ParseInt(strings.TrimPrefix(token, tokenPrefix), 36, 64)
+	if err != nil || revision < 0 {
+		return 0, errors.New("invalid consistency token")
+	}
+	return revision, nil
+}
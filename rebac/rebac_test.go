@@ -0,0 +1,173 @@
+package rebac
+
+import "testing"
+
+func TestParseTuple(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"object subject", "doc:readme#viewer@user:42", false},
+		{"userset subject", "doc:readme#viewer@group:eng#member", false},
+		{"id with punctuation", "doc:a/b.c-d=e#owner@user:x|y", false},
+		{"missing subject", "doc:readme#viewer", true},
+		{"missing relation", "doc:readme@user:42", true},
+		{"empty relation", "doc:readme#@user:42", true},
+		{"invalid namespace", "Doc:readme#viewer@user:42", true},
+		{"empty id", "doc:#viewer@user:42", true},
+		{"invalid subject relation", "doc:readme#viewer@group:eng#Member", true},
+		{"subject without namespace", "doc:readme#viewer@42", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tuple, err := ParseTuple(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTuple(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if err == nil && tuple.String() != tt.input {
+				t.Errorf("ParseTuple(%q).String() = %q", tt.input, tuple.String())
+			}
+		})
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantUserset bool
+		wantErr     bool
+	}{
+		{"user:42", false, false},
+		{"group:eng#member", true, false},
+		{"group:eng#", false, true},
+		{"group", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			subject, err := ParseSubject(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSubject(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if subject.IsUserset() != tt.wantUserset {
+				t.Errorf("ParseSubject(%q).IsUserset() = %v, want %v", tt.input, subject.IsUserset(), tt.wantUserset)
+			}
+		})
+	}
+}
+
+func TestParseNamespaceConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{
+			name:   "direct relations",
+			config: `{"name": "group", "relations": {"member": null}}`,
+		},
+		{
+			name: "rewrites",
+			config: `{"name": "doc", "relations": {
+				"parent": null,
+				"owner": null,
+				"editor": {"union": [{"this": {}}, {"computed_userset": {"relation": "owner"}}]},
+				"viewer": {"union": [{"this": {}}, {"computed_userset": {"relation": "editor"}},
+					{"tuple_to_userset": {"tupleset": "parent", "computed_userset": "viewer"}}]}
+			}}`,
+		},
+		{
+			name:    "invalid name",
+			config:  `{"name": "Doc", "relations": {"owner": null}}`,
+			wantErr: true,
+		},
+		{
+			name:    "no relations",
+			config:  `{"name": "doc", "relations": {}}`,
+			wantErr: true,
+		},
+		{
+			name:    "undefined computed relation",
+			config:  `{"name": "doc", "relations": {"viewer": {"computed_userset": {"relation": "editor"}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "undefined tupleset",
+			config:  `{"name": "doc", "relations": {"viewer": {"tuple_to_userset": {"tupleset": "parent", "computed_userset": "viewer"}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "two fields set",
+			config:  `{"name": "doc", "relations": {"owner": null, "viewer": {"this": {}, "computed_userset": {"relation": "owner"}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "empty rewrite",
+			config:  `{"name": "doc", "relations": {"viewer": {}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "empty union",
+			config:  `{"name": "doc", "relations": {"viewer": {"union": []}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			config:  `{"name": "doc"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseNamespaceConfig([]byte(tt.config))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseNamespaceConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllowsTuples(t *testing.T) {
+	config, err := ParseNamespaceConfig([]byte(`{"name": "doc", "relations": {
+		"owner": null,
+		"editor": {"union": [{"this": {}}, {"computed_userset": {"relation": "owner"}}]},
+		"viewer": {"computed_userset": {"relation": "editor"}}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		relation string
+		want     bool
+	}{
+		{"owner", true},
+		{"editor", true},
+		{"viewer", false},
+		{"undefined", false},
+	}
+
+	for _, tt := range tests {
+		if got := config.AllowsTuples(tt.relation); got != tt.want {
+			t.Errorf("AllowsTuples(%q) = %v, want %v", tt.relation, got, tt.want)
+		}
+	}
+}
+
+func TestConsistencyToken(t *testing.T) {
+	for _, revision := range []int64{0, 1, 35, 123456789} {
+		got, err := DecodeToken(EncodeToken(revision))
+		if err != nil || got != revision {
+			t.Errorf("DecodeToken(EncodeToken(%d)) = %d, %v", revision, got, err)
+		}
+	}
+
+	for _, token := range []string{"", "42", "zk1.", "zk1.-1", "zk1.!!", "zk2.1"} {
+		if _, err := DecodeToken(token); err == nil {
+			t.Errorf("DecodeToken(%q) accepted an invalid token", token)
+		}
+	}
+}
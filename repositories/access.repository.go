@@ -2,11 +2,48 @@ package repositories
 
 import (
 	"database/sql"
+	"fmt"
+
+	"github.com/princeparmar/contact_manager/permission"
 )
 
+// Access is a permission named "namespace:resource:action". The structured columns are derived
+// from the name whenever it is written.
 type Access struct {
-	ID   int
-	Name string
+	ID        int
+	Name      string
+	Namespace string
+	Resource  string
+	Action    string
+}
+
+// accessColumns lists the columns scanned by scanAccess, for queries aliasing the access table as a
+const accessColumns = "a.access_id, a.access_name, a.namespace, a.resource, a.action"
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAccess scans an access selected with accessColumns
+func scanAccess(row scanner) (*Access, error) {
+	access := &Access{}
+	err := row.Scan(&access.ID, &access.Name, &access.Namespace, &access.Resource, &access.Action)
+	return access, err
+}
+
+// setPermission parses the name of an access and fills its structured fields
+func setPermission(access *Access) error {
+	p, err := permission.Parse(access.Name)
+	if err != nil {
+		return err
+	}
+
+	access.Namespace = p.Namespace
+	access.Resource = p.Resource
+	access.Action = p.Action
+
+	return nil
 }
 
 // AccessRepository provides access to the access store
@@ -21,10 +58,15 @@ func NewAccessRepository(db *sql.DB) *AccessRepository {
 
 // Create creates a new access in the database and sets its ID
 func (r *AccessRepository) Create(access *Access) error {
+	err := setPermission(access)
+	if err != nil {
+		return err
+	}
+
 	// Prepare the query to insert a new access object
-	query := "INSERT INTO access (access_name) VALUES (?)"
-	// Execute the query with the access name parameter
-	result, err := r.db.Exec(query, access.Name)
+	query := "INSERT INTO access (access_name, namespace, resource, action) VALUES (?, ?, ?, ?)"
+	// Execute the query with the access name parameters
+	result, err := r.db.Exec(query, access.Name, access.Namespace, access.Resource, access.Action)
 	if err != nil {
 		return err
 	}
@@ -44,20 +86,23 @@ func (r *AccessRepository) Create(access *Access) error {
 // Get retrieves an access object with the given ID from the database
 func (r *AccessRepository) Get(id int) (*Access, error) {
 	// Prepare the query to select an access object by ID
-	query := "SELECT access_id, access_name FROM access WHERE access_id = ?"
+	query := "SELECT " + accessColumns + " FROM access a WHERE a.access_id = ?"
 	// Execute the query with the ID parameter
 	row := r.db.QueryRow(query, id)
-	access := &Access{}
-	err := row.Scan(&access.ID, &access.Name)
-	return access, err
+	return scanAccess(row)
 }
 
 // Update updates an access object in the database with the new data
 func (r *AccessRepository) Update(access *Access) error {
+	err := setPermission(access)
+	if err != nil {
+		return err
+	}
+
 	// Prepare the query to update an access object by ID
-	query := "UPDATE access SET access_name = ? WHERE access_id = ?"
+	query := "UPDATE access SET access_name = ?, namespace = ?, resource = ?, action = ?, updated_date = NOW() WHERE access_id = ?"
 	// Execute the query with the access name and ID parameters
-	_, err := r.db.Exec(query, access.Name, access.ID)
+	_, err = r.db.Exec(query, access.Name, access.Namespace, access.Resource, access.Action, access.ID)
 	return err
 }
 
@@ -73,7 +118,7 @@ func (r *AccessRepository) Delete(id int) error {
 // GetAll retrieves all access objects from the database
 func (r *AccessRepository) GetAll() ([]*Access, error) {
 	// Prepare the query to select all access objects
-	query := "SELECT access_id, access_name FROM access"
+	query := "SELECT " + accessColumns + " FROM access a"
 	// Execute the query
 	rows, err := r.db.Query(query)
 	if err != nil {
@@ -85,8 +130,7 @@ func (r *AccessRepository) GetAll() ([]*Access, error) {
 	accesses := []*Access{}
 
 	for rows.Next() {
-		access := &Access{}
-		err := rows.Scan(&access.ID, &access.Name)
+		access, err := scanAccess(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -102,9 +146,112 @@ func (r *AccessRepository) CreateTable() error {
         CREATE TABLE IF NOT EXISTS access (
             access_id INT AUTO_INCREMENT PRIMARY KEY,
             access_name VARCHAR(255) NOT NULL UNIQUE,
+            namespace VARCHAR(255) NOT NULL DEFAULT '',
+            resource VARCHAR(255) NOT NULL DEFAULT '',
+            action VARCHAR(255) NOT NULL DEFAULT '',
 			created_date DATETIME NOT NULL DEFAULT NOW(),
 			updated_date DATETIME NOT NULL DEFAULT NOW()
         )`
 	_, err := r.db.Exec(query)
 	return err
 }
+
+// MigrateStructuredNames adds the structured permission columns to an existing access table and
+// rewrites every access name that is not yet in "namespace:resource:action" form. Free-text names
+// become "legacy:<name>:access". Names that would end up equal, such as "User Admin" and "user_admin",
+// are reported before anything is rewritten, and the names are rewritten in a single transaction.
+func (r *AccessRepository) MigrateStructuredNames() error {
+	// Add the columns when the table predates structured permissions
+	exists, err := columnExists(r.db, "access", "namespace")
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		query := `
+			ALTER TABLE access
+				ADD COLUMN namespace VARCHAR(255) NOT NULL DEFAULT '',
+				ADD COLUMN resource VARCHAR(255) NOT NULL DEFAULT '',
+				ADD COLUMN action VARCHAR(255) NOT NULL DEFAULT ''`
+		_, err = r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Widen the columns of tables created with VARCHAR(100), which cannot hold every segment of a
+	// 255 character name
+	var length int
+	query := "SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'access' AND COLUMN_NAME = 'resource'"
+	err = r.db.QueryRow(query).Scan(&length)
+	if err != nil {
+		return err
+	}
+
+	if length < 255 {
+		query = `
+			ALTER TABLE access
+				MODIFY COLUMN namespace VARCHAR(255) NOT NULL DEFAULT '',
+				MODIFY COLUMN resource VARCHAR(255) NOT NULL DEFAULT '',
+				MODIFY COLUMN action VARCHAR(255) NOT NULL DEFAULT ''`
+		_, err = r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the table so that no access is created or renamed while the names are checked and rewritten
+	rows, err := tx.Query("SELECT access_id, access_name, namespace FROM access FOR UPDATE")
+	if err != nil {
+		return err
+	}
+
+	pending := []*Access{}
+	owners := map[string]string{}
+	for rows.Next() {
+		access := &Access{}
+		err := rows.Scan(&access.ID, &access.Name, &access.Namespace)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		oldName := access.Name
+		if access.Namespace == "" {
+			access.Name = permission.FromLegacyName(oldName).String()
+			pending = append(pending, access)
+		}
+
+		if other, ok := owners[access.Name]; ok {
+			rows.Close()
+			return fmt.Errorf("accesses %q and %q both migrate to %q; rename one of them first", other, oldName, access.Name)
+		}
+		owners[access.Name] = oldName
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, access := range pending {
+		err = setPermission(access)
+		if err != nil {
+			return err
+		}
+
+		query = "UPDATE access SET access_name = ?, namespace = ?, resource = ?, action = ?, updated_date = NOW() WHERE access_id = ?"
+		_, err = tx.Exec(query, access.Name, access.Namespace, access.Resource, access.Action, access.ID)
+		if err != nil {
+			return fmt.Errorf("migrating access %q: %w", owners[access.Name], err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,198 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// AccessDeny denies an access to the holders of a role or to a single user. Exactly one of RoleID and
+// UserID is set. Denies override every grant, including grants through other roles. A deny applies in
+// its organization only, unless OrgID is GlobalOrgID.
+type AccessDeny struct {
+	ID         int
+	RoleID     int
+	UserID     int
+	OrgID      int
+	AccessID   int
+	AccessName string
+}
+
+// denyColumns lists the access_denies columns scanned by scanDenies, with d.* aliased
+const denyColumns = "d.deny_id, d.role_id, d.user_id, d.org_id, d.access_id, a.access_name"
+
+// AccessDenyRepository is a struct that handles all database operations related to AccessDeny
+type AccessDenyRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewAccessDenyRepository creates a new AccessDenyRepository with the given db instance
+func NewAccessDenyRepository(db *sql.DB) *AccessDenyRepository {
+	return &AccessDenyRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization. The copy sees the denies of the
+// organization and global denies, and only modifies denies of the organization. A deny created in an
+// organization on a global role only applies within that organization
+func (r *AccessDenyRepository) ForOrg(orgID int) *AccessDenyRepository {
+	return &AccessDenyRepository{db: r.db, orgID: orgID}
+}
+
+// nullID stores a zero ID as NULL
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// Create creates a new deny entry in the database and sets its ID
+func (r *AccessDenyRepository) Create(deny *AccessDeny) error {
+	if (deny.RoleID == 0) == (deny.UserID == 0) {
+		return errors.New("a deny must be attached to either a role or a user")
+	}
+
+	deny.OrgID = r.orgID
+	query := "INSERT INTO access_denies (role_id, user_id, org_id, access_id, created_date) VALUES (?, ?, ?, ?, NOW())"
+	result, err := r.db.Exec(query, nullID(deny.RoleID), nullID(deny.UserID), deny.OrgID, deny.AccessID)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	deny.ID = int(id)
+
+	return nil
+}
+
+// Get retrieves a deny entry with the given ID from the database
+func (r *AccessDenyRepository) Get(id int) (*AccessDeny, error) {
+	query := "SELECT " + denyColumns + " FROM access_denies d INNER JOIN access a ON d.access_id = a.access_id WHERE d.deny_id = ? AND " + orgVisible("d")
+	rows, err := r.db.Query(query, id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	denies, err := scanDenies(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(denies) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return denies[0], nil
+}
+
+// Delete deletes a deny entry with the given ID from the database
+func (r *AccessDenyRepository) Delete(id int) error {
+	query := "DELETE FROM access_denies WHERE deny_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, id, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetForRole retrieves the deny entries attached to a role from the database
+func (r *AccessDenyRepository) GetForRole(roleID int) ([]*AccessDeny, error) {
+	query := "SELECT " + denyColumns + " FROM access_denies d INNER JOIN access a ON d.access_id = a.access_id WHERE d.role_id = ? AND " + orgVisible("d")
+	rows, err := r.db.Query(query, roleID, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDenies(rows)
+}
+
+// GetForUser retrieves the deny entries attached directly to a user from the database
+func (r *AccessDenyRepository) GetForUser(userID int) ([]*AccessDeny, error) {
+	query := "SELECT " + denyColumns + " FROM access_denies d INNER JOIN access a ON d.access_id = a.access_id WHERE d.user_id = ? AND " + orgVisible("d")
+	rows, err := r.db.Query(query, userID, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDenies(rows)
+}
+
+// GetAll retrieves every deny entry visible to the organization from the database
+func (r *AccessDenyRepository) GetAll() ([]*AccessDeny, error) {
+	query := "SELECT " + denyColumns + " FROM access_denies d INNER JOIN access a ON d.access_id = a.access_id WHERE " + orgVisible("d")
+	rows, err := r.db.Query(query, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDenies(rows)
+}
+
+// GetEffectiveDenies retrieves every deny that applies to a user: those attached to the user and those
+// attached to the roles the user holds directly, through groups or through inheritance
+func (r *AccessDenyRepository) GetEffectiveDenies(userID int) ([]*AccessDeny, error) {
+	query := effectiveRolesCTE + `
+		SELECT ` + denyColumns + `
+		FROM access_denies d
+		INNER JOIN access a ON d.access_id = a.access_id
+		WHERE (d.user_id = ? OR d.role_id IN (SELECT role_id FROM effective_roles)) AND ` + orgVisible("d") + `
+	`
+	args := append(effectiveRolesArgs(userID, r.orgID), userID, r.orgID)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDenies(rows)
+}
+
+// scanDenies scans and closes rows selected with denyColumns
+func scanDenies(rows *sql.Rows) ([]*AccessDeny, error) {
+	defer rows.Close()
+
+	denies := []*AccessDeny{}
+
+	for rows.Next() {
+		deny := &AccessDeny{}
+		var roleID, userID sql.NullInt64
+		err := rows.Scan(&deny.ID, &roleID, &userID, &deny.OrgID, &deny.AccessID, &deny.AccessName)
+		if err != nil {
+			return nil, err
+		}
+		deny.RoleID = int(roleID.Int64)
+		deny.UserID = int(userID.Int64)
+		denies = append(denies, deny)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return denies, nil
+}
+
+func (r *AccessDenyRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS access_denies (
+		deny_id INT AUTO_INCREMENT PRIMARY KEY,
+		role_id INT,
+		user_id INT,
+		org_id INT NOT NULL DEFAULT 0,
+		access_id INT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		UNIQUE (role_id, access_id),
+		UNIQUE (user_id, access_id, org_id),
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+		FOREIGN KEY (access_id) REFERENCES access(access_id) ON DELETE CASCADE
+	)
+`
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
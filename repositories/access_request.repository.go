@@ -0,0 +1,375 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// States of an access request. A request starts pending and is decided once: approved, rejected, or
+// cancelled by the requester.
+const (
+	RequestPending   = "pending"
+	RequestApproved  = "approved"
+	RequestRejected  = "rejected"
+	RequestCancelled = "cancelled"
+)
+
+// ErrRequestDecided is returned when deciding or cancelling a request that is no longer pending.
+var ErrRequestDecided = errors.New("access request is no longer pending")
+
+// AccessRequest is a request by a user for a role in an organization. DurationHours is how long the
+// user wants the role for. ExpiryDate is set when the request is approved.
+type AccessRequest struct {
+	ID            int
+	OrgID         int
+	UserID        int
+	UserName      string
+	RoleID        int
+	RoleName      string
+	Justification string
+	DurationHours int
+	State         string
+	ExpiryDate    time.Time
+	CreatedDate   time.Time
+	UpdatedDate   time.Time
+}
+
+// AccessRequestEvent records a change of state of a request, or a comment on it when FromState and
+// ToState are equal.
+type AccessRequestEvent struct {
+	ID          int
+	RequestID   int
+	ActorID     int
+	ActorName   string
+	FromState   string
+	ToState     string
+	Comment     string
+	CreatedDate time.Time
+}
+
+// AccessRequestFilter restricts the requests returned by GetAll. Zero fields are not filtered on.
+type AccessRequestFilter struct {
+	UserID int
+	RoleID int
+	State  string
+}
+
+// accessRequestQuery selects the columns scanned by queryRequests; callers append the WHERE clause.
+const accessRequestQuery = `
+		SELECT ar.request_id, ar.org_id, ar.user_id, u.user_name, ar.role_id, r.role_name, ar.justification, ar.duration_hours, ar.state, ar.expiry_date, ar.created_date, ar.updated_date
+		FROM access_requests ar
+		JOIN users u ON ar.user_id = u.user_id
+		JOIN roles r ON ar.role_id = r.role_id
+`
+
+// AccessRequestRepository stores access requests and their history. It only sees and modifies the
+// requests of the organization it is scoped to with ForOrg.
+type AccessRequestRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewAccessRequestRepository creates a new AccessRequestRepository with the given db instance
+func NewAccessRequestRepository(db *sql.DB) *AccessRequestRepository {
+	return &AccessRequestRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *AccessRequestRepository) ForOrg(orgID int) *AccessRequestRepository {
+	return &AccessRequestRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts a new pending request and records its creation by the requester.
+func (r *AccessRequestRepository) Create(req *AccessRequest) error {
+	req.OrgID = r.orgID
+	req.State = RequestPending
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO access_requests (org_id, user_id, role_id, justification, duration_hours, state, created_date, updated_date) VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())"
+	result, err := tx.Exec(query, req.OrgID, req.UserID, req.RoleID, req.Justification, req.DurationHours, req.State)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	req.ID = int(id)
+
+	err = insertRequestEvent(tx, req.ID, req.UserID, "", RequestPending, "")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get retrieves a request by ID. Returns sql.ErrNoRows if it does not exist in the organization.
+func (r *AccessRequestRepository) Get(id int) (*AccessRequest, error) {
+	requests, err := r.queryRequests(accessRequestQuery+"WHERE ar.request_id = ? AND ar.org_id = ?", id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(requests) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return requests[0], nil
+}
+
+// GetAll retrieves the requests matching filter, newest first.
+func (r *AccessRequestRepository) GetAll(filter AccessRequestFilter) ([]*AccessRequest, error) {
+	where := `
+		WHERE ar.org_id = ? AND (? = 0 OR ar.user_id = ?) AND (? = 0 OR ar.role_id = ?) AND (? = '' OR ar.state = ?)
+		ORDER BY ar.request_id DESC
+	`
+	return r.queryRequests(accessRequestQuery+where, r.orgID, filter.UserID, filter.UserID, filter.RoleID, filter.RoleID, filter.State, filter.State)
+}
+
+// GetPendingForApprover retrieves the pending requests a user may decide, oldest first. Requests made by
+// the user are not included.
+func (r *AccessRequestRepository) GetPendingForApprover(userID int) ([]*AccessRequest, error) {
+	where := `
+		WHERE ar.org_id = ? AND ar.state = ? AND ar.user_id <> ? AND EXISTS (
+			SELECT 1 FROM role_approvers ra
+			WHERE ra.role_id = ar.role_id AND ` + orgVisible("ra") + `
+			AND (ra.approver_user_id = ? OR ra.approver_role_id IN (SELECT role_id FROM effective_roles))
+		)
+		ORDER BY ar.request_id
+	`
+	args := append(effectiveRolesArgs(userID, r.orgID), r.orgID, RequestPending, userID, r.orgID, userID)
+	return r.queryRequests(effectiveRolesCTE+accessRequestQuery+where, args...)
+}
+
+// Approve approves a pending request and assigns the role to the requester in the same transaction,
+// expiring after the requested duration. A request without a duration cannot be approved, so that no
+// request turns into a permanent assignment. The assignment is subject to the separation of duties
+// constraints; if it is rejected, the request stays pending.
+func (r *AccessRequestRepository) Approve(id, actorID int, comment string) (*AccessRequest, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	req, err := r.transition(tx, id, actorID, RequestApproved, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.DurationHours <= 0 {
+		return nil, errors.New("access request has no duration and cannot be approved")
+	}
+
+	ur := &UserRole{UserID: req.UserID, RoleID: req.RoleID, OrgID: req.OrgID}
+	ur.ExpiryDate = time.Now().Add(time.Duration(req.DurationHours) * time.Hour)
+	req.ExpiryDate = ur.ExpiryDate
+
+	err = insertUserRole(tx, ur)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE access_requests SET expiry_date = ? WHERE request_id = ?", nullTime(req.ExpiryDate), req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, tx.Commit()
+}
+
+// Reject rejects a pending request.
+func (r *AccessRequestRepository) Reject(id, actorID int, comment string) (*AccessRequest, error) {
+	return r.decide(id, actorID, RequestRejected, comment)
+}
+
+// Cancel withdraws a pending request.
+func (r *AccessRequestRepository) Cancel(id, actorID int, comment string) (*AccessRequest, error) {
+	return r.decide(id, actorID, RequestCancelled, comment)
+}
+
+// decide moves a pending request to a final state that has no other effect.
+func (r *AccessRequestRepository) decide(id, actorID int, state, comment string) (*AccessRequest, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	req, err := r.transition(tx, id, actorID, state, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, tx.Commit()
+}
+
+// transition moves a pending request of the organization to state within tx, records the change and
+// returns the request. Returns sql.ErrNoRows if the request does not exist and ErrRequestDecided if it is
+// not pending.
+func (r *AccessRequestRepository) transition(tx *sql.Tx, id, actorID int, state, comment string) (*AccessRequest, error) {
+	var userID, roleID, durationHours int
+	var current string
+	query := "SELECT user_id, role_id, duration_hours, state FROM access_requests WHERE request_id = ? AND org_id = ? FOR UPDATE"
+	err := tx.QueryRow(query, id, r.orgID).Scan(&userID, &roleID, &durationHours, &current)
+	if err != nil {
+		return nil, err
+	}
+
+	if current != RequestPending {
+		return nil, ErrRequestDecided
+	}
+
+	_, err = tx.Exec("UPDATE access_requests SET state = ?, updated_date = NOW() WHERE request_id = ?", state, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertRequestEvent(tx, id, actorID, current, state, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessRequest{ID: id, OrgID: r.orgID, UserID: userID, RoleID: roleID, DurationHours: durationHours, State: state}, nil
+}
+
+// Comment records a comment on a request without changing its state.
+func (r *AccessRequestRepository) Comment(id, actorID int, comment string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var state string
+	err = tx.QueryRow("SELECT state FROM access_requests WHERE request_id = ? AND org_id = ?", id, r.orgID).Scan(&state)
+	if err != nil {
+		return err
+	}
+
+	err = insertRequestEvent(tx, id, actorID, state, state, comment)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetEvents retrieves the history of a request, oldest first.
+func (r *AccessRequestRepository) GetEvents(id int) ([]*AccessRequestEvent, error) {
+	query := `
+		SELECT e.event_id, e.request_id, e.actor_id, u.user_name, e.from_state, e.to_state, e.comment, e.created_date
+		FROM access_request_events e
+		JOIN access_requests ar ON e.request_id = ar.request_id
+		JOIN users u ON e.actor_id = u.user_id
+		WHERE e.request_id = ? AND ar.org_id = ?
+		ORDER BY e.event_id
+	`
+	rows, err := r.db.Query(query, id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*AccessRequestEvent{}
+
+	for rows.Next() {
+		e := &AccessRequestEvent{}
+		err := rows.Scan(&e.ID, &e.RequestID, &e.ActorID, &e.ActorName, &e.FromState, &e.ToState, &e.Comment, &e.CreatedDate)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// insertRequestEvent records an event on a request within tx.
+func insertRequestEvent(tx *sql.Tx, requestID, actorID int, from, to, comment string) error {
+	query := "INSERT INTO access_request_events (request_id, actor_id, from_state, to_state, comment, created_date) VALUES (?, ?, ?, ?, ?, NOW())"
+	_, err := tx.Exec(query, requestID, actorID, from, to, comment)
+	return err
+}
+
+// queryRequests retrieves the requests of a query selecting the columns of accessRequestQuery.
+func (r *AccessRequestRepository) queryRequests(query string, args ...interface{}) ([]*AccessRequest, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	requests := []*AccessRequest{}
+
+	for rows.Next() {
+		req := &AccessRequest{}
+		var expiryDate sql.NullTime
+		err := rows.Scan(&req.ID, &req.OrgID, &req.UserID, &req.UserName, &req.RoleID, &req.RoleName, &req.Justification, &req.DurationHours, &req.State, &expiryDate, &req.CreatedDate, &req.UpdatedDate)
+		if err != nil {
+			return nil, err
+		}
+		req.ExpiryDate = expiryDate.Time
+		requests = append(requests, req)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// CreateTable creates the access request tables in the database.
+func (r *AccessRequestRepository) CreateTable() error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS access_requests (
+		request_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		user_id INT NOT NULL,
+		role_id INT NOT NULL,
+		justification TEXT NOT NULL,
+		duration_hours INT NOT NULL DEFAULT 0,
+		state VARCHAR(16) NOT NULL,
+		expiry_date DATETIME,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		updated_date DATETIME NOT NULL DEFAULT NOW(),
+		INDEX (org_id, state),
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)`, `
+	CREATE TABLE IF NOT EXISTS access_request_events (
+		event_id INT AUTO_INCREMENT PRIMARY KEY,
+		request_id INT NOT NULL,
+		actor_id INT NOT NULL,
+		from_state VARCHAR(16) NOT NULL,
+		to_state VARCHAR(16) NOT NULL,
+		comment TEXT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		INDEX (request_id),
+		FOREIGN KEY (request_id) REFERENCES access_requests(request_id) ON DELETE CASCADE
+	)`}
+
+	for _, query := range queries {
+		_, err := r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package repositories
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Device defines a struct for a device a user has logged in from. ID is the secret device cookie and is
+// never returned to clients; Handle identifies the device in API responses and requests instead.
+type Device struct {
+	ID            string `json:"-"`
+	Handle        string
+	UserID        int
+	UserAgent     string
+	Trusted       bool
+	FirstSeenDate time.Time
+	LastSeenDate  time.Time
+}
+
+// DeviceRepository defines a struct for Device data storage and retrieval.
+type DeviceRepository struct {
+	db *sql.DB
+}
+
+// NewDeviceRepository creates a new DeviceRepository instance using the provided database connection.
+func NewDeviceRepository(db *sql.DB) *DeviceRepository {
+	return &DeviceRepository{db: db}
+}
+
+// DeviceHandle returns the opaque handle of the device with deviceID.
+func DeviceHandle(deviceID string) string {
+	sum := sha256.Sum256([]byte(deviceID))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create inserts a new Device record into the database. A stored record of the same device, seen with a
+// different user agent, is replaced, so that its trust is not carried over to the new user agent.
+func (r *DeviceRepository) Create(device *Device) error {
+	query := `
+	INSERT INTO user_devices (device_id, user_id, user_agent, trusted, first_seen_date, last_seen_date) VALUES (?, ?, ?, ?, NOW(), NOW())
+	ON DUPLICATE KEY UPDATE user_agent = VALUES(user_agent), trusted = VALUES(trusted), first_seen_date = NOW(), last_seen_date = NOW()
+	`
+	_, err := r.db.Exec(query, device.ID, device.UserID, device.UserAgent, device.Trusted)
+	return err
+}
+
+// Get retrieves a Device record of a user from the database. It returns nil when the user has never used the device.
+func (r *DeviceRepository) Get(userID int, deviceID string) (*Device, error) {
+	query := "SELECT device_id, user_id, user_agent, trusted, first_seen_date, last_seen_date FROM user_devices WHERE user_id = ? AND device_id = ?"
+	row := r.db.QueryRow(query, userID, deviceID)
+	device := &Device{}
+	err := row.Scan(&device.ID, &device.UserID, &device.UserAgent, &device.Trusted, &device.FirstSeenDate, &device.LastSeenDate)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	device.Handle = DeviceHandle(device.ID)
+	return device, nil
+}
+
+// GetAllForUser retrieves all Device records of a user from the database.
+func (r *DeviceRepository) GetAllForUser(userID int) ([]*Device, error) {
+	query := "SELECT device_id, user_id, user_agent, trusted, first_seen_date, last_seen_date FROM user_devices WHERE user_id = ? ORDER BY last_seen_date DESC"
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	devices := []*Device{}
+
+	for rows.Next() {
+		device := &Device{}
+		err := rows.Scan(&device.ID, &device.UserID, &device.UserAgent, &device.Trusted, &device.FirstSeenDate, &device.LastSeenDate)
+		if err != nil {
+			return nil, err
+		}
+		device.Handle = DeviceHandle(device.ID)
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// Touch records that a user logged in from a device again. A login can make the device trusted but never
+// distrusts it.
+func (r *DeviceRepository) Touch(device *Device) error {
+	query := "UPDATE user_devices SET trusted = trusted OR ?, last_seen_date = NOW() WHERE user_id = ? AND device_id = ?"
+	_, err := r.db.Exec(query, device.Trusted, device.UserID, device.ID)
+	return err
+}
+
+// SetTrusted marks a device of a user as trusted or untrusted.
+func (r *DeviceRepository) SetTrusted(userID int, deviceID string, trusted bool) error {
+	query := "UPDATE user_devices SET trusted = ? WHERE user_id = ? AND device_id = ?"
+	result, err := r.db.Exec(query, trusted, userID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// Delete removes a Device record of a user from the database, so the next login from it is treated as new.
+func (r *DeviceRepository) Delete(userID int, deviceID string) error {
+	query := "DELETE FROM user_devices WHERE user_id = ? AND device_id = ?"
+	_, err := r.db.Exec(query, userID, deviceID)
+	return err
+}
+
+// CreateTable creates the 'user_devices' table in the database.
+func (r *DeviceRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS user_devices (
+		device_id VARCHAR(64) NOT NULL,
+		user_id INT NOT NULL,
+		user_agent VARCHAR(512) NOT NULL,
+		trusted BOOLEAN NOT NULL DEFAULT FALSE,
+		first_seen_date DATETIME NOT NULL DEFAULT NOW(),
+		last_seen_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, device_id),
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	)
+	`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EligibleRole allows a user to activate a role on demand for up to MaxHours, instead of holding it
+// permanently. RequireMFA asks for a recent authentication with a second factor at activation and
+// RequireApproval routes the activation through an access request.
+type EligibleRole struct {
+	ID              int
+	OrgID           int
+	UserID          int
+	UserName        string
+	RoleID          int
+	RoleName        string
+	MaxHours        int
+	RequireMFA      bool
+	RequireApproval bool
+}
+
+// RoleActivation records the activation of an eligible role: the justification given and the
+// assignment it created, expiring at ExpiryDate.
+type RoleActivation struct {
+	ID            int
+	OrgID         int
+	UserID        int
+	UserName      string
+	RoleID        int
+	RoleName      string
+	Justification string
+	Hours         int
+	ActivatedDate time.Time
+	ExpiryDate    time.Time
+}
+
+// ErrRoleActive is returned by Activate and by role assignments when the user already holds the role.
+var ErrRoleActive = errors.New("role is already active for the user")
+
+// EligibleRoleRepository stores eligibilities and their activations. It only sees and modifies the
+// eligibilities of the organization it is scoped to with ForOrg.
+type EligibleRoleRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewEligibleRoleRepository creates a new EligibleRoleRepository with the given db instance
+func NewEligibleRoleRepository(db *sql.DB) *EligibleRoleRepository {
+	return &EligibleRoleRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *EligibleRoleRepository) ForOrg(orgID int) *EligibleRoleRepository {
+	return &EligibleRoleRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts a new eligibility.
+func (r *EligibleRoleRepository) Create(e *EligibleRole) error {
+	e.OrgID = r.orgID
+	query := "INSERT INTO eligible_roles (org_id, user_id, role_id, max_hours, require_mfa, require_approval, created_date) VALUES (?, ?, ?, ?, ?, ?, NOW())"
+	result, err := r.db.Exec(query, e.OrgID, e.UserID, e.RoleID, e.MaxHours, e.RequireMFA, e.RequireApproval)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.ID = int(id)
+
+	return nil
+}
+
+// Get retrieves an eligibility by ID. Returns sql.ErrNoRows if it does not exist in the organization.
+func (r *EligibleRoleRepository) Get(id int) (*EligibleRole, error) {
+	return r.get("WHERE er.eligibility_id = ? AND er.org_id = ?", id, r.orgID)
+}
+
+// GetForUserRole retrieves the eligibility of a user for a role. Returns sql.ErrNoRows if the user is
+// not eligible for the role in the organization.
+func (r *EligibleRoleRepository) GetForUserRole(userID, roleID int) (*EligibleRole, error) {
+	return r.get("WHERE er.user_id = ? AND er.role_id = ? AND er.org_id = ?", userID, roleID, r.orgID)
+}
+
+// GetAll retrieves the eligibilities of the organization, or of a user when userID is not zero.
+func (r *EligibleRoleRepository) GetAll(userID int) ([]*EligibleRole, error) {
+	return r.query("WHERE er.org_id = ? AND (? = 0 OR er.user_id = ?)", r.orgID, userID, userID)
+}
+
+// Delete removes an eligibility of the organization. Active assignments made from it run until they expire.
+func (r *EligibleRoleRepository) Delete(id int) error {
+	result, err := r.db.Exec("DELETE FROM eligible_roles WHERE eligibility_id = ? AND org_id = ?", id, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("eligibility not found")
+	}
+
+	return nil
+}
+
+// Activate assigns the role of an eligibility to its user for the given number of hours and records the
+// activation in the same transaction. As with any assignment, it returns ErrRoleActive if the user holds
+// an unexpired assignment of the role, and is subject to the separation of duties constraints; see
+// insertUserRole.
+func (r *EligibleRoleRepository) Activate(e *EligibleRole, justification string, hours int) (*RoleActivation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	activation := &RoleActivation{
+		OrgID:         r.orgID,
+		UserID:        e.UserID,
+		UserName:      e.UserName,
+		RoleID:        e.RoleID,
+		RoleName:      e.RoleName,
+		Justification: justification,
+		Hours:         hours,
+		ActivatedDate: time.Now(),
+	}
+	activation.ExpiryDate = activation.ActivatedDate.Add(time.Duration(hours) * time.Hour)
+
+	err = insertUserRole(tx, &UserRole{UserID: e.UserID, RoleID: e.RoleID, OrgID: r.orgID, ExpiryDate: activation.ExpiryDate})
+	if err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO role_activations (org_id, user_id, role_id, justification, hours, activated_date, expiry_date) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	result, err := tx.Exec(query, activation.OrgID, activation.UserID, activation.RoleID, activation.Justification, activation.Hours, activation.ActivatedDate, activation.ExpiryDate)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	activation.ID = int(id)
+
+	return activation, tx.Commit()
+}
+
+// GetActivations retrieves the activations of the organization, or of a user when userID is not zero,
+// newest first.
+func (r *EligibleRoleRepository) GetActivations(userID int) ([]*RoleActivation, error) {
+	query := `
+		SELECT ra.activation_id, ra.org_id, ra.user_id, u.user_name, ra.role_id, r.role_name, ra.justification, ra.hours, ra.activated_date, ra.expiry_date
+		FROM role_activations ra
+		JOIN users u ON ra.user_id = u.user_id
+		JOIN roles r ON ra.role_id = r.role_id
+		WHERE ra.org_id = ? AND (? = 0 OR ra.user_id = ?)
+		ORDER BY ra.activated_date DESC, ra.activation_id DESC
+	`
+	rows, err := r.db.Query(query, r.orgID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	activations := []*RoleActivation{}
+
+	for rows.Next() {
+		a := &RoleActivation{}
+		err := rows.Scan(&a.ID, &a.OrgID, &a.UserID, &a.UserName, &a.RoleID, &a.RoleName, &a.Justification, &a.Hours, &a.ActivatedDate, &a.ExpiryDate)
+		if err != nil {
+			return nil, err
+		}
+		activations = append(activations, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return activations, nil
+}
+
+// get retrieves the eligibility matching a WHERE clause on eligible_roles aliased as er. Returns
+// sql.ErrNoRows if there is none.
+func (r *EligibleRoleRepository) get(where string, args ...interface{}) (*EligibleRole, error) {
+	eligibilities, err := r.query(where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(eligibilities) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return eligibilities[0], nil
+}
+
+// query retrieves the eligibilities matching a WHERE clause on eligible_roles aliased as er.
+func (r *EligibleRoleRepository) query(where string, args ...interface{}) ([]*EligibleRole, error) {
+	query := `
+		SELECT er.eligibility_id, er.org_id, er.user_id, u.user_name, er.role_id, r.role_name, er.max_hours, er.require_mfa, er.require_approval
+		FROM eligible_roles er
+		JOIN users u ON er.user_id = u.user_id
+		JOIN roles r ON er.role_id = r.role_id
+		` + where + `
+		ORDER BY er.eligibility_id
+	`
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	eligibilities := []*EligibleRole{}
+
+	for rows.Next() {
+		e := &EligibleRole{}
+		err := rows.Scan(&e.ID, &e.OrgID, &e.UserID, &e.UserName, &e.RoleID, &e.RoleName, &e.MaxHours, &e.RequireMFA, &e.RequireApproval)
+		if err != nil {
+			return nil, err
+		}
+		eligibilities = append(eligibilities, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return eligibilities, nil
+}
+
+// CreateTable creates the eligibility and activation tables in the database.
+func (r *EligibleRoleRepository) CreateTable() error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS eligible_roles (
+		eligibility_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		user_id INT NOT NULL,
+		role_id INT NOT NULL,
+		max_hours INT NOT NULL,
+		require_mfa BOOLEAN NOT NULL DEFAULT FALSE,
+		require_approval BOOLEAN NOT NULL DEFAULT FALSE,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		UNIQUE (org_id, user_id, role_id),
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)`, `
+	CREATE TABLE IF NOT EXISTS role_activations (
+		activation_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		user_id INT NOT NULL,
+		role_id INT NOT NULL,
+		justification TEXT NOT NULL,
+		hours INT NOT NULL,
+		activated_date DATETIME NOT NULL,
+		expiry_date DATETIME NOT NULL,
+		INDEX (org_id, user_id),
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)`}
+
+	for _, query := range queries {
+		_, err := r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,461 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Group is a set of users that can hold roles. Groups may contain other groups, in which case the
+// members of the contained group are members of the containing group too. A group with OrgID
+// GlobalOrgID is global and grants its roles in every organization.
+type Group struct {
+	ID    int
+	OrgID int
+	Name  string
+}
+
+// GroupNesting makes the members of MemberGroupID members of GroupID.
+type GroupNesting struct {
+	GroupID       int
+	MemberGroupID int
+}
+
+// GroupRole assigns a role to every member of a group. A zero ExpiryDate means it does not expire.
+type GroupRole struct {
+	GroupID    int
+	RoleID     int
+	RoleName   string
+	ExpiryDate time.Time
+}
+
+// ErrGroupCycle is returned when nesting a group would make it contain itself.
+var ErrGroupCycle = errors.New("group nesting must not contain cycles")
+
+// GroupRepository defines a struct for Group data storage and retrieval.
+type GroupRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewGroupRepository creates a new GroupRepository instance using the provided database connection.
+func NewGroupRepository(db *sql.DB) *GroupRepository {
+	return &GroupRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization. The copy sees the groups of the
+// organization and global groups, and only modifies groups of the organization.
+func (r *GroupRepository) ForOrg(orgID int) *GroupRepository {
+	return &GroupRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts a new Group record into the database.
+func (r *GroupRepository) Create(group *Group) error {
+	group.OrgID = r.orgID
+	query := "INSERT INTO user_groups (org_id, group_name, created_date, updated_date) VALUES (?, ?, NOW(), NOW())"
+	result, err := r.db.Exec(query, group.OrgID, group.Name)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	group.ID = int(id)
+
+	return nil
+}
+
+// Get retrieves a Group record from the database by ID.
+func (r *GroupRepository) Get(id int) (*Group, error) {
+	query := "SELECT g.group_id, g.org_id, g.group_name FROM user_groups g WHERE g.group_id = ? AND " + orgVisible("g")
+	row := r.db.QueryRow(query, id, r.orgID)
+	group := &Group{}
+	err := row.Scan(&group.ID, &group.OrgID, &group.Name)
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// Update updates an existing Group record in the database.
+func (r *GroupRepository) Update(group *Group) error {
+	group.OrgID = r.orgID
+	query := "UPDATE user_groups SET group_name = ?, updated_date = NOW() WHERE group_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, group.Name, group.ID, group.OrgID)
+	return err
+}
+
+// Delete removes a Group record from the database by ID.
+func (r *GroupRepository) Delete(id int) error {
+	query := "DELETE FROM user_groups WHERE group_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, id, r.orgID)
+	return err
+}
+
+// GetAll retrieves all Group records from the database.
+func (r *GroupRepository) GetAll() ([]*Group, error) {
+	query := "SELECT g.group_id, g.org_id, g.group_name FROM user_groups g WHERE " + orgVisible("g")
+	return r.queryGroups(query, r.orgID)
+}
+
+// GetGroupsForUser retrieves the groups a user is a direct member of.
+func (r *GroupRepository) GetGroupsForUser(userID int) ([]*Group, error) {
+	query := "SELECT g.group_id, g.org_id, g.group_name FROM user_groups g INNER JOIN group_members gm ON g.group_id = gm.group_id WHERE gm.user_id = ? AND " + orgVisible("g")
+	return r.queryGroups(query, userID, r.orgID)
+}
+
+func (r *GroupRepository) queryGroups(query string, args ...interface{}) ([]*Group, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	groups := []*Group{}
+
+	for rows.Next() {
+		group := &Group{}
+		err := rows.Scan(&group.ID, &group.OrgID, &group.Name)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// AddMember adds a user to a group, rejecting with ErrSoDViolation a membership that would make the user
+// hold mutually exclusive roles in the organization of the repository; see SoDConstraint.
+func (r *GroupRepository) AddMember(groupID, userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	users := []int{userID}
+	before, err := heldRoleSets(tx, users, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO group_members (group_id, user_id, created_date) VALUES (?, ?, NOW())"
+	_, err = tx.Exec(query, groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = checkSoDGained(tx, users, before, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember removes a user from a group.
+func (r *GroupRepository) RemoveMember(groupID, userID int) error {
+	query := "DELETE FROM group_members WHERE group_id = ? AND user_id = ?"
+	_, err := r.db.Exec(query, groupID, userID)
+	return err
+}
+
+// GetMembers retrieves the users who are direct members of a group.
+func (r *GroupRepository) GetMembers(groupID int) ([]*User, error) {
+	query := "SELECT u.user_id, u.user_name, u.mobile, u.email_id FROM users u INNER JOIN group_members gm ON u.user_id = gm.user_id WHERE gm.group_id = ?"
+	rows, err := r.db.Query(query, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// AddSubgroup nests memberGroupID inside groupID, rejecting nestings that would create a cycle, and with
+// ErrSoDViolation nestings that would make a member of memberGroupID hold mutually exclusive roles.
+func (r *GroupRepository) AddSubgroup(groupID, memberGroupID int) error {
+	if groupID == memberGroupID {
+		return ErrGroupCycle
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the nesting so that concurrent writes cannot create a cycle together
+	_, err = tx.Exec("SELECT group_id FROM group_nesting FOR UPDATE")
+	if err != nil {
+		return err
+	}
+
+	// Nesting creates a cycle if the member group already contains the group
+	query := `
+		WITH RECURSIVE contained (group_id) AS (
+			SELECT ?
+			UNION
+			SELECT gn.member_group_id FROM group_nesting gn INNER JOIN contained c ON gn.group_id = c.group_id
+		)
+		SELECT COUNT(*) FROM contained WHERE group_id = ?
+	`
+	var count int
+	err = tx.QueryRow(query, memberGroupID, groupID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrGroupCycle
+	}
+
+	users, err := groupMemberIDs(tx, memberGroupID)
+	if err != nil {
+		return err
+	}
+
+	before, err := heldRoleSets(tx, users, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	query = "INSERT INTO group_nesting (group_id, member_group_id, created_date) VALUES (?, ?, NOW())"
+	_, err = tx.Exec(query, groupID, memberGroupID)
+	if err != nil {
+		return err
+	}
+
+	err = checkSoDGained(tx, users, before, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveSubgroup removes memberGroupID from groupID.
+func (r *GroupRepository) RemoveSubgroup(groupID, memberGroupID int) error {
+	query := "DELETE FROM group_nesting WHERE group_id = ? AND member_group_id = ?"
+	_, err := r.db.Exec(query, groupID, memberGroupID)
+	return err
+}
+
+// GetAllNesting retrieves all group nesting links from the database.
+func (r *GroupRepository) GetAllNesting() ([]*GroupNesting, error) {
+	query := "SELECT group_id, member_group_id FROM group_nesting"
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	nesting := []*GroupNesting{}
+
+	for rows.Next() {
+		n := &GroupNesting{}
+		err := rows.Scan(&n.GroupID, &n.MemberGroupID)
+		if err != nil {
+			return nil, err
+		}
+		nesting = append(nesting, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nesting, nil
+}
+
+// AssignRole assigns a role to a group, or updates the expiry of an existing assignment. It is rejected with
+// ErrSoDViolation if it would make a member of the group, direct or through a nested group, hold mutually
+// exclusive roles.
+func (r *GroupRepository) AssignRole(gr *GroupRole) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	users, err := groupMemberIDs(tx, gr.GroupID)
+	if err != nil {
+		return err
+	}
+
+	before, err := heldRoleSets(tx, users, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO group_roles (group_id, role_id, expiry_date, created_date, updated_date) VALUES (?, ?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE expiry_date = VALUES(expiry_date), updated_date = NOW()`
+	_, err = tx.Exec(query, gr.GroupID, gr.RoleID, nullTime(gr.ExpiryDate))
+	if err != nil {
+		return err
+	}
+
+	err = checkSoDGained(tx, users, before, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// groupMemberIDs returns the users who are members of a group, directly or through a nested group, within tx.
+func groupMemberIDs(tx *sql.Tx, groupID int) ([]int, error) {
+	query := `
+		WITH RECURSIVE contained (group_id) AS (
+			SELECT ?
+			UNION
+			SELECT gn.member_group_id FROM group_nesting gn INNER JOIN contained c ON gn.group_id = c.group_id
+		)
+		SELECT DISTINCT gm.user_id FROM group_members gm INNER JOIN contained c ON gm.group_id = c.group_id ORDER BY gm.user_id
+	`
+	rows, err := tx.Query(query, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []int{}
+
+	for rows.Next() {
+		var userID int
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// RevokeRole removes a role from a group.
+func (r *GroupRepository) RevokeRole(groupID, roleID int) error {
+	query := "DELETE FROM group_roles WHERE group_id = ? AND role_id = ?"
+	_, err := r.db.Exec(query, groupID, roleID)
+	return err
+}
+
+// GetRoles retrieves the roles assigned to a group, including expired ones.
+func (r *GroupRepository) GetRoles(groupID int) ([]*GroupRole, error) {
+	query := "SELECT gr.group_id, gr.role_id, r.role_name, gr.expiry_date FROM group_roles gr INNER JOIN roles r ON gr.role_id = r.role_id WHERE gr.group_id = ?"
+	return r.queryGroupRoles(query, groupID)
+}
+
+// GetAllActiveRoles retrieves every role assignment of every group that has not expired.
+func (r *GroupRepository) GetAllActiveRoles() ([]*GroupRole, error) {
+	query := "SELECT gr.group_id, gr.role_id, r.role_name, gr.expiry_date FROM group_roles gr INNER JOIN roles r ON gr.role_id = r.role_id WHERE " + activeGroupRole
+	return r.queryGroupRoles(query)
+}
+
+func (r *GroupRepository) queryGroupRoles(query string, args ...interface{}) ([]*GroupRole, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	groupRoles := []*GroupRole{}
+
+	for rows.Next() {
+		gr := &GroupRole{}
+		var expiryDate sql.NullTime
+		err := rows.Scan(&gr.GroupID, &gr.RoleID, &gr.RoleName, &expiryDate)
+		if err != nil {
+			return nil, err
+		}
+		gr.ExpiryDate = expiryDate.Time
+		groupRoles = append(groupRoles, gr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groupRoles, nil
+}
+
+// CreateTable creates the group tables in the database.
+func (r *GroupRepository) CreateTable() error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS user_groups (
+		group_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		group_name VARCHAR(255) NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		updated_date DATETIME NOT NULL DEFAULT NOW(),
+		UNIQUE (org_id, group_name)
+	)`, `
+	CREATE TABLE IF NOT EXISTS group_members (
+		group_id INT NOT NULL,
+		user_id INT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (group_id, user_id),
+		FOREIGN KEY (group_id) REFERENCES user_groups(group_id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	)`, `
+	CREATE TABLE IF NOT EXISTS group_nesting (
+		group_id INT NOT NULL,
+		member_group_id INT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (group_id, member_group_id),
+		FOREIGN KEY (group_id) REFERENCES user_groups(group_id) ON DELETE CASCADE,
+		FOREIGN KEY (member_group_id) REFERENCES user_groups(group_id) ON DELETE CASCADE
+	)`, `
+	CREATE TABLE IF NOT EXISTS group_roles (
+		group_id INT NOT NULL,
+		role_id INT NOT NULL,
+		expiry_date DATETIME,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		updated_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (group_id, role_id),
+		FOREIGN KEY (group_id) REFERENCES user_groups(group_id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)`}
+
+	for _, query := range queries {
+		_, err := r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package repositories
+
+import (
+	"database/sql"
+)
+
+// GlobalOrgID is the organization ID of global roles, groups and assignments, which are visible to
+// every organization. Repositories that have not been scoped with ForOrg only see global rows.
+const GlobalOrgID = 0
+
+// orgVisible restricts a query on a table aliased as the given alias to rows of the scoped organization
+// and global rows. It takes the organization ID once.
+func orgVisible(alias string) string {
+	return alias + ".org_id IN (0, ?)"
+}
+
+// orgScope restricts a query on a table aliased as the given alias like orgVisible, except that in the
+// global scope it keeps the rows of every organization. It takes the organization ID twice.
+func orgScope(alias string) string {
+	return "(? = 0 OR " + alias + ".org_id IN (0, ?))"
+}
+
+// userInOrg restricts a query on users to the members of the scoped organization, or to every user
+// when the repository is not scoped. It takes the organization ID twice.
+const userInOrg = "(? = 0 OR user_id IN (SELECT om.user_id FROM org_members om WHERE om.org_id = ?))"
+
+// Organization is a tenant. Users belong to one or more organizations and see only the roles,
+// groups and assignments of the organization they act in, plus global ones.
+type Organization struct {
+	ID   int
+	Name string
+}
+
+// OrgRepository defines a struct for Organization data storage and retrieval.
+type OrgRepository struct {
+	db *sql.DB
+}
+
+// NewOrgRepository creates a new OrgRepository instance using the provided database connection.
+func NewOrgRepository(db *sql.DB) *OrgRepository {
+	return &OrgRepository{db: db}
+}
+
+// Create inserts a new Organization record into the database.
+func (r *OrgRepository) Create(org *Organization) error {
+	query := "INSERT INTO organizations (org_name, created_date, updated_date) VALUES (?, NOW(), NOW())"
+	result, err := r.db.Exec(query, org.Name)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	org.ID = int(id)
+
+	return nil
+}
+
+// Get retrieves an Organization record from the database by ID.
+func (r *OrgRepository) Get(id int) (*Organization, error) {
+	query := "SELECT org_id, org_name FROM organizations WHERE org_id = ?"
+	row := r.db.QueryRow(query, id)
+	org := &Organization{}
+	err := row.Scan(&org.ID, &org.Name)
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// Update updates an existing Organization record in the database.
+func (r *OrgRepository) Update(org *Organization) error {
+	query := "UPDATE organizations SET org_name = ?, updated_date = NOW() WHERE org_id = ?"
+	_, err := r.db.Exec(query, org.Name, org.ID)
+	return err
+}
+
+// Delete removes an Organization record from the database by ID.
+func (r *OrgRepository) Delete(id int) error {
+	query := "DELETE FROM organizations WHERE org_id = ?"
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+// GetAll retrieves all Organization records from the database.
+func (r *OrgRepository) GetAll() ([]*Organization, error) {
+	query := "SELECT org_id, org_name FROM organizations"
+	return r.queryOrgs(query)
+}
+
+// GetOrgsForUser retrieves the organizations a user belongs to.
+func (r *OrgRepository) GetOrgsForUser(userID int) ([]*Organization, error) {
+	query := "SELECT o.org_id, o.org_name FROM organizations o INNER JOIN org_members om ON o.org_id = om.org_id WHERE om.user_id = ?"
+	return r.queryOrgs(query, userID)
+}
+
+func (r *OrgRepository) queryOrgs(query string, args ...interface{}) ([]*Organization, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	orgs := []*Organization{}
+
+	for rows.Next() {
+		org := &Organization{}
+		err := rows.Scan(&org.ID, &org.Name)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// AddMember adds a user to an organization.
+func (r *OrgRepository) AddMember(orgID, userID int) error {
+	query := "INSERT INTO org_members (org_id, user_id, created_date) VALUES (?, ?, NOW())"
+	_, err := r.db.Exec(query, orgID, userID)
+	return err
+}
+
+// RemoveMember removes a user from an organization together with the roles and role bindings the user holds in it.
+func (r *OrgRepository) RemoveMember(orgID, userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM user_roles WHERE org_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM role_bindings WHERE org_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM org_members WHERE org_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsMember reports whether a user belongs to an organization. Every user belongs to the global organization.
+func (r *OrgRepository) IsMember(orgID, userID int) (bool, error) {
+	if orgID == GlobalOrgID {
+		return true, nil
+	}
+
+	var count int
+	query := "SELECT COUNT(*) FROM org_members WHERE org_id = ? AND user_id = ?"
+	err := r.db.QueryRow(query, orgID, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetMembers retrieves the users who belong to an organization.
+func (r *OrgRepository) GetMembers(orgID int) ([]*User, error) {
+	query := "SELECT u.user_id, u.user_name, u.mobile, u.email_id FROM users u INNER JOIN org_members om ON u.user_id = om.user_id WHERE om.org_id = ?"
+	rows, err := r.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// CreateTable creates the organization tables in the database.
+func (r *OrgRepository) CreateTable() error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS organizations (
+		org_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_name VARCHAR(255) NOT NULL UNIQUE,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		updated_date DATETIME NOT NULL DEFAULT NOW()
+	)`, `
+	CREATE TABLE IF NOT EXISTS org_members (
+		org_id INT NOT NULL,
+		user_id INT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (org_id, user_id),
+		FOREIGN KEY (org_id) REFERENCES organizations(org_id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	)`}
+
+	for _, query := range queries {
+		_, err := r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateTenancy adds the org_id column to the tables created before organizations existed. Existing
+// rows become global, so a single-tenant deployment keeps working unchanged.
+func (r *OrgRepository) MigrateTenancy() error {
+	migrations := []struct {
+		table string
+		alter string
+	}{
+		{"roles", "ALTER TABLE roles ADD COLUMN org_id INT NOT NULL DEFAULT 0, DROP INDEX role_name, ADD UNIQUE (org_id, role_name)"},
+		{"user_roles", "ALTER TABLE user_roles ADD COLUMN org_id INT NOT NULL DEFAULT 0, DROP PRIMARY KEY, ADD PRIMARY KEY (user_id, role_id, org_id)"},
+		{"user_roles_history", "ALTER TABLE user_roles_history ADD COLUMN org_id INT NOT NULL DEFAULT 0"},
+		{"user_groups", "ALTER TABLE user_groups ADD COLUMN org_id INT NOT NULL DEFAULT 0, DROP INDEX group_name, ADD UNIQUE (org_id, group_name)"},
+		{"access_denies", "ALTER TABLE access_denies ADD COLUMN org_id INT NOT NULL DEFAULT 0, DROP INDEX user_id, ADD UNIQUE (user_id, access_id, org_id)"},
+		{"sessions", "ALTER TABLE sessions ADD COLUMN org_id INT NOT NULL DEFAULT 0"},
+	}
+
+	for _, m := range migrations {
+		var count int
+		query := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'org_id'"
+		err := r.db.QueryRow(query, m.table).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		_, err = r.db.Exec(m.alter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrResourceCycle is returned when registering a resource under one of its own descendants.
+var ErrResourceCycle = errors.New("resource cannot be nested under itself or one of its descendants")
+
+// ResourceRef identifies a resource owned by another service, such as project 42.
+type ResourceRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// String returns the reference as type:id.
+func (ref ResourceRef) String() string {
+	return ref.Type + ":" + ref.ID
+}
+
+// Resource is a node of the registered resource tree. A role bound on a resource applies to all of its
+// descendants. A resource without a parent is a root.
+type Resource struct {
+	Type   string       `json:"type"`
+	ID     string       `json:"id"`
+	Parent *ResourceRef `json:"parent,omitempty"`
+	OrgID  int          `json:"org_id"`
+}
+
+// Ref returns the reference of the resource.
+func (r *Resource) Ref() ResourceRef {
+	return ResourceRef{Type: r.Type, ID: r.ID}
+}
+
+// resourceAncestorsCTE defines resource_ancestors: a resource and every resource above it in the tree
+// visible to an organization, with their distance from it. It takes the resource type, the resource ID
+// and the organization ID. The walk stops after 32 levels, so that a corrupted tree cannot make it loop.
+const resourceAncestorsCTE = `
+		WITH RECURSIVE resource_ancestors (resource_type, resource_id, depth) AS (
+			SELECT CAST(? AS CHAR(64)), CAST(? AS CHAR(255)), 0
+			UNION
+			SELECT rs.parent_type, rs.parent_id, ra.depth + 1
+			FROM resources rs
+			JOIN resource_ancestors ra ON rs.resource_type = ra.resource_type AND rs.resource_id = ra.resource_id
+			WHERE rs.parent_type IS NOT NULL AND rs.org_id IN (0, ?) AND ra.depth < 32
+		)
+`
+
+// ResourceRepository stores the resource tree. Its methods see the resources of the organization the
+// repository is scoped to with ForOrg and global ones, and only modify resources of that organization.
+type ResourceRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewResourceRepository creates a new ResourceRepository with the given db instance
+func NewResourceRepository(db *sql.DB) *ResourceRepository {
+	return &ResourceRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *ResourceRepository) ForOrg(orgID int) *ResourceRepository {
+	return &ResourceRepository{db: r.db, orgID: orgID}
+}
+
+// Register adds a resource to the tree, or moves it under a new parent when it is already registered.
+func (r *ResourceRepository) Register(resource *Resource) error {
+	if resource.Parent != nil {
+		ancestors, err := r.GetAncestors(*resource.Parent)
+		if err != nil {
+			return err
+		}
+		for _, ancestor := range ancestors {
+			if ancestor == resource.Ref() {
+				return ErrResourceCycle
+			}
+		}
+	}
+
+	var parentType, parentID sql.NullString
+	if resource.Parent != nil {
+		parentType = sql.NullString{String: resource.Parent.Type, Valid: true}
+		parentID = sql.NullString{String: resource.Parent.ID, Valid: true}
+	}
+
+	resource.OrgID = r.orgID
+	query := `
+		INSERT INTO resources (org_id, resource_type, resource_id, parent_type, parent_id, created_date, updated_date)
+		VALUES (?, ?, ?, ?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE parent_type = VALUES(parent_type), parent_id = VALUES(parent_id), updated_date = NOW()
+	`
+	_, err := r.db.Exec(query, resource.OrgID, resource.Type, resource.ID, parentType, parentID)
+	return err
+}
+
+// Get retrieves a registered resource. Returns sql.ErrNoRows if the resource is not registered.
+func (r *ResourceRepository) Get(ref ResourceRef) (*Resource, error) {
+	query := "SELECT org_id, resource_type, resource_id, parent_type, parent_id FROM resources rs WHERE resource_type = ? AND resource_id = ? AND " + orgVisible("rs")
+	rows, err := r.db.Query(query, ref.Type, ref.ID, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := scanResources(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resources) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return resources[0], nil
+}
+
+// Delete removes a resource from the tree. Its children become roots.
+func (r *ResourceRepository) Delete(ref ResourceRef) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE resources SET parent_type = NULL, parent_id = NULL WHERE parent_type = ? AND parent_id = ? AND org_id = ?", ref.Type, ref.ID, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM resources WHERE resource_type = ? AND resource_id = ? AND org_id = ?", ref.Type, ref.ID, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetChildren retrieves the resources registered directly under a resource.
+func (r *ResourceRepository) GetChildren(ref ResourceRef) ([]*Resource, error) {
+	query := "SELECT org_id, resource_type, resource_id, parent_type, parent_id FROM resources rs WHERE parent_type = ? AND parent_id = ? AND " + orgVisible("rs")
+	rows, err := r.db.Query(query, ref.Type, ref.ID, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanResources(rows)
+}
+
+// GetAncestors returns a resource followed by the resources above it in the tree, nearest first. An
+// unregistered resource is returned alone.
+func (r *ResourceRepository) GetAncestors(ref ResourceRef) ([]ResourceRef, error) {
+	query := resourceAncestorsCTE + "SELECT resource_type, resource_id FROM resource_ancestors ORDER BY depth"
+	rows, err := r.db.Query(query, ref.Type, ref.ID, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	refs := []ResourceRef{}
+
+	for rows.Next() {
+		ancestor := ResourceRef{}
+		err := rows.Scan(&ancestor.Type, &ancestor.ID)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ancestor)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// scanResources scans and closes rows of resources
+func scanResources(rows *sql.Rows) ([]*Resource, error) {
+	defer rows.Close()
+
+	resources := []*Resource{}
+
+	for rows.Next() {
+		resource := &Resource{}
+		var parentType, parentID sql.NullString
+		err := rows.Scan(&resource.OrgID, &resource.Type, &resource.ID, &parentType, &parentID)
+		if err != nil {
+			return nil, err
+		}
+		if parentType.Valid {
+			resource.Parent = &ResourceRef{Type: parentType.String, ID: parentID.String}
+		}
+		resources = append(resources, resource)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// CreateTable creates the 'resources' table in the database.
+func (r *ResourceRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS resources (
+		org_id INT NOT NULL DEFAULT 0,
+		resource_type VARCHAR(64) NOT NULL,
+		resource_id VARCHAR(255) NOT NULL,
+		parent_type VARCHAR(64),
+		parent_id VARCHAR(255),
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		updated_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (org_id, resource_type, resource_id),
+		INDEX (parent_type, parent_id)
+	)
+`
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
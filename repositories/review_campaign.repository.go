@@ -0,0 +1,476 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Scopes of a review campaign: the assignments of one role, the assignments of the users reporting to
+// one manager, or all assignments of the organization.
+const (
+	ReviewScopeRole    = "role"
+	ReviewScopeManager = "manager"
+	ReviewScopeOrg     = "org"
+)
+
+// States of a review campaign. A campaign is completed when every item is decided or its deadline passes.
+const (
+	CampaignActive    = "active"
+	CampaignCompleted = "completed"
+)
+
+// Decisions on a review item. An item without a decision has not been reviewed.
+const (
+	ReviewApproved = "approved"
+	ReviewRevoked  = "revoked"
+)
+
+// ErrCampaignCompleted is returned when deciding an item of a campaign that is completed.
+var ErrCampaignCompleted = errors.New("review campaign is completed")
+
+// ErrItemDecided is returned when deciding an item that is already decided.
+var ErrItemDecided = errors.New("review item is already decided")
+
+// autoRevokeComment is recorded on the items revoked because they were not reviewed by the deadline.
+const autoRevokeComment = "revoked automatically: not reviewed by the deadline"
+
+// ReviewCampaign is a certification of the role assignments in scope at the time it was created. Each
+// assignment becomes an item reviewed by ReviewerID or, with ReviewByManager, by the manager of the
+// assigned user where one is recorded. With AutoRevoke, the items not reviewed by the deadline are revoked.
+type ReviewCampaign struct {
+	ID              int
+	OrgID           int
+	Name            string
+	Scope           string
+	ScopeID         int
+	ReviewerID      int
+	ReviewByManager bool
+	Deadline        time.Time
+	AutoRevoke      bool
+	State           string
+	CreatedBy       int
+	CreatedDate     time.Time
+	CompletedDate   time.Time
+}
+
+// ReviewItem is an assignment under review in a campaign, as it was when the campaign was created.
+// DecidedBy is zero for items decided automatically.
+type ReviewItem struct {
+	ID           int
+	CampaignID   int
+	UserID       int
+	UserName     string
+	RoleID       int
+	RoleName     string
+	OrgID        int
+	AssignedDate time.Time
+	ExpiryDate   time.Time
+	ReviewerID   int
+	ReviewerName string
+	Decision     string
+	DecidedBy    int
+	DecidedDate  time.Time
+	Comment      string
+}
+
+// reviewCampaignQuery selects the columns scanned by queryCampaigns; callers append the WHERE clause.
+const reviewCampaignQuery = `
+		SELECT c.campaign_id, c.org_id, c.campaign_name, c.scope, c.scope_id, c.reviewer_id, c.review_by_manager, c.deadline, c.auto_revoke, c.state, c.created_by, c.created_date, c.completed_date
+		FROM review_campaigns c
+`
+
+// reviewItemQuery selects the columns scanned by queryItems; callers append the WHERE clause.
+const reviewItemQuery = `
+		SELECT i.item_id, i.campaign_id, i.user_id, u.user_name, i.role_id, r.role_name, i.org_id, i.assigned_date, i.expiry_date, i.reviewer_id, rv.user_name, i.decision, COALESCE(i.decided_by, 0), i.decided_date, i.comment
+		FROM review_items i
+		JOIN users u ON i.user_id = u.user_id
+		JOIN roles r ON i.role_id = r.role_id
+		JOIN users rv ON i.reviewer_id = rv.user_id
+`
+
+// ReviewCampaignRepository stores access review campaigns and their items. It only sees and modifies the
+// campaigns of the organization it is scoped to with ForOrg, which review the assignments made in that
+// organization.
+type ReviewCampaignRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewReviewCampaignRepository creates a new ReviewCampaignRepository with the given db instance
+func NewReviewCampaignRepository(db *sql.DB) *ReviewCampaignRepository {
+	return &ReviewCampaignRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *ReviewCampaignRepository) ForOrg(orgID int) *ReviewCampaignRepository {
+	return &ReviewCampaignRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts an active campaign and snapshots the assignments in its scope as items: the unexpired
+// assignments of the organization, of the role or of the direct reports of the manager given by ScopeID.
+// It returns the number of items. A campaign without items is completed immediately.
+func (r *ReviewCampaignRepository) Create(c *ReviewCampaign) (int64, error) {
+	c.OrgID = r.orgID
+	c.State = CampaignActive
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO review_campaigns (org_id, campaign_name, scope, scope_id, reviewer_id, review_by_manager, deadline, auto_revoke, state, created_by, created_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())
+	`
+	result, err := tx.Exec(query, c.OrgID, c.Name, c.Scope, c.ScopeID, c.ReviewerID, c.ReviewByManager, c.Deadline, c.AutoRevoke, c.State, c.CreatedBy)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	c.ID = int(id)
+
+	query = `
+		INSERT INTO review_items (campaign_id, user_id, role_id, org_id, assigned_date, expiry_date, reviewer_id, decision, comment)
+		SELECT ?, ur.user_id, ur.role_id, ur.org_id, ur.created_date, ur.expiry_date,
+			CASE WHEN ? AND um.manager_id IS NOT NULL THEN um.manager_id ELSE ? END, '', ''
+		FROM user_roles ur
+		LEFT JOIN user_managers um ON ur.user_id = um.user_id
+		WHERE ur.org_id = ? AND (ur.expiry_date IS NULL OR ur.expiry_date > NOW())
+		AND (? <> 'role' OR ur.role_id = ?)
+		AND (? <> 'manager' OR um.manager_id = ?)
+	`
+	result, err = tx.Exec(query, c.ID, c.ReviewByManager, c.ReviewerID, c.OrgID, c.Scope, c.ScopeID, c.Scope, c.ScopeID)
+	if err != nil {
+		return 0, err
+	}
+
+	items, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if items == 0 {
+		err = completeCampaign(tx, c.ID)
+		if err != nil {
+			return 0, err
+		}
+		c.State = CampaignCompleted
+	}
+
+	return items, tx.Commit()
+}
+
+// Get retrieves a campaign by ID. Returns sql.ErrNoRows if it does not exist in the organization.
+func (r *ReviewCampaignRepository) Get(id int) (*ReviewCampaign, error) {
+	campaigns, err := r.queryCampaigns(reviewCampaignQuery+"WHERE c.campaign_id = ? AND c.org_id = ?", id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(campaigns) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return campaigns[0], nil
+}
+
+// GetAll retrieves the campaigns of the organization, newest first.
+func (r *ReviewCampaignRepository) GetAll() ([]*ReviewCampaign, error) {
+	return r.queryCampaigns(reviewCampaignQuery+"WHERE c.org_id = ? ORDER BY c.campaign_id DESC", r.orgID)
+}
+
+// GetOverdue retrieves the active campaigns of every organization whose deadline has passed.
+func (r *ReviewCampaignRepository) GetOverdue() ([]*ReviewCampaign, error) {
+	return r.queryCampaigns(reviewCampaignQuery+"WHERE c.state = ? AND c.deadline <= NOW()", CampaignActive)
+}
+
+// GetItems retrieves the items of a campaign.
+func (r *ReviewCampaignRepository) GetItems(campaignID int) ([]*ReviewItem, error) {
+	query := reviewItemQuery + `
+		JOIN review_campaigns c ON i.campaign_id = c.campaign_id
+		WHERE i.campaign_id = ? AND c.org_id = ?
+		ORDER BY u.user_name, r.role_name
+	`
+	return r.queryItems(query, campaignID, r.orgID)
+}
+
+// GetItem retrieves an item of a campaign of the organization. Returns sql.ErrNoRows if it does not exist.
+func (r *ReviewCampaignRepository) GetItem(id int) (*ReviewItem, error) {
+	query := reviewItemQuery + `
+		JOIN review_campaigns c ON i.campaign_id = c.campaign_id
+		WHERE i.item_id = ? AND c.org_id = ?
+	`
+	items, err := r.queryItems(query, id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return items[0], nil
+}
+
+// GetPendingForReviewer retrieves the undecided items of active campaigns that a user reviews.
+func (r *ReviewCampaignRepository) GetPendingForReviewer(reviewerID int) ([]*ReviewItem, error) {
+	query := reviewItemQuery + `
+		JOIN review_campaigns c ON i.campaign_id = c.campaign_id
+		WHERE i.reviewer_id = ? AND i.decision = '' AND c.state = ? AND c.org_id = ?
+		ORDER BY c.deadline, i.item_id
+	`
+	return r.queryItems(query, reviewerID, CampaignActive, r.orgID)
+}
+
+// Decide records the decision of a reviewer on an item. Revoking removes the assignment, archiving it to
+// the assignment history. The campaign is completed when its last item is decided.
+func (r *ReviewCampaignRepository) Decide(itemID, actorID int, decision, comment string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var campaignID, userID, roleID, orgID int
+	var current, state string
+	query := `
+		SELECT i.campaign_id, i.user_id, i.role_id, i.org_id, i.decision, c.state
+		FROM review_items i
+		JOIN review_campaigns c ON i.campaign_id = c.campaign_id
+		WHERE i.item_id = ? AND c.org_id = ?
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, itemID, r.orgID).Scan(&campaignID, &userID, &roleID, &orgID, &current, &state)
+	if err != nil {
+		return err
+	}
+
+	if state != CampaignActive {
+		return ErrCampaignCompleted
+	}
+	if current != "" {
+		return ErrItemDecided
+	}
+
+	if decision == ReviewRevoked {
+		err = revokeUserRole(tx, userID, roleID, orgID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query = "UPDATE review_items SET decision = ?, decided_by = ?, decided_date = NOW(), comment = ? WHERE item_id = ?"
+	_, err = tx.Exec(query, decision, actorID, comment, itemID)
+	if err != nil {
+		return err
+	}
+
+	var pending int
+	err = tx.QueryRow("SELECT COUNT(*) FROM review_items WHERE campaign_id = ? AND decision = ''", campaignID).Scan(&pending)
+	if err != nil {
+		return err
+	}
+
+	if pending == 0 {
+		err = completeCampaign(tx, campaignID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Reassign hands an undecided item of an active campaign over to another reviewer.
+func (r *ReviewCampaignRepository) Reassign(itemID, reviewerID int) error {
+	query := `
+		UPDATE review_items i
+		JOIN review_campaigns c ON i.campaign_id = c.campaign_id
+		SET i.reviewer_id = ?
+		WHERE i.item_id = ? AND c.org_id = ? AND c.state = ? AND i.decision = ''
+	`
+	result, err := r.db.Exec(query, reviewerID, itemID, r.orgID, CampaignActive)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("item not found or already decided")
+	}
+
+	return nil
+}
+
+// Close completes an active campaign of the organization at its deadline. With AutoRevoke, the undecided
+// items are revoked and returned; otherwise they stay undecided and the assignments are kept.
+func (r *ReviewCampaignRepository) Close(id int) ([]*ReviewItem, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var autoRevoke bool
+	var state string
+	query := "SELECT auto_revoke, state FROM review_campaigns WHERE campaign_id = ? AND org_id = ? FOR UPDATE"
+	err = tx.QueryRow(query, id, r.orgID).Scan(&autoRevoke, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	if state != CampaignActive {
+		return nil, ErrCampaignCompleted
+	}
+
+	revoked := []*ReviewItem{}
+	if autoRevoke {
+		query := reviewItemQuery + "WHERE i.campaign_id = ? AND i.decision = ''"
+		revoked, err = r.queryItems(query, id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range revoked {
+			err = revokeUserRole(tx, item.UserID, item.RoleID, item.OrgID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		query = "UPDATE review_items SET decision = ?, decided_date = NOW(), comment = ? WHERE campaign_id = ? AND decision = ''"
+		_, err = tx.Exec(query, ReviewRevoked, autoRevokeComment, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = completeCampaign(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+// completeCampaign marks a campaign completed within tx.
+func completeCampaign(tx *sql.Tx, id int) error {
+	_, err := tx.Exec("UPDATE review_campaigns SET state = ?, completed_date = NOW() WHERE campaign_id = ?", CampaignCompleted, id)
+	return err
+}
+
+// queryCampaigns retrieves the campaigns of a query selecting the columns of reviewCampaignQuery.
+func (r *ReviewCampaignRepository) queryCampaigns(query string, args ...interface{}) ([]*ReviewCampaign, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	campaigns := []*ReviewCampaign{}
+
+	for rows.Next() {
+		c := &ReviewCampaign{}
+		var completedDate sql.NullTime
+		err := rows.Scan(&c.ID, &c.OrgID, &c.Name, &c.Scope, &c.ScopeID, &c.ReviewerID, &c.ReviewByManager, &c.Deadline, &c.AutoRevoke, &c.State, &c.CreatedBy, &c.CreatedDate, &completedDate)
+		if err != nil {
+			return nil, err
+		}
+		c.CompletedDate = completedDate.Time
+		campaigns = append(campaigns, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return campaigns, nil
+}
+
+// queryItems retrieves the items of a query selecting the columns of reviewItemQuery.
+func (r *ReviewCampaignRepository) queryItems(query string, args ...interface{}) ([]*ReviewItem, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := []*ReviewItem{}
+
+	for rows.Next() {
+		item := &ReviewItem{}
+		var expiryDate, decidedDate sql.NullTime
+		err := rows.Scan(&item.ID, &item.CampaignID, &item.UserID, &item.UserName, &item.RoleID, &item.RoleName, &item.OrgID, &item.AssignedDate, &expiryDate, &item.ReviewerID, &item.ReviewerName, &item.Decision, &item.DecidedBy, &decidedDate, &item.Comment)
+		if err != nil {
+			return nil, err
+		}
+		item.ExpiryDate = expiryDate.Time
+		item.DecidedDate = decidedDate.Time
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// CreateTable creates the review campaign tables in the database.
+func (r *ReviewCampaignRepository) CreateTable() error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS review_campaigns (
+		campaign_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		campaign_name VARCHAR(255) NOT NULL,
+		scope VARCHAR(16) NOT NULL,
+		scope_id INT NOT NULL DEFAULT 0,
+		reviewer_id INT NOT NULL,
+		review_by_manager BOOLEAN NOT NULL DEFAULT FALSE,
+		deadline DATETIME NOT NULL,
+		auto_revoke BOOLEAN NOT NULL DEFAULT FALSE,
+		state VARCHAR(16) NOT NULL,
+		created_by INT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		completed_date DATETIME,
+		INDEX (state, deadline)
+	)`, `
+	CREATE TABLE IF NOT EXISTS review_items (
+		item_id INT AUTO_INCREMENT PRIMARY KEY,
+		campaign_id INT NOT NULL,
+		user_id INT NOT NULL,
+		role_id INT NOT NULL,
+		org_id INT NOT NULL DEFAULT 0,
+		assigned_date DATETIME NOT NULL,
+		expiry_date DATETIME,
+		reviewer_id INT NOT NULL,
+		decision VARCHAR(16) NOT NULL DEFAULT '',
+		decided_by INT,
+		decided_date DATETIME,
+		comment TEXT NOT NULL,
+		INDEX (campaign_id),
+		INDEX (reviewer_id, decision),
+		FOREIGN KEY (campaign_id) REFERENCES review_campaigns(campaign_id) ON DELETE CASCADE
+	)`}
+
+	for _, query := range queries {
+		_, err := r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
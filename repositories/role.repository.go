@@ -4,22 +4,32 @@ import (
 	"database/sql"
 )
 
+// Role is a named set of accesses. A role with OrgID GlobalOrgID is global and can be assigned in every organization.
 type Role struct {
-	ID   int
-	Name string
+	ID    int
+	OrgID int
+	Name  string
 }
 
 type RoleRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	orgID int
 }
 
 func NewRoleRepository(db *sql.DB) *RoleRepository {
 	return &RoleRepository{db: db}
 }
 
+// ForOrg returns a copy of the repository scoped to an organization. The copy sees the roles of the
+// organization and global roles, and only modifies roles of the organization.
+func (r *RoleRepository) ForOrg(orgID int) *RoleRepository {
+	return &RoleRepository{db: r.db, orgID: orgID}
+}
+
 func (r *RoleRepository) Create(role *Role) error {
-	query := "INSERT INTO roles (role_name, created_date, updated_date) VALUES (?, NOW(), NOW())"
-	result, err := r.db.Exec(query, role.Name)
+	role.OrgID = r.orgID
+	query := "INSERT INTO roles (org_id, role_name, created_date, updated_date) VALUES (?, ?, NOW(), NOW())"
+	result, err := r.db.Exec(query, role.OrgID, role.Name)
 	if err != nil {
 		return err
 	}
@@ -35,10 +45,10 @@ func (r *RoleRepository) Create(role *Role) error {
 }
 
 func (r *RoleRepository) Get(id int) (*Role, error) {
-	query := "SELECT role_id, role_name FROM roles WHERE role_id = ?"
-	row := r.db.QueryRow(query, id)
+	query := "SELECT r.role_id, r.org_id, r.role_name FROM roles r WHERE r.role_id = ? AND " + orgVisible("r")
+	row := r.db.QueryRow(query, id, r.orgID)
 	role := &Role{}
-	err := row.Scan(&role.ID, &role.Name)
+	err := row.Scan(&role.ID, &role.OrgID, &role.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -46,8 +56,9 @@ func (r *RoleRepository) Get(id int) (*Role, error) {
 }
 
 func (r *RoleRepository) Update(role *Role) error {
-	query := "UPDATE roles SET role_name = ?, updated_date = NOW() WHERE role_id = ?"
-	_, err := r.db.Exec(query, role.Name, role.ID)
+	role.OrgID = r.orgID
+	query := "UPDATE roles SET role_name = ?, updated_date = NOW() WHERE role_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, role.Name, role.ID, role.OrgID)
 	if err != nil {
 		return err
 	}
@@ -56,8 +67,8 @@ func (r *RoleRepository) Update(role *Role) error {
 }
 
 func (r *RoleRepository) Delete(id int) error {
-	query := "DELETE FROM roles WHERE role_id = ?"
-	_, err := r.db.Exec(query, id)
+	query := "DELETE FROM roles WHERE role_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, id, r.orgID)
 	if err != nil {
 		return err
 	}
@@ -66,8 +77,8 @@ func (r *RoleRepository) Delete(id int) error {
 }
 
 func (r *RoleRepository) GetAll() ([]*Role, error) {
-	query := "SELECT role_id, role_name FROM roles"
-	rows, err := r.db.Query(query)
+	query := "SELECT r.role_id, r.org_id, r.role_name FROM roles r WHERE " + orgVisible("r")
+	rows, err := r.db.Query(query, r.orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +89,7 @@ func (r *RoleRepository) GetAll() ([]*Role, error) {
 
 	for rows.Next() {
 		role := &Role{}
-		err := rows.Scan(&role.ID, &role.Name)
+		err := rows.Scan(&role.ID, &role.OrgID, &role.Name)
 		if err != nil {
 			return nil, err
 		}
@@ -96,9 +107,11 @@ func (r *RoleRepository) CreateTable() error {
 	query := `
         CREATE TABLE IF NOT EXISTS roles (
             role_id INT AUTO_INCREMENT PRIMARY KEY,
-            role_name VARCHAR(255) NOT NULL UNIQUE,
+            org_id INT NOT NULL DEFAULT 0,
+            role_name VARCHAR(255) NOT NULL,
 			created_date DATETIME NOT NULL DEFAULT NOW(),
-			updated_date DATETIME NOT NULL DEFAULT NOW()
+			updated_date DATETIME NOT NULL DEFAULT NOW(),
+			UNIQUE (org_id, role_name)
 			)`
 	_, err := r.db.Exec(query)
 	if err != nil {
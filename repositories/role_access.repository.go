@@ -4,25 +4,44 @@ import (
 	"database/sql"
 )
 
+// RoleAccess grants an access to a role. A grant with a Condition only holds when the condition is
+// satisfied by the attributes of the request and the user; see package condition.
 type RoleAccess struct {
-	RoleID   int
-	AccessID int
+	RoleID    int
+	AccessID  int
+	Condition string
+}
+
+// InheritedAccess describes an access a role holds, either directly or through one of its ancestors.
+type InheritedAccess struct {
+	Access
+	SourceRoleID   int
+	SourceRoleName string
+	Inherited      bool
+	Condition      string
 }
 
 // RoleAccessRepository is a struct that handles all database operations related to RoleAccess
 type RoleAccessRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	orgID int
 }
 
 // NewRoleAccessRepository creates a new RoleAccessRepository with the given db instance
 func NewRoleAccessRepository(db *sql.DB) *RoleAccessRepository {
-	return &RoleAccessRepository{db}
+	return &RoleAccessRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization, whose role listings only include
+// the roles of the organization and global roles
+func (r *RoleAccessRepository) ForOrg(orgID int) *RoleAccessRepository {
+	return &RoleAccessRepository{db: r.db, orgID: orgID}
 }
 
 // Create creates a new role access object in the database
 func (r *RoleAccessRepository) Create(ra *RoleAccess) error {
-	query := "INSERT INTO access_role (role_id, access_id, created_date, updated_date) VALUES (?, ?, NOW(), NOW())"
-	result, err := r.db.Exec(query, ra.RoleID, ra.AccessID)
+	query := "INSERT INTO access_role (role_id, access_id, condition_expr, created_date, updated_date) VALUES (?, ?, ?, NOW(), NOW())"
+	result, err := r.db.Exec(query, ra.RoleID, ra.AccessID, nullString(ra.Condition))
 	if err != nil {
 		return err
 	}
@@ -37,13 +56,15 @@ func (r *RoleAccessRepository) Create(ra *RoleAccess) error {
 
 // Get retrieves a role access object with the given role ID and access ID from the database
 func (r *RoleAccessRepository) Get(roleID, accessID int) (*RoleAccess, error) {
-	query := "SELECT role_id, access_id FROM access_role WHERE role_id = ? AND access_id = ?"
+	query := "SELECT role_id, access_id, condition_expr FROM access_role WHERE role_id = ? AND access_id = ?"
 	row := r.db.QueryRow(query, roleID, accessID)
 	roleAccess := &RoleAccess{}
-	err := row.Scan(&roleAccess.RoleID, &roleAccess.AccessID)
+	var condition sql.NullString
+	err := row.Scan(&roleAccess.RoleID, &roleAccess.AccessID, &condition)
 	if err != nil {
 		return nil, err
 	}
+	roleAccess.Condition = condition.String
 	return roleAccess, nil
 }
 
@@ -60,7 +81,7 @@ func (r *RoleAccessRepository) Delete(roleID, accessID int) error {
 
 // GetAll retrieves all role access objects from the database
 func (r *RoleAccessRepository) GetAll() ([]*RoleAccess, error) {
-	query := "SELECT role_id, access_id FROM access_role"
+	query := "SELECT role_id, access_id, condition_expr FROM access_role"
 	rows, err := r.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -72,10 +93,12 @@ func (r *RoleAccessRepository) GetAll() ([]*RoleAccess, error) {
 
 	for rows.Next() {
 		roleAccess := &RoleAccess{}
-		err := rows.Scan(&roleAccess.RoleID, &roleAccess.AccessID)
+		var condition sql.NullString
+		err := rows.Scan(&roleAccess.RoleID, &roleAccess.AccessID, &condition)
 		if err != nil {
 			return nil, err
 		}
+		roleAccess.Condition = condition.String
 		roleAccesses = append(roleAccesses, roleAccess)
 	}
 
@@ -86,8 +109,47 @@ func (r *RoleAccessRepository) GetAll() ([]*RoleAccess, error) {
 	return roleAccesses, nil
 }
 
+// GetRolesForAccess retrieves all visible roles that hold the access with the given ID from the database
+func (r *RoleAccessRepository) GetRolesForAccess(accessID int) ([]*Role, error) {
+	query := "SELECT r.role_id, r.org_id, r.role_name FROM roles r INNER JOIN access_role ar ON r.role_id = ar.role_id WHERE ar.access_id = ? AND " + orgVisible("r")
+	rows, err := r.db.Query(query, accessID, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		role := &Role{}
+		err := rows.Scan(&role.ID, &role.OrgID, &role.Name)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetAccessesForRole retrieves the effective accesses of a role, including those inherited from its ancestors
 func (r *RoleRepository) GetAccessesForRole(roleID int) ([]*Access, error) {
-	query := "SELECT a.access_id, a.access_name FROM access a INNER JOIN access_role ar ON a.access_id = ar.access_id WHERE ar.role_id = ?"
+	query := `
+		WITH RECURSIVE role_tree (role_id) AS (
+			SELECT ?
+			UNION
+			SELECT rp.parent_role_id FROM role_parents rp INNER JOIN role_tree rt ON rp.role_id = rt.role_id
+		)
+		SELECT DISTINCT ` + accessColumns + `
+		FROM role_tree rt
+		INNER JOIN access_role ar ON rt.role_id = ar.role_id
+		INNER JOIN access a ON ar.access_id = a.access_id
+	`
 	rows, err := r.db.Query(query, roleID)
 	if err != nil {
 		return nil, err
@@ -98,8 +160,7 @@ func (r *RoleRepository) GetAccessesForRole(roleID int) ([]*Access, error) {
 	accesses := []*Access{}
 
 	for rows.Next() {
-		access := &Access{}
-		err := rows.Scan(&access.ID, &access.Name)
+		access, err := scanAccess(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -113,15 +174,59 @@ func (r *RoleRepository) GetAccessesForRole(roleID int) ([]*Access, error) {
 	return accesses, nil
 }
 
+// GetInheritedAccesses retrieves the effective accesses of a role together with the role each one comes from
+func (r *RoleRepository) GetInheritedAccesses(roleID int) ([]*InheritedAccess, error) {
+	query := `
+		WITH RECURSIVE role_tree (role_id) AS (
+			SELECT ?
+			UNION
+			SELECT rp.parent_role_id FROM role_parents rp INNER JOIN role_tree rt ON rp.role_id = rt.role_id
+		)
+		SELECT ` + accessColumns + `, r.role_id, r.role_name, ar.condition_expr
+		FROM role_tree rt
+		INNER JOIN roles r ON rt.role_id = r.role_id
+		INNER JOIN access_role ar ON rt.role_id = ar.role_id
+		INNER JOIN access a ON ar.access_id = a.access_id
+		ORDER BY a.access_id, r.role_id
+	`
+	rows, err := r.db.Query(query, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	accesses := []*InheritedAccess{}
+
+	for rows.Next() {
+		access := &InheritedAccess{}
+		var condition sql.NullString
+		err := rows.Scan(&access.ID, &access.Name, &access.Namespace, &access.Resource, &access.Action, &access.SourceRoleID, &access.SourceRoleName, &condition)
+		if err != nil {
+			return nil, err
+		}
+		access.Condition = condition.String
+		access.Inherited = access.SourceRoleID != roleID
+		accesses = append(accesses, access)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accesses, nil
+}
+
 func (r *RoleAccessRepository) CreateTable() error {
 	query := `
 	CREATE TABLE IF NOT EXISTS access_role (
 		role_id INT NOT NULL,
 		access_id INT NOT NULL,
+		condition_expr TEXT,
 		created_date DATETIME NOT NULL DEFAULT NOW(),
 		updated_date DATETIME NOT NULL DEFAULT NOW(),
 		PRIMARY KEY (role_id, access_id),
-		FOREIGN KEY (role_id) REFERENCES role(role_id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE,
 		FOREIGN KEY (access_id) REFERENCES access(access_id) ON DELETE CASCADE
 	)	
 `
@@ -132,3 +237,44 @@ func (r *RoleAccessRepository) CreateTable() error {
 
 	return nil
 }
+
+// HasConditions reports whether any access is granted to a role on a condition, or any role is assigned
+// to the given user on a condition. Callers use it to skip evaluating conditions when there are none.
+func (r *RoleAccessRepository) HasConditions(userID int) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM access_role WHERE condition_expr IS NOT NULL) OR EXISTS (SELECT 1 FROM user_roles WHERE user_id = ? AND condition_expr IS NOT NULL)"
+	var has bool
+	err := r.db.QueryRow(query, userID).Scan(&has)
+	if err != nil {
+		return false, err
+	}
+	return has, nil
+}
+
+// MigrateConditions adds the condition_expr column to the role grant tables created before conditional
+// grants existed: access_role and user_roles. Existing grants stay unconditional.
+func (r *RoleAccessRepository) MigrateConditions() error {
+	for _, table := range []string{"access_role", "user_roles"} {
+		var count int
+		query := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'condition_expr'"
+		err := r.db.QueryRow(query, table).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		_, err = r.db.Exec("ALTER TABLE " + table + " ADD COLUMN condition_expr TEXT")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
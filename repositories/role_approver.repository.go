@@ -0,0 +1,165 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// RoleApprover designates who may decide access requests for a role: either a user, or every user
+// holding ApproverRoleID. Exactly one of UserID and ApproverRoleID is set; Name is the name of the
+// user or role. An approver with OrgID GlobalOrgID decides requests in every organization.
+type RoleApprover struct {
+	ID             int
+	OrgID          int
+	RoleID         int
+	UserID         int
+	ApproverRoleID int
+	Name           string
+}
+
+// RoleApproverRepository stores the approvers of roles. It sees the approvers of the organization it is
+// scoped to with ForOrg and global ones, and only modifies approvers of that organization.
+type RoleApproverRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewRoleApproverRepository creates a new RoleApproverRepository with the given db instance
+func NewRoleApproverRepository(db *sql.DB) *RoleApproverRepository {
+	return &RoleApproverRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *RoleApproverRepository) ForOrg(orgID int) *RoleApproverRepository {
+	return &RoleApproverRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts a new approver of a role.
+func (r *RoleApproverRepository) Create(a *RoleApprover) error {
+	a.OrgID = r.orgID
+	query := "INSERT INTO role_approvers (org_id, role_id, approver_user_id, approver_role_id, created_date) VALUES (?, ?, ?, ?, NOW())"
+	result, err := r.db.Exec(query, a.OrgID, a.RoleID, nullID(a.UserID), nullID(a.ApproverRoleID))
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	a.ID = int(id)
+
+	return nil
+}
+
+// Get retrieves an approver by ID. Returns sql.ErrNoRows if it does not exist or is not visible.
+func (r *RoleApproverRepository) Get(id int) (*RoleApprover, error) {
+	approvers, err := r.query("WHERE ra.approver_id = ? AND "+orgVisible("ra"), id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(approvers) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return approvers[0], nil
+}
+
+// Delete removes an approver of the organization.
+func (r *RoleApproverRepository) Delete(id int) error {
+	result, err := r.db.Exec("DELETE FROM role_approvers WHERE approver_id = ? AND org_id = ?", id, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("approver not found")
+	}
+
+	return nil
+}
+
+// GetForRole retrieves the visible approvers of a role.
+func (r *RoleApproverRepository) GetForRole(roleID int) ([]*RoleApprover, error) {
+	return r.query("WHERE ra.role_id = ? AND "+orgVisible("ra"), roleID, r.orgID)
+}
+
+// IsApprover reports whether a user may decide requests for a role, being a visible approver of it
+// directly or by holding an approving role.
+func (r *RoleApproverRepository) IsApprover(roleID, userID int) (bool, error) {
+	query := effectiveRolesCTE + `
+		SELECT COUNT(*) FROM role_approvers ra
+		WHERE ra.role_id = ? AND ` + orgVisible("ra") + `
+		AND (ra.approver_user_id = ? OR ra.approver_role_id IN (SELECT role_id FROM effective_roles))
+	`
+	args := append(effectiveRolesArgs(userID, r.orgID), roleID, r.orgID, userID)
+
+	var count int
+	err := r.db.QueryRow(query, args...).Scan(&count)
+	return count > 0, err
+}
+
+// query retrieves the approvers matching a WHERE clause on role_approvers aliased as ra.
+func (r *RoleApproverRepository) query(where string, args ...interface{}) ([]*RoleApprover, error) {
+	query := `
+		SELECT ra.approver_id, ra.org_id, ra.role_id, COALESCE(ra.approver_user_id, 0), COALESCE(ra.approver_role_id, 0), COALESCE(u.user_name, r.role_name)
+		FROM role_approvers ra
+		LEFT JOIN users u ON ra.approver_user_id = u.user_id
+		LEFT JOIN roles r ON ra.approver_role_id = r.role_id
+		` + where + `
+		ORDER BY ra.approver_id
+	`
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	approvers := []*RoleApprover{}
+
+	for rows.Next() {
+		a := &RoleApprover{}
+		err := rows.Scan(&a.ID, &a.OrgID, &a.RoleID, &a.UserID, &a.ApproverRoleID, &a.Name)
+		if err != nil {
+			return nil, err
+		}
+		approvers = append(approvers, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return approvers, nil
+}
+
+// CreateTable creates the 'role_approvers' table in the database.
+func (r *RoleApproverRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS role_approvers (
+		approver_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		role_id INT NOT NULL,
+		approver_user_id INT,
+		approver_role_id INT,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		UNIQUE (org_id, role_id, approver_user_id, approver_role_id),
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE,
+		FOREIGN KEY (approver_user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+		FOREIGN KEY (approver_role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)
+`
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RoleBinding grants a role to a user on a single resource, such as editor on project 42. The role applies
+// to the resource and every resource below it in the registered tree. A zero ExpiryDate means the binding
+// does not expire.
+type RoleBinding struct {
+	ID         int
+	UserID     int
+	RoleID     int
+	RoleName   string
+	Resource   ResourceRef
+	OrgID      int
+	ExpiryDate time.Time
+}
+
+// bindingQuery selects the columns scanned by queryBindings; callers append any further joins and the WHERE clause.
+const bindingQuery = `
+		SELECT b.binding_id, b.user_id, b.role_id, r.role_name, b.resource_type, b.resource_id, b.org_id, b.expiry_date
+		FROM role_bindings b
+		JOIN roles r ON b.role_id = r.role_id
+`
+
+// activeBinding restricts a query on role_bindings aliased as b to bindings that have not expired.
+const activeBinding = "(b.expiry_date IS NULL OR b.expiry_date > NOW())"
+
+// RoleBindingRepository stores role bindings. Its methods see the bindings of the organization the
+// repository is scoped to with ForOrg and global ones, and only modify bindings of that organization.
+type RoleBindingRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewRoleBindingRepository creates a new RoleBindingRepository with the given db instance
+func NewRoleBindingRepository(db *sql.DB) *RoleBindingRepository {
+	return &RoleBindingRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *RoleBindingRepository) ForOrg(orgID int) *RoleBindingRepository {
+	return &RoleBindingRepository{db: r.db, orgID: orgID}
+}
+
+// Create creates a new role binding in the database and sets its ID
+func (r *RoleBindingRepository) Create(binding *RoleBinding) error {
+	binding.OrgID = r.orgID
+	query := "INSERT INTO role_bindings (user_id, role_id, resource_type, resource_id, org_id, expiry_date, created_date) VALUES (?, ?, ?, ?, ?, ?, NOW())"
+	result, err := r.db.Exec(query, binding.UserID, binding.RoleID, binding.Resource.Type, binding.Resource.ID, binding.OrgID, nullTime(binding.ExpiryDate))
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	binding.ID = int(id)
+
+	return nil
+}
+
+// Get retrieves a role binding with the given ID from the database
+func (r *RoleBindingRepository) Get(id int) (*RoleBinding, error) {
+	query := bindingQuery + "WHERE b.binding_id = ? AND " + orgVisible("b")
+	bindings, err := r.queryBindings(query, id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bindings) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return bindings[0], nil
+}
+
+// Delete deletes a role binding with the given ID from the database
+func (r *RoleBindingRepository) Delete(id int) error {
+	query := "DELETE FROM role_bindings WHERE binding_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, id, r.orgID)
+	return err
+}
+
+// GetForUser retrieves the role bindings of a user, including expired ones
+func (r *RoleBindingRepository) GetForUser(userID int) ([]*RoleBinding, error) {
+	query := bindingQuery + "WHERE b.user_id = ? AND " + orgVisible("b")
+	return r.queryBindings(query, userID, r.orgID)
+}
+
+// GetForResource retrieves the role bindings made directly on a resource, including expired ones
+func (r *RoleBindingRepository) GetForResource(ref ResourceRef) ([]*RoleBinding, error) {
+	query := bindingQuery + "WHERE b.resource_type = ? AND b.resource_id = ? AND " + orgVisible("b")
+	return r.queryBindings(query, ref.Type, ref.ID, r.orgID)
+}
+
+// GetApplicable retrieves the active bindings of a user that apply to a resource: those made on the
+// resource itself and on the resources above it in the tree, nearest first
+func (r *RoleBindingRepository) GetApplicable(userID int, ref ResourceRef) ([]*RoleBinding, error) {
+	query := resourceAncestorsCTE + bindingQuery + `
+		JOIN resource_ancestors ra ON b.resource_type = ra.resource_type AND b.resource_id = ra.resource_id
+		WHERE b.user_id = ? AND ` + orgVisible("b") + " AND " + orgVisible("r") + " AND " + activeBinding + `
+		ORDER BY ra.depth, b.binding_id
+	`
+	return r.queryBindings(query, ref.Type, ref.ID, r.orgID, userID, r.orgID, r.orgID)
+}
+
+func (r *RoleBindingRepository) queryBindings(query string, args ...interface{}) ([]*RoleBinding, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	bindings := []*RoleBinding{}
+
+	for rows.Next() {
+		binding := &RoleBinding{}
+		var expiryDate sql.NullTime
+		err := rows.Scan(&binding.ID, &binding.UserID, &binding.RoleID, &binding.RoleName, &binding.Resource.Type, &binding.Resource.ID, &binding.OrgID, &expiryDate)
+		if err != nil {
+			return nil, err
+		}
+		binding.ExpiryDate = expiryDate.Time
+		bindings = append(bindings, binding)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bindings, nil
+}
+
+// CreateTable creates the 'role_bindings' table in the database.
+func (r *RoleBindingRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS role_bindings (
+		binding_id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		role_id INT NOT NULL,
+		resource_type VARCHAR(64) NOT NULL,
+		resource_id VARCHAR(255) NOT NULL,
+		org_id INT NOT NULL DEFAULT 0,
+		expiry_date DATETIME,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		UNIQUE (user_id, role_id, resource_type, resource_id, org_id),
+		INDEX (resource_type, resource_id),
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)
+`
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
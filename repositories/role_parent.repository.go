@@ -0,0 +1,173 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// RoleParent makes a role inherit every access of its parent role. Parent links form a DAG.
+type RoleParent struct {
+	RoleID       int
+	ParentRoleID int
+}
+
+// RoleParentRepository is a struct that handles all database operations related to RoleParent
+type RoleParentRepository struct {
+	db *sql.DB
+}
+
+// NewRoleParentRepository creates a new RoleParentRepository with the given db instance
+func NewRoleParentRepository(db *sql.DB) *RoleParentRepository {
+	return &RoleParentRepository{db}
+}
+
+// ErrRoleCycle is returned when adding a parent would make a role inherit from itself.
+var ErrRoleCycle = errors.New("role hierarchy must not contain cycles")
+
+// roleAncestorsQuery counts how often the second argument appears among the first argument and its ancestors
+const roleAncestorsQuery = `
+	WITH RECURSIVE ancestors (role_id) AS (
+		SELECT ?
+		UNION
+		SELECT rp.parent_role_id FROM role_parents rp INNER JOIN ancestors a ON rp.role_id = a.role_id
+	)
+	SELECT COUNT(*) FROM ancestors WHERE role_id = ?
+`
+
+// Create creates a new role parent link in the database, rejecting links that would create a cycle.
+// The link is not checked against separation of duties constraints, since it changes the roles of every
+// holder of the role in every organization; conflicts it introduces surface in SoDRepository.GetViolations.
+func (r *RoleParentRepository) Create(rp *RoleParent) error {
+	if rp.RoleID == rp.ParentRoleID {
+		return ErrRoleCycle
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the hierarchy so that concurrent writes cannot create a cycle together
+	_, err = tx.Exec("SELECT role_id FROM role_parents FOR UPDATE")
+	if err != nil {
+		return err
+	}
+
+	// Adding the link creates a cycle if the role is already an ancestor of the parent
+	var count int
+	err = tx.QueryRow(roleAncestorsQuery, rp.ParentRoleID, rp.RoleID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrRoleCycle
+	}
+
+	query := "INSERT INTO role_parents (role_id, parent_role_id, created_date) VALUES (?, ?, NOW())"
+	_, err = tx.Exec(query, rp.RoleID, rp.ParentRoleID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get retrieves a role parent link with the given role ID and parent role ID from the database
+func (r *RoleParentRepository) Get(roleID, parentRoleID int) (*RoleParent, error) {
+	query := "SELECT role_id, parent_role_id FROM role_parents WHERE role_id = ? AND parent_role_id = ?"
+	row := r.db.QueryRow(query, roleID, parentRoleID)
+	roleParent := &RoleParent{}
+	err := row.Scan(&roleParent.RoleID, &roleParent.ParentRoleID)
+	if err != nil {
+		return nil, err
+	}
+	return roleParent, nil
+}
+
+// Delete deletes a role parent link with the given role ID and parent role ID from the database
+func (r *RoleParentRepository) Delete(roleID, parentRoleID int) error {
+	query := "DELETE FROM role_parents WHERE role_id = ? AND parent_role_id = ?"
+	_, err := r.db.Exec(query, roleID, parentRoleID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetParents retrieves the direct parent roles of the role with the given ID from the database
+func (r *RoleParentRepository) GetParents(roleID int) ([]*Role, error) {
+	query := "SELECT r.role_id, r.role_name FROM roles r INNER JOIN role_parents rp ON r.role_id = rp.parent_role_id WHERE rp.role_id = ?"
+	rows, err := r.db.Query(query, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		role := &Role{}
+		err := rows.Scan(&role.ID, &role.Name)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetAll retrieves all role parent links from the database
+func (r *RoleParentRepository) GetAll() ([]*RoleParent, error) {
+	query := "SELECT role_id, parent_role_id FROM role_parents"
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roleParents := []*RoleParent{}
+
+	for rows.Next() {
+		roleParent := &RoleParent{}
+		err := rows.Scan(&roleParent.RoleID, &roleParent.ParentRoleID)
+		if err != nil {
+			return nil, err
+		}
+		roleParents = append(roleParents, roleParent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roleParents, nil
+}
+
+func (r *RoleParentRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS role_parents (
+		role_id INT NOT NULL,
+		parent_role_id INT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (role_id, parent_role_id),
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE,
+		FOREIGN KEY (parent_role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)
+`
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type Session struct {
+	ID               string
+	UserID           int
+	ClientID         string
+	OrgID            int
+	RefreshTokenHash string
+	AuthDate         time.Time
+	AMR              []string
+	CreatedDate      time.Time
+	LastActivityDate time.Time
+}
+
+// SessionRepository defines a struct for Session data storage and retrieval.
+type SessionRepository struct {
+	db *sql.DB
+}
+
+// NewSessionRepository creates a new SessionRepository instance using the provided database connection.
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// Create inserts a new Session record into the database.
+func (r *SessionRepository) Create(session *Session) error {
+	query := "INSERT INTO sessions (session_id, user_id, client_id, org_id, refresh_token_hash, auth_date, amr, created_date, last_activity_date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err := r.db.Exec(query, session.ID, session.UserID, session.ClientID, session.OrgID, session.RefreshTokenHash, session.AuthDate, strings.Join(session.AMR, " "), session.CreatedDate, session.LastActivityDate)
+	return err
+}
+
+// Get retrieves an active Session record from the database by ID.
+func (r *SessionRepository) Get(id string) (*Session, error) {
+	query := "SELECT session_id, user_id, client_id, org_id, refresh_token_hash, auth_date, amr, created_date, last_activity_date FROM sessions WHERE session_id = ? AND revoked_date IS NULL"
+	session, err := scanSession(r.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid session id")
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetByRefreshTokenHash retrieves an active Session record from the database by the hash of its refresh token.
+func (r *SessionRepository) GetByRefreshTokenHash(hash string) (*Session, error) {
+	query := "SELECT session_id, user_id, client_id, org_id, refresh_token_hash, auth_date, amr, created_date, last_activity_date FROM sessions WHERE refresh_token_hash = ? AND revoked_date IS NULL"
+	session, err := scanSession(r.db.QueryRow(query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid refresh token")
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// scanSession scans a Session record from a row selected with the columns used by Get.
+func scanSession(row *sql.Row) (*Session, error) {
+	session := &Session{}
+	var amr string
+	err := row.Scan(&session.ID, &session.UserID, &session.ClientID, &session.OrgID, &session.RefreshTokenHash, &session.AuthDate, &amr, &session.CreatedDate, &session.LastActivityDate)
+	if err != nil {
+		return nil, err
+	}
+	session.AMR = strings.Fields(amr)
+	return session, nil
+}
+
+// UpdateAuthentication records that the user of a Session authenticated again, without starting a new session.
+func (r *SessionRepository) UpdateAuthentication(session *Session) error {
+	query := "UPDATE sessions SET auth_date = ?, amr = ? WHERE session_id = ? AND revoked_date IS NULL"
+	result, err := r.db.Exec(query, session.AuthDate, strings.Join(session.AMR, " "), session.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("session has been revoked")
+	}
+
+	return nil
+}
+
+// Touch rotates the refresh token of a Session from previousHash to session.RefreshTokenHash and records
+// the refresh as session activity. It fails when the session has been revoked or its refresh token has
+// already been rotated, so that of two concurrent refreshes with the same token only one succeeds.
+func (r *SessionRepository) Touch(session *Session, previousHash string) error {
+	query := "UPDATE sessions SET refresh_token_hash = ?, last_activity_date = ? WHERE session_id = ? AND refresh_token_hash = ? AND revoked_date IS NULL"
+	result, err := r.db.Exec(query, session.RefreshTokenHash, session.LastActivityDate, session.ID, previousHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("invalid refresh token")
+	}
+
+	return nil
+}
+
+// Revoke marks a Session as revoked so its refresh token can no longer be used.
+func (r *SessionRepository) Revoke(id string) error {
+	query := "UPDATE sessions SET revoked_date = NOW() WHERE session_id = ?"
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+// MigrateAuthentication adds the auth_date and amr columns to a sessions table that predates them.
+// Existing sessions are taken to have authenticated when they were created, with unknown methods.
+func (r *SessionRepository) MigrateAuthentication() error {
+	exists, err := columnExists(r.db, "sessions", "auth_date")
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		queries := []string{
+			"ALTER TABLE sessions ADD COLUMN auth_date DATETIME NULL",
+			"UPDATE sessions SET auth_date = created_date WHERE auth_date IS NULL",
+			"ALTER TABLE sessions MODIFY COLUMN auth_date DATETIME NOT NULL",
+		}
+		for _, query := range queries {
+			_, err = r.db.Exec(query)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	exists, err = columnExists(r.db, "sessions", "amr")
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		_, err = r.db.Exec("ALTER TABLE sessions ADD COLUMN amr VARCHAR(255) NOT NULL DEFAULT ''")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// columnExists reports whether a column exists in a table of the current database.
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?"
+	err := db.QueryRow(query, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// CreateTable creates the 'sessions' table in the database.
+func (r *SessionRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		session_id VARCHAR(64) PRIMARY KEY,
+		user_id INT NOT NULL,
+		client_id VARCHAR(255) NOT NULL,
+		org_id INT NOT NULL DEFAULT 0,
+		refresh_token_hash VARCHAR(64) NOT NULL UNIQUE,
+		auth_date DATETIME NOT NULL,
+		amr VARCHAR(255) NOT NULL DEFAULT '',
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		last_activity_date DATETIME NOT NULL DEFAULT NOW(),
+		revoked_date DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	)
+	`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
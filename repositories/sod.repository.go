@@ -0,0 +1,404 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SoDConstraint is a static separation of duties constraint: a set of mutually exclusive roles, of
+// which a user may hold at most one. Roles count as held however the user holds them: directly,
+// through a group or by inheritance, including assignments that have not started yet or depend on a
+// condition. A constraint with OrgID GlobalOrgID applies in every organization.
+type SoDConstraint struct {
+	ID      int
+	OrgID   int
+	Name    string
+	RoleIDs []int
+}
+
+// SoDViolation describes a user holding more than one role of a constraint.
+type SoDViolation struct {
+	ConstraintID   int
+	ConstraintName string
+	UserID         int
+	UserName       string
+	Roles          []string
+}
+
+// ErrSoDViolation is returned when an assignment would make a user hold mutually exclusive roles.
+var ErrSoDViolation = errors.New("assignment violates a separation of duties constraint")
+
+// heldRolesCTE defines held_roles: the roles users hold in an organization for separation of duties,
+// i.e. every assignment that has not expired, direct or through groups, and the roles they inherit. In
+// the global scope it is the union of the roles held in every organization, since a global assignment or
+// group reaches them all. Its arguments are returned by heldRolesArgs.
+var heldRolesCTE = `
+		WITH RECURSIVE member_groups (user_id, group_id) AS (
+			SELECT gm.user_id, gm.group_id FROM group_members gm JOIN user_groups g ON gm.group_id = g.group_id WHERE (? = 0 OR gm.user_id = ?) AND ` + orgScope("g") + `
+			UNION
+			SELECT mg.user_id, gn.group_id FROM group_nesting gn JOIN member_groups mg ON gn.member_group_id = mg.group_id JOIN user_groups g ON gn.group_id = g.group_id WHERE ` + orgScope("g") + `
+		),
+		held_roles (user_id, role_id) AS (
+			SELECT ur.user_id, ur.role_id FROM user_roles ur WHERE (? = 0 OR ur.user_id = ?) AND ` + orgScope("ur") + ` AND (ur.expiry_date IS NULL OR ur.expiry_date > NOW())
+			UNION
+			SELECT mg.user_id, gr.role_id FROM group_roles gr JOIN member_groups mg ON gr.group_id = mg.group_id WHERE ` + activeGroupRole + `
+			UNION
+			SELECT hr.user_id, rp.parent_role_id FROM role_parents rp JOIN held_roles hr ON rp.role_id = hr.role_id
+		)
+`
+
+// heldRolesArgs returns the arguments of heldRolesCTE for a user, or for all users if userID is 0, in an organization.
+func heldRolesArgs(userID, orgID int) []interface{} {
+	return []interface{}{userID, userID, orgID, orgID, orgID, orgID, userID, userID, orgID, orgID}
+}
+
+// checkSoD returns ErrSoDViolation, naming the constraint and roles, if holding roleID in addition to the
+// roles userID already holds in orgID would violate a constraint. In the global scope the roles held in every
+// organization are checked against the constraints of every organization. Violations among the roles
+// already held are left to the violations report, so that they do not block unrelated assignments.
+func checkSoD(tx *sql.Tx, userID, roleID, orgID int) error {
+	query := heldRolesCTE + `,
+		new_roles (role_id) AS (
+			SELECT ?
+			UNION
+			SELECT rp.parent_role_id FROM role_parents rp JOIN new_roles nr ON rp.role_id = nr.role_id
+		),
+		user_roles_after (role_id) AS (
+			SELECT role_id FROM held_roles WHERE user_id = ?
+			UNION
+			SELECT role_id FROM new_roles
+		)
+		SELECT c.constraint_name, rn.role_name, rh.role_name
+		FROM sod_constraints c
+		JOIN sod_constraint_roles cn ON c.constraint_id = cn.constraint_id
+		JOIN new_roles nr ON cn.role_id = nr.role_id
+		JOIN sod_constraint_roles ch ON c.constraint_id = ch.constraint_id AND ch.role_id <> cn.role_id
+		JOIN user_roles_after ura ON ch.role_id = ura.role_id
+		JOIN roles rn ON cn.role_id = rn.role_id
+		JOIN roles rh ON ch.role_id = rh.role_id
+		WHERE ` + orgScope("c") + `
+		LIMIT 1
+	`
+	var constraint, newRole, heldRole string
+	args := append(heldRolesArgs(userID, orgID), roleID, userID, orgID, orgID)
+	err := tx.QueryRow(query, args...).Scan(&constraint, &newRole, &heldRole)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w %s: %s conflicts with %s", ErrSoDViolation, constraint, newRole, heldRole)
+}
+
+// heldRoleSets returns the roles each of userIDs holds in orgID for separation of duties, as seen within tx.
+// The users are locked so that concurrent writes cannot violate a constraint together.
+func heldRoleSets(tx *sql.Tx, userIDs []int, orgID int) (map[int]map[int]bool, error) {
+	held := map[int]map[int]bool{}
+	for _, userID := range userIDs {
+		_, err := tx.Exec("SELECT user_id FROM users WHERE user_id = ? FOR UPDATE", userID)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := tx.Query(heldRolesCTE+"SELECT DISTINCT role_id FROM held_roles WHERE user_id = ?", append(heldRolesArgs(userID, orgID), userID)...)
+		if err != nil {
+			return nil, err
+		}
+
+		roles := map[int]bool{}
+		for rows.Next() {
+			var roleID int
+			err := rows.Scan(&roleID)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			roles[roleID] = true
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		held[userID] = roles
+	}
+	return held, nil
+}
+
+// checkSoDGained returns ErrSoDViolation if a write made within tx gave one of the users in before a role
+// conflicting with another role they now hold in orgID, or in any organization in the global scope, as
+// with checkSoD. before holds the roles of the users prior to the
+// write, as returned by heldRoleSets; as with checkSoD, violations among those roles are left to the
+// violations report.
+func checkSoDGained(tx *sql.Tx, userIDs []int, before map[int]map[int]bool, orgID int) error {
+	query := `
+		SELECT c.constraint_id, c.constraint_name, cr.role_id, r.role_name
+		FROM sod_constraints c
+		JOIN sod_constraint_roles cr ON c.constraint_id = cr.constraint_id
+		JOIN roles r ON cr.role_id = r.role_id
+		WHERE ` + orgScope("c") + `
+		ORDER BY c.constraint_id, cr.role_id
+	`
+	rows, err := tx.Query(query, orgID, orgID)
+	if err != nil {
+		return err
+	}
+
+	type constraintRole struct {
+		constraintID int
+		constraint   string
+		roleID       int
+		roleName     string
+	}
+
+	roles := []constraintRole{}
+	for rows.Next() {
+		var cr constraintRole
+		err := rows.Scan(&cr.constraintID, &cr.constraint, &cr.roleID, &cr.roleName)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		roles = append(roles, cr)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+
+	after, err := heldRoleSets(tx, userIDs, orgID)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		for _, gained := range roles {
+			if !after[userID][gained.roleID] || before[userID][gained.roleID] {
+				continue
+			}
+			for _, held := range roles {
+				if held.constraintID == gained.constraintID && held.roleID != gained.roleID && after[userID][held.roleID] {
+					return fmt.Errorf("%w %s: %s conflicts with %s", ErrSoDViolation, gained.constraint, gained.roleName, held.roleName)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SoDRepository stores separation of duties constraints. It sees the constraints of the organization it
+// is scoped to with ForOrg and global ones, and only modifies constraints of that organization.
+type SoDRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewSoDRepository creates a new SoDRepository with the given db instance
+func NewSoDRepository(db *sql.DB) *SoDRepository {
+	return &SoDRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *SoDRepository) ForOrg(orgID int) *SoDRepository {
+	return &SoDRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts a new constraint with its roles. Existing assignments are not checked; use
+// GetViolations to find the users the constraint is violated by.
+func (r *SoDRepository) Create(c *SoDConstraint) error {
+	c.OrgID = r.orgID
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO sod_constraints (org_id, constraint_name, created_date) VALUES (?, ?, NOW())"
+	result, err := tx.Exec(query, c.OrgID, c.Name)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.ID = int(id)
+
+	for _, roleID := range c.RoleIDs {
+		_, err = tx.Exec("INSERT INTO sod_constraint_roles (constraint_id, role_id) VALUES (?, ?)", c.ID, roleID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get retrieves a constraint by ID. Returns sql.ErrNoRows if it does not exist or is not visible.
+func (r *SoDRepository) Get(id int) (*SoDConstraint, error) {
+	constraints, err := r.query("WHERE c.constraint_id = ? AND "+orgVisible("c"), id, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(constraints) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return constraints[0], nil
+}
+
+// GetAll retrieves all visible constraints.
+func (r *SoDRepository) GetAll() ([]*SoDConstraint, error) {
+	return r.query("WHERE "+orgVisible("c"), r.orgID)
+}
+
+// query retrieves the constraints matching a WHERE clause on sod_constraints aliased as c, with their roles.
+func (r *SoDRepository) query(where string, args ...interface{}) ([]*SoDConstraint, error) {
+	query := `
+		SELECT c.constraint_id, c.org_id, c.constraint_name, cr.role_id
+		FROM sod_constraints c
+		JOIN sod_constraint_roles cr ON c.constraint_id = cr.constraint_id
+		` + where + `
+		ORDER BY c.constraint_id, cr.role_id
+	`
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	constraints := []*SoDConstraint{}
+	var current *SoDConstraint
+
+	for rows.Next() {
+		c := &SoDConstraint{}
+		var roleID int
+		err := rows.Scan(&c.ID, &c.OrgID, &c.Name, &roleID)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || current.ID != c.ID {
+			current = c
+			constraints = append(constraints, c)
+		}
+		current.RoleIDs = append(current.RoleIDs, roleID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return constraints, nil
+}
+
+// Delete removes a constraint of the organization.
+func (r *SoDRepository) Delete(id int) error {
+	result, err := r.db.Exec("DELETE FROM sod_constraints WHERE constraint_id = ? AND org_id = ?", id, r.orgID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("constraint not found")
+	}
+
+	return nil
+}
+
+// GetViolations lists the users holding more than one role of a visible constraint, including violations
+// that existed before the constraint was declared. Within an organization, only its members are listed; in
+// the global scope, roles held in different organizations count together, as for writes.
+func (r *SoDRepository) GetViolations() ([]*SoDViolation, error) {
+	query := heldRolesCTE + `
+		SELECT c.constraint_id, c.constraint_name, u.user_id, u.user_name, ro.role_name
+		FROM sod_constraints c
+		JOIN sod_constraint_roles cr ON c.constraint_id = cr.constraint_id
+		JOIN held_roles hr ON cr.role_id = hr.role_id
+		JOIN users u ON hr.user_id = u.user_id
+		JOIN roles ro ON cr.role_id = ro.role_id
+		WHERE ` + orgVisible("c") + `
+		AND (? = 0 OR hr.user_id IN (SELECT om.user_id FROM org_members om WHERE om.org_id = ?))
+		AND EXISTS (
+			SELECT 1 FROM sod_constraint_roles other JOIN held_roles ho ON other.role_id = ho.role_id
+			WHERE other.constraint_id = c.constraint_id AND other.role_id <> cr.role_id AND ho.user_id = hr.user_id
+		)
+		ORDER BY c.constraint_id, u.user_id, ro.role_name
+	`
+	args := append(heldRolesArgs(0, r.orgID), r.orgID, r.orgID, r.orgID)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	violations := []*SoDViolation{}
+	var current *SoDViolation
+
+	for rows.Next() {
+		v := &SoDViolation{}
+		var roleName string
+		err := rows.Scan(&v.ConstraintID, &v.ConstraintName, &v.UserID, &v.UserName, &roleName)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || current.ConstraintID != v.ConstraintID || current.UserID != v.UserID {
+			current = v
+			violations = append(violations, v)
+		}
+		current.Roles = append(current.Roles, roleName)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return violations, nil
+}
+
+// CreateTable creates the separation of duties tables in the database.
+func (r *SoDRepository) CreateTable() error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS sod_constraints (
+		constraint_id INT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		constraint_name VARCHAR(255) NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		UNIQUE (org_id, constraint_name)
+	)`, `
+	CREATE TABLE IF NOT EXISTS sod_constraint_roles (
+		constraint_id INT NOT NULL,
+		role_id INT NOT NULL,
+		PRIMARY KEY (constraint_id, role_id),
+		FOREIGN KEY (constraint_id) REFERENCES sod_constraints(constraint_id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+	)`}
+
+	for _, query := range queries {
+		_, err := r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
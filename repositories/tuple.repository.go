@@ -0,0 +1,281 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/princeparmar/contact_manager/rebac"
+)
+
+// liveAt restricts a query on relation_tuples to the tuples that existed at a revision. It takes the
+// revision twice.
+const liveAt = "created_rev <= ? AND (deleted_rev IS NULL OR deleted_rev > ?)"
+
+// tupleColumns lists the relation_tuples columns scanned by Read
+const tupleColumns = "namespace, object_id, relation, subject_namespace, subject_id, subject_relation"
+
+// TupleRepository stores relationship tuples. Tuples are never updated in place: every write creates a
+// new revision of the store, and deleted tuples are kept with the revision that deleted them, so that
+// reads at a revision see a consistent snapshot. Tuples belong to the organization the repository is
+// scoped to with ForOrg and are not shared with other organizations.
+type TupleRepository struct {
+	db    *sql.DB
+	orgID int
+}
+
+// NewTupleRepository creates a new TupleRepository with the given db instance
+func NewTupleRepository(db *sql.DB) *TupleRepository {
+	return &TupleRepository{db: db}
+}
+
+// ForOrg returns a copy of the repository scoped to an organization.
+func (r *TupleRepository) ForOrg(orgID int) *TupleRepository {
+	return &TupleRepository{db: r.db, orgID: orgID}
+}
+
+// Write adds and removes tuples in a single new revision of the store and returns the revision. Adding a
+// tuple that exists and removing one that does not are no-ops.
+func (r *TupleRepository) Write(writes, deletes []rebac.Tuple) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Locking the revision row serialises writers, so revisions commit in order
+	_, err = tx.Exec("UPDATE tuple_revision SET revision = LAST_INSERT_ID(revision + 1) WHERE id = 1")
+	if err != nil {
+		return 0, err
+	}
+
+	var revision int64
+	err = tx.QueryRow("SELECT LAST_INSERT_ID()").Scan(&revision)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, t := range deletes {
+		query := `
+			UPDATE relation_tuples SET deleted_rev = ?
+			WHERE org_id = ? AND namespace = ? AND object_id = ? AND relation = ? AND subject_namespace = ? AND subject_id = ? AND subject_relation = ? AND deleted_rev IS NULL
+		`
+		_, err = tx.Exec(query, revision, r.orgID, t.Object.Namespace, t.Object.ID, t.Relation, t.Subject.Namespace, t.Subject.ID, t.Subject.Relation)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, t := range writes {
+		var count int
+		query := `
+			SELECT COUNT(*) FROM relation_tuples
+			WHERE org_id = ? AND namespace = ? AND object_id = ? AND relation = ? AND subject_namespace = ? AND subject_id = ? AND subject_relation = ? AND deleted_rev IS NULL
+		`
+		err = tx.QueryRow(query, r.orgID, t.Object.Namespace, t.Object.ID, t.Relation, t.Subject.Namespace, t.Subject.ID, t.Subject.Relation).Scan(&count)
+		if err != nil {
+			return 0, err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		query = "INSERT INTO relation_tuples (org_id, " + tupleColumns + ", created_rev) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+		_, err = tx.Exec(query, r.orgID, t.Object.Namespace, t.Object.ID, t.Relation, t.Subject.Namespace, t.Subject.ID, t.Subject.Relation, revision)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return revision, tx.Commit()
+}
+
+// Revision returns the latest revision of the store.
+func (r *TupleRepository) Revision() (int64, error) {
+	var revision int64
+	err := r.db.QueryRow("SELECT revision FROM tuple_revision WHERE id = 1").Scan(&revision)
+	return revision, err
+}
+
+// GetSubjects retrieves the subjects related to an object by a relation at a revision, as written in tuples.
+func (r *TupleRepository) GetSubjects(object rebac.Object, relation string, revision int64) ([]rebac.Subject, error) {
+	tuples, err := r.Read(object, relation, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make([]rebac.Subject, 0, len(tuples))
+	for _, t := range tuples {
+		subjects = append(subjects, t.Subject)
+	}
+	return subjects, nil
+}
+
+// Read retrieves the tuples of an object at a revision, restricted to a relation unless it is empty.
+func (r *TupleRepository) Read(object rebac.Object, relation string, revision int64) ([]rebac.Tuple, error) {
+	query := "SELECT " + tupleColumns + " FROM relation_tuples WHERE org_id = ? AND namespace = ? AND object_id = ? AND (? = '' OR relation = ?) AND " + liveAt + " ORDER BY relation, tuple_id"
+	rows, err := r.db.Query(query, r.orgID, object.Namespace, object.ID, relation, relation, revision, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tuples := []rebac.Tuple{}
+
+	for rows.Next() {
+		t := rebac.Tuple{}
+		err := rows.Scan(&t.Object.Namespace, &t.Object.ID, &t.Relation, &t.Subject.Namespace, &t.Subject.ID, &t.Subject.Relation)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tuples, nil
+}
+
+// CreateTable creates the tuple store tables in the database.
+func (r *TupleRepository) CreateTable() error {
+	queries := []string{`
+	CREATE TABLE IF NOT EXISTS relation_tuples (
+		tuple_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		org_id INT NOT NULL DEFAULT 0,
+		namespace VARCHAR(64) NOT NULL,
+		object_id VARCHAR(255) NOT NULL,
+		relation VARCHAR(64) NOT NULL,
+		subject_namespace VARCHAR(64) NOT NULL,
+		subject_id VARCHAR(255) NOT NULL,
+		subject_relation VARCHAR(64) NOT NULL DEFAULT '',
+		created_rev BIGINT NOT NULL,
+		deleted_rev BIGINT,
+		INDEX (org_id, namespace, object_id, relation)
+	)`, `
+	CREATE TABLE IF NOT EXISTS tuple_revision (
+		id TINYINT PRIMARY KEY,
+		revision BIGINT NOT NULL
+	)`,
+		"INSERT IGNORE INTO tuple_revision (id, revision) VALUES (1, 0)",
+	}
+
+	for _, query := range queries {
+		_, err := r.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NamespaceRepository stores namespace configurations. Namespaces are shared by all organizations.
+type NamespaceRepository struct {
+	db *sql.DB
+}
+
+// NewNamespaceRepository creates a new NamespaceRepository with the given db instance
+func NewNamespaceRepository(db *sql.DB) *NamespaceRepository {
+	return &NamespaceRepository{db: db}
+}
+
+// Save creates or replaces a namespace configuration.
+func (r *NamespaceRepository) Save(config *rebac.NamespaceConfig) error {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO relation_namespaces (namespace, config, created_date, updated_date) VALUES (?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE config = VALUES(config), updated_date = NOW()
+	`
+	_, err = r.db.Exec(query, config.Name, string(encoded))
+	return err
+}
+
+// Get retrieves a namespace configuration. Returns sql.ErrNoRows if the namespace is not configured.
+func (r *NamespaceRepository) Get(name string) (*rebac.NamespaceConfig, error) {
+	var encoded string
+	err := r.db.QueryRow("SELECT config FROM relation_namespaces WHERE namespace = ?", name).Scan(&encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &rebac.NamespaceConfig{}
+	return config, json.Unmarshal([]byte(encoded), config)
+}
+
+// GetAll retrieves all namespace configurations by name.
+func (r *NamespaceRepository) GetAll() (map[string]*rebac.NamespaceConfig, error) {
+	rows, err := r.db.Query("SELECT config FROM relation_namespaces")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	configs := map[string]*rebac.NamespaceConfig{}
+
+	for rows.Next() {
+		var encoded string
+		err := rows.Scan(&encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		config := &rebac.NamespaceConfig{}
+		err = json.Unmarshal([]byte(encoded), config)
+		if err != nil {
+			return nil, err
+		}
+		configs[config.Name] = config
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// Delete removes a namespace configuration. Tuples of the namespace are kept but can no longer be checked.
+func (r *NamespaceRepository) Delete(name string) error {
+	result, err := r.db.Exec("DELETE FROM relation_namespaces WHERE namespace = ?", name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("namespace not found")
+	}
+
+	return nil
+}
+
+// CreateTable creates the 'relation_namespaces' table in the database.
+func (r *NamespaceRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS relation_namespaces (
+		namespace VARCHAR(64) PRIMARY KEY,
+		config TEXT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		updated_date DATETIME NOT NULL DEFAULT NOW()
+	)
+`
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
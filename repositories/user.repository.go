@@ -14,7 +14,8 @@ type User struct {
 
 // UserRepository defines a struct for User data storage and retrieval.
 type UserRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	orgID int
 }
 
 // NewUserRepository creates a new UserRepository instance using the provided database connection.
@@ -22,10 +23,23 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-// Create inserts a new User record into the database.
+// ForOrg returns a copy of the repository scoped to an organization. The copy only reads and modifies
+// the members of the organization; users are looked up by name and password regardless of scope so
+// that they can log in to any organization they belong to.
+func (r *UserRepository) ForOrg(orgID int) *UserRepository {
+	return &UserRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts a new User record into the database. A user created in an organization becomes its member.
 func (r *UserRepository) Create(user *User) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := "INSERT INTO users (user_name, mobile, created_date, updated_date, email_id) VALUES (?, ?, NOW(), NOW(), ?)"
-	result, err := r.db.Exec(query, user.UserName, user.Mobile, user.EmailID)
+	result, err := tx.Exec(query, user.UserName, user.Mobile, user.EmailID)
 	if err != nil {
 		return err
 	}
@@ -35,6 +49,19 @@ func (r *UserRepository) Create(user *User) error {
 		return err
 	}
 
+	if r.orgID != GlobalOrgID {
+		query = "INSERT INTO org_members (org_id, user_id, created_date) VALUES (?, ?, NOW())"
+		_, err = tx.Exec(query, r.orgID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
 	user.ID = int(id)
 
 	return nil
@@ -42,8 +69,8 @@ func (r *UserRepository) Create(user *User) error {
 
 // Get retrieves a User record from the database by ID.
 func (r *UserRepository) Get(id int) (*User, error) {
-	query := "SELECT user_id, user_name, mobile, email_id FROM users WHERE user_id = ?"
-	row := r.db.QueryRow(query, id)
+	query := "SELECT user_id, user_name, mobile, email_id FROM users WHERE user_id = ? AND " + userInOrg
+	row := r.db.QueryRow(query, id, r.orgID, r.orgID)
 	user := &User{}
 	err := row.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
 	if err != nil {
@@ -56,8 +83,8 @@ func (r *UserRepository) Get(id int) (*User, error) {
 }
 
 func (r *UserRepository) GetAll() ([]*User, error) {
-	query := "SELECT user_id, user_name, email_id, mobile FROM user"
-	rows, err := r.db.Query(query)
+	query := "SELECT user_id, user_name, email_id, mobile FROM users WHERE " + userInOrg
+	rows, err := r.db.Query(query, r.orgID, r.orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -82,10 +109,26 @@ func (r *UserRepository) GetAll() ([]*User, error) {
 	return users, nil
 }
 
-// Update updates an existing User record in the database.
+// ErrSharedUser is returned when a user that also belongs to other organizations is modified within one of them.
+var ErrSharedUser = errors.New("user belongs to other organizations and can only be modified globally")
+
+// Update updates an existing User record in the database. Within an organization, only users that belong
+// to no other organization can be updated, since the record is shared between them.
 func (r *UserRepository) Update(user *User) error {
-	query := "UPDATE users SET user_name = ?, mobile = ?, updated_date = NOW(), email_id = ? WHERE user_id = ?"
-	result, err := r.db.Exec(query, user.UserName, user.Mobile, user.EmailID, user.ID)
+	if r.orgID != GlobalOrgID {
+		var others int
+		query := "SELECT COUNT(*) FROM org_members WHERE user_id = ? AND org_id <> ?"
+		err := r.db.QueryRow(query, user.ID, r.orgID).Scan(&others)
+		if err != nil {
+			return err
+		}
+		if others > 0 {
+			return ErrSharedUser
+		}
+	}
+
+	query := "UPDATE users SET user_name = ?, mobile = ?, updated_date = NOW(), email_id = ? WHERE user_id = ? AND " + userInOrg
+	result, err := r.db.Exec(query, user.UserName, user.Mobile, user.EmailID, user.ID, r.orgID, r.orgID)
 	if err != nil {
 		return err
 	}
@@ -102,8 +145,22 @@ func (r *UserRepository) Update(user *User) error {
 	return nil
 }
 
-// Delete removes a User record from the database by ID.
+// Delete removes a User record from the database by ID. Within an organization, the user only leaves it,
+// together with the roles and role bindings held in it, as with OrgRepository.RemoveMember; the record
+// itself can only be deleted globally.
 func (r *UserRepository) Delete(id int) error {
+	if r.orgID != GlobalOrgID {
+		orgRepo := NewOrgRepository(r.db)
+		member, err := orgRepo.IsMember(r.orgID, id)
+		if err != nil {
+			return err
+		}
+		if !member {
+			return errors.New("no rows were affected during the delete")
+		}
+		return orgRepo.RemoveMember(r.orgID, id)
+	}
+
 	query := "DELETE FROM users WHERE user_id = ?"
 	result, err := r.db.Exec(query, id)
 	if err != nil {
@@ -124,8 +181,8 @@ func (r *UserRepository) Delete(id int) error {
 
 // List retrieves a list of all User records from the database.
 func (r *UserRepository) List() ([]*User, error) {
-	query := "SELECT user_id, user_name, mobile, email_id FROM users"
-	rows, err := r.db.Query(query)
+	query := "SELECT user_id, user_name, mobile, email_id FROM users WHERE " + userInOrg
+	rows, err := r.db.Query(query, r.orgID, r.orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -200,7 +257,59 @@ func (r *UserRepository) UpdatePassword(userID int, password string) error {
 	return nil
 }
 
-// CreateTable creates the 'users' table in the database.
+// SetManager records managerID as the manager of a member of the organization, or removes the manager
+// of the user when managerID is zero.
+func (r *UserRepository) SetManager(userID, managerID int) error {
+	user, err := r.Get(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	if managerID == 0 {
+		_, err = r.db.Exec("DELETE FROM user_managers WHERE user_id = ?", userID)
+		return err
+	}
+
+	query := `
+		INSERT INTO user_managers (user_id, manager_id, updated_date) VALUES (?, ?, NOW())
+		ON DUPLICATE KEY UPDATE manager_id = VALUES(manager_id), updated_date = NOW()
+	`
+	_, err = r.db.Exec(query, userID, managerID)
+	return err
+}
+
+// GetReports retrieves the members of the organization whose manager is managerID.
+func (r *UserRepository) GetReports(managerID int) ([]*User, error) {
+	query := "SELECT user_id, user_name, mobile, email_id FROM users WHERE user_id IN (SELECT um.user_id FROM user_managers um WHERE um.manager_id = ?) AND " + userInOrg
+	rows, err := r.db.Query(query, managerID, r.orgID, r.orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// CreateTable creates the 'users' and 'user_managers' tables in the database.
 func (ur *UserRepository) CreateTable() error {
 	query := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -219,5 +328,20 @@ func (ur *UserRepository) CreateTable() error {
 		return err
 	}
 
+	query = `
+	CREATE TABLE IF NOT EXISTS user_managers (
+		user_id INT PRIMARY KEY,
+		manager_id INT NOT NULL,
+		updated_date DATETIME NOT NULL DEFAULT NOW(),
+		INDEX (manager_id),
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+		FOREIGN KEY (manager_id) REFERENCES users(user_id) ON DELETE CASCADE
+	)
+	`
+	_, err = ur.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// UserAttributeRepository stores the attributes of users that conditional grants are evaluated against,
+// such as department or spending limit. Values are stored as JSON so that numbers, strings, booleans and
+// lists keep their type.
+type UserAttributeRepository struct {
+	db *sql.DB
+}
+
+// NewUserAttributeRepository creates a new UserAttributeRepository with the given db instance
+func NewUserAttributeRepository(db *sql.DB) *UserAttributeRepository {
+	return &UserAttributeRepository{db: db}
+}
+
+// Set sets an attribute of a user, replacing any previous value
+func (r *UserAttributeRepository) Set(userID int, name string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO user_attributes (user_id, attr_name, attr_value, created_date, updated_date)
+		VALUES (?, ?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE attr_value = VALUES(attr_value), updated_date = NOW()
+	`
+	_, err = r.db.Exec(query, userID, name, string(encoded))
+	return err
+}
+
+// Delete removes an attribute of a user
+func (r *UserAttributeRepository) Delete(userID int, name string) error {
+	query := "DELETE FROM user_attributes WHERE user_id = ? AND attr_name = ?"
+	_, err := r.db.Exec(query, userID, name)
+	return err
+}
+
+// GetForUser retrieves the attributes of a user by name
+func (r *UserAttributeRepository) GetForUser(userID int) (map[string]interface{}, error) {
+	query := "SELECT attr_name, attr_value FROM user_attributes WHERE user_id = ?"
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	attributes := map[string]interface{}{}
+
+	for rows.Next() {
+		var name, encoded string
+		err := rows.Scan(&name, &encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		err = json.Unmarshal([]byte(encoded), &value)
+		if err != nil {
+			return nil, err
+		}
+		attributes[name] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+// CreateTable creates the 'user_attributes' table in the database.
+func (r *UserAttributeRepository) CreateTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS user_attributes (
+		user_id INT NOT NULL,
+		attr_name VARCHAR(100) NOT NULL,
+		attr_value TEXT NOT NULL,
+		created_date DATETIME NOT NULL DEFAULT NOW(),
+		updated_date DATETIME NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, attr_name),
+		FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	)
+`
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
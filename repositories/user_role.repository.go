@@ -3,62 +3,226 @@ package repositories
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 )
 
+// UserRole assigns a role to a user within an organization. An assignment in GlobalOrgID applies in
+// every organization. A zero StartDate means the assignment is effective immediately and a zero
+// ExpiryDate means it does not expire. An assignment with a Condition only holds when the condition
+// is satisfied by the attributes of the request and the user; see package condition.
 type UserRole struct {
 	UserID     int
 	RoleID     int
+	OrgID      int
+	StartDate  time.Time
 	ExpiryDate time.Time
+	Condition  string
 }
 
+// RoleAssignment describes a role held by a user, with the names of both sides.
+type RoleAssignment struct {
+	UserID     int
+	UserName   string
+	RoleID     int
+	RoleName   string
+	OrgID      int
+	StartDate  time.Time
+	ExpiryDate time.Time
+	Condition  string
+}
+
+// Active reports whether the assignment has started and not expired at the given time.
+func (a *RoleAssignment) Active(now time.Time) bool {
+	return (a.StartDate.IsZero() || !a.StartDate.After(now)) && (a.ExpiryDate.IsZero() || a.ExpiryDate.After(now))
+}
+
+// HolderQuery selects a page of the users holding any of RoleIDs directly, unconditionally and unexpired,
+// or belonging to any of GroupIDs, except the users in ExcludedUserIDs, holding any of ExcludedRoleIDs
+// or belonging to any of ExcludedGroupIDs.
+type HolderQuery struct {
+	RoleIDs          []int
+	GroupIDs         []int
+	ExcludedUserIDs  []int
+	ExcludedRoleIDs  []int
+	ExcludedGroupIDs []int
+	Offset           int
+	Limit            int
+}
+
+// ErrNoAccess is returned by GetAllAccess when the user holds no access.
+var ErrNoAccess = errors.New("no access found for the user")
+
+// activeUserRole restricts a query on user_roles aliased as ur to assignments that have started and not expired.
+const activeUserRole = "(ur.start_date IS NULL OR ur.start_date <= NOW()) AND (ur.expiry_date IS NULL OR ur.expiry_date > NOW())"
+
+// activeGroupRole restricts a query on group_roles aliased as gr to assignments that have not expired.
+const activeGroupRole = "(gr.expiry_date IS NULL OR gr.expiry_date > NOW())"
+
+// effectiveRolesCTE defines effective_roles: the roles a user holds unconditionally in an organization
+// directly, through the groups the user belongs to (including containing groups) and through role
+// inheritance. Its arguments are returned by effectiveRolesArgs.
+const effectiveRolesCTE = `
+		WITH RECURSIVE member_groups (group_id) AS (
+			SELECT gm.group_id FROM group_members gm JOIN user_groups g ON gm.group_id = g.group_id WHERE gm.user_id = ? AND g.org_id IN (0, ?)
+			UNION
+			SELECT gn.group_id FROM group_nesting gn JOIN member_groups mg ON gn.member_group_id = mg.group_id JOIN user_groups g ON gn.group_id = g.group_id WHERE g.org_id IN (0, ?)
+		),
+		effective_roles (role_id) AS (
+			SELECT ur.role_id FROM user_roles ur WHERE ur.user_id = ? AND ur.org_id IN (0, ?) AND ur.condition_expr IS NULL AND ` + activeUserRole + `
+			UNION
+			SELECT gr.role_id FROM group_roles gr JOIN member_groups mg ON gr.group_id = mg.group_id WHERE ` + activeGroupRole + `
+			UNION
+			SELECT rp.parent_role_id FROM role_parents rp JOIN effective_roles er ON rp.role_id = er.role_id
+		)
+`
+
+// effectiveRolesArgs returns the arguments of effectiveRolesCTE for a user in an organization.
+func effectiveRolesArgs(userID, orgID int) []interface{} {
+	return []interface{}{userID, orgID, orgID, userID, orgID}
+}
+
+// assignmentQuery selects the columns scanned by queryAssignments; callers append the WHERE clause.
+const assignmentQuery = `
+		SELECT ur.user_id, u.user_name, ur.role_id, r.role_name, ur.org_id, ur.start_date, ur.expiry_date, ur.condition_expr
+		FROM user_roles ur
+		JOIN users u ON ur.user_id = u.user_id
+		JOIN roles r ON ur.role_id = r.role_id
+`
+
+// UserRoleRepository stores role assignments. Unless stated otherwise, its methods only see the
+// assignments of the organization the repository is scoped to with ForOrg, plus global assignments
+// when reading, and only modify assignments of that organization.
 type UserRoleRepository interface {
+	ForOrg(orgID int) UserRoleRepository
 	Create(*UserRole) error
+	CreateAll([]*UserRole) error
 	Get(int, int) (*UserRole, error)
 	Update(*UserRole) error
 	Delete(int, int) error
 	GetAll() ([]*UserRole, error)
 	GetRolesForUser(int) ([]*Role, error)
 	GetAllAccess(userID int) ([]*Access, error)
+	GetAssignmentsForUser(userID int) ([]*RoleAssignment, error)
+	GetAssignmentsForRole(roleID int) ([]*RoleAssignment, error)
+	GetHolders(q *HolderQuery) ([]*User, int, error)
+	GetUpcomingForUser(userID int) ([]*RoleAssignment, error)
+	GetUpcomingForRole(roleID int) ([]*RoleAssignment, error)
+	GetExpiringAssignments(before time.Time) ([]*RoleAssignment, error)
+	MarkExpiryWarned(*RoleAssignment) error
+	ArchiveExpired() (int64, error)
 }
 
 type userRoleRepository struct {
-	db *sql.DB
+	db    *sql.DB
+	orgID int
 }
 
 func NewUserRoleRepository(db *sql.DB) UserRoleRepository {
 	return &userRoleRepository{db: db}
 }
 
+func (r *userRoleRepository) ForOrg(orgID int) UserRoleRepository {
+	return &userRoleRepository{db: r.db, orgID: orgID}
+}
+
+// Create inserts an assignment, rejecting with ErrSoDViolation one that would make the user hold
+// mutually exclusive roles; see SoDConstraint.
 func (r *userRoleRepository) Create(ur *UserRole) error {
-	query := "INSERT INTO user_roles (user_id, role_id, expiry_date, created_date, updated_date) VALUES (?, ?, ?, NOW(), NOW())"
-	result, err := r.db.Exec(query, ur.UserID, ur.RoleID, ur.ExpiryDate)
+	ur.OrgID = r.orgID
+
+	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = result.LastInsertId()
+	err = insertUserRole(tx, ur)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+// CreateAll inserts assignments in a single transaction: either all of them are made or, when one fails
+// or is rejected with ErrSoDViolation, none is.
+func (r *userRoleRepository) CreateAll(urs []*UserRole) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, ur := range urs {
+		ur.OrgID = r.orgID
+		err = insertUserRole(tx, ur)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
+// insertUserRole inserts an assignment in its organization within tx, after checking it against the
+// separation of duties constraints. It returns ErrRoleActive if the user holds an unexpired assignment of
+// the role; an expired one not archived yet is archived first.
+func insertUserRole(tx *sql.Tx, ur *UserRole) error {
+	// Lock the user so that concurrent assignments cannot violate a constraint together
+	_, err := tx.Exec("SELECT user_id FROM users WHERE user_id = ? FOR UPDATE", ur.UserID)
+	if err != nil {
+		return err
+	}
+
+	var expiryDate sql.NullTime
+	query := "SELECT expiry_date FROM user_roles WHERE user_id = ? AND role_id = ? AND org_id = ? FOR UPDATE"
+	err = tx.QueryRow(query, ur.UserID, ur.RoleID, ur.OrgID).Scan(&expiryDate)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case !expiryDate.Valid || expiryDate.Time.After(time.Now()):
+		return ErrRoleActive
+	default:
+		err = revokeUserRole(tx, ur.UserID, ur.RoleID, ur.OrgID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkSoD(tx, ur.UserID, ur.RoleID, ur.OrgID)
+	if err != nil {
+		return err
+	}
+
+	query = "INSERT INTO user_roles (user_id, role_id, org_id, start_date, expiry_date, condition_expr, created_date, updated_date) VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())"
+	_, err = tx.Exec(query, ur.UserID, ur.RoleID, ur.OrgID, nullTime(ur.StartDate), nullTime(ur.ExpiryDate), nullString(ur.Condition))
+	return err
+}
+
+// Get returns the unexpired assignment of a role to a user, or sql.ErrNoRows when there is none. An
+// expired assignment is treated as gone even before ArchiveExpired has moved it.
 func (r *userRoleRepository) Get(userID, roleID int) (*UserRole, error) {
-	query := "SELECT user_id, role_id, expiry_date FROM user_roles WHERE user_id = ? AND role_id = ?"
-	row := r.db.QueryRow(query, userID, roleID)
+	query := "SELECT user_id, role_id, org_id, start_date, expiry_date, condition_expr FROM user_roles WHERE user_id = ? AND role_id = ? AND org_id = ? AND (expiry_date IS NULL OR expiry_date > NOW())"
+	row := r.db.QueryRow(query, userID, roleID, r.orgID)
 	userRole := &UserRole{}
-	err := row.Scan(&userRole.UserID, &userRole.RoleID, &userRole.ExpiryDate)
+	var startDate, expiryDate sql.NullTime
+	var condition sql.NullString
+	err := row.Scan(&userRole.UserID, &userRole.RoleID, &userRole.OrgID, &startDate, &expiryDate, &condition)
 	if err != nil {
 		return nil, err
 	}
+	userRole.StartDate = startDate.Time
+	userRole.ExpiryDate = expiryDate.Time
+	userRole.Condition = condition.String
 	return userRole, nil
 }
 
 func (r *userRoleRepository) Update(ur *UserRole) error {
-	query := "UPDATE user_roles SET expiry_date = ?, updated_date = NOW() WHERE user_id = ? AND role_id = ?"
-	_, err := r.db.Exec(query, ur.ExpiryDate, ur.UserID, ur.RoleID)
+	ur.OrgID = r.orgID
+	query := "UPDATE user_roles SET start_date = ?, expiry_date = ?, condition_expr = ?, expiry_warned_date = NULL, updated_date = NOW() WHERE user_id = ? AND role_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, nullTime(ur.StartDate), nullTime(ur.ExpiryDate), nullString(ur.Condition), ur.UserID, ur.RoleID, ur.OrgID)
 	if err != nil {
 		return err
 	}
@@ -67,8 +231,8 @@ func (r *userRoleRepository) Update(ur *UserRole) error {
 }
 
 func (r *userRoleRepository) Delete(userID, roleID int) error {
-	query := "DELETE FROM user_roles WHERE user_id = ? AND role_id = ?"
-	_, err := r.db.Exec(query, userID, roleID)
+	query := "DELETE FROM user_roles WHERE user_id = ? AND role_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, userID, roleID, r.orgID)
 	if err != nil {
 		return err
 	}
@@ -77,8 +241,8 @@ func (r *userRoleRepository) Delete(userID, roleID int) error {
 }
 
 func (r *userRoleRepository) GetAll() ([]*UserRole, error) {
-	query := "SELECT user_id, role_id, expiry_date FROM user_roles"
-	rows, err := r.db.Query(query)
+	query := "SELECT user_id, role_id, org_id, start_date, expiry_date FROM user_roles WHERE org_id = ?"
+	rows, err := r.db.Query(query, r.orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -89,10 +253,13 @@ func (r *userRoleRepository) GetAll() ([]*UserRole, error) {
 
 	for rows.Next() {
 		userRole := &UserRole{}
-		err := rows.Scan(&userRole.UserID, &userRole.RoleID, &userRole.ExpiryDate)
+		var startDate, expiryDate sql.NullTime
+		err := rows.Scan(&userRole.UserID, &userRole.RoleID, &userRole.OrgID, &startDate, &expiryDate)
 		if err != nil {
 			return nil, err
 		}
+		userRole.StartDate = startDate.Time
+		userRole.ExpiryDate = expiryDate.Time
 		userRoles = append(userRoles, userRole)
 	}
 
@@ -103,9 +270,10 @@ func (r *userRoleRepository) GetAll() ([]*UserRole, error) {
 	return userRoles, nil
 }
 
+// GetRolesForUser returns the roles assigned to a user directly and unconditionally.
 func (r *userRoleRepository) GetRolesForUser(userID int) ([]*Role, error) {
-	query := "SELECT r.role_id, r.role_name FROM roles r INNER JOIN user_roles ur ON r.role_id = ur.role_id WHERE ur.user_id = ?"
-	rows, err := r.db.Query(query, userID)
+	query := "SELECT DISTINCT r.role_id, r.org_id, r.role_name FROM roles r INNER JOIN user_roles ur ON r.role_id = ur.role_id WHERE ur.user_id = ? AND " + orgVisible("ur") + " AND ur.condition_expr IS NULL AND " + activeUserRole
+	rows, err := r.db.Query(query, userID, r.orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -116,7 +284,7 @@ func (r *userRoleRepository) GetRolesForUser(userID int) ([]*Role, error) {
 
 	for rows.Next() {
 		role := &Role{}
-		err := rows.Scan(&role.ID, &role.Name)
+		err := rows.Scan(&role.ID, &role.OrgID, &role.Name)
 		if err != nil {
 			return nil, err
 		}
@@ -130,16 +298,17 @@ func (r *userRoleRepository) GetRolesForUser(userID int) ([]*Role, error) {
 	return roles, nil
 }
 
+// GetAllAccess returns the accesses a user holds unconditionally. Accesses that depend on a condition
+// are only granted by the check API, which evaluates the condition.
 func (r *userRoleRepository) GetAllAccess(userID int) ([]*Access, error) {
-	query := `
-		SELECT DISTINCT access.access_id, access.access_name
-		FROM user_role
-		JOIN role ON user_role.role_id = role.role_id
-		JOIN access_role ON role.role_id = access_role.role_id
-		JOIN access ON access_role.access_id = access.access_id
-		WHERE user_role.user_id = ?
+	query := effectiveRolesCTE + `
+		SELECT DISTINCT ` + accessColumns + `
+		FROM effective_roles er
+		JOIN access_role ar ON er.role_id = ar.role_id
+		JOIN access a ON ar.access_id = a.access_id
+		WHERE ar.condition_expr IS NULL
 	`
-	rows, err := r.db.Query(query, userID)
+	rows, err := r.db.Query(query, effectiveRolesArgs(userID, r.orgID)...)
 	if err != nil {
 		return nil, err
 	}
@@ -147,8 +316,7 @@ func (r *userRoleRepository) GetAllAccess(userID int) ([]*Access, error) {
 
 	accesses := []*Access{}
 	for rows.Next() {
-		access := &Access{}
-		err := rows.Scan(&access.ID, &access.Name)
+		access, err := scanAccess(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -160,21 +328,283 @@ func (r *userRoleRepository) GetAllAccess(userID int) ([]*Access, error) {
 	}
 
 	if len(accesses) == 0 {
-		return nil, errors.New("no access found for the user")
+		return nil, ErrNoAccess
 	}
 
 	return accesses, nil
 }
 
+func (r *userRoleRepository) GetAssignmentsForUser(userID int) ([]*RoleAssignment, error) {
+	query := assignmentQuery + "WHERE ur.user_id = ? AND " + orgVisible("ur")
+	return r.queryAssignments(query, userID, r.orgID)
+}
+
+func (r *userRoleRepository) GetAssignmentsForRole(roleID int) ([]*RoleAssignment, error) {
+	query := assignmentQuery + "WHERE ur.role_id = ? AND " + orgVisible("ur")
+	return r.queryAssignments(query, roleID, r.orgID)
+}
+
+// GetHolders returns the members of the organization selected by q, ordered by ID, and the number of
+// users selected across all pages.
+func (r *userRoleRepository) GetHolders(q *HolderQuery) ([]*User, int, error) {
+	where, args := holderWhere(q, r.orgID)
+
+	var total int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT user_id, user_name, mobile, email_id FROM users" + where + " ORDER BY user_id LIMIT ? OFFSET ?"
+	rows, err := r.db.Query(query, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	page := []*User{}
+
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
+		if err != nil {
+			return nil, 0, err
+		}
+		page = append(page, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return page, total, nil
+}
+
+// GetUpcomingForUser returns the assignments of a user that have not started yet.
+func (r *userRoleRepository) GetUpcomingForUser(userID int) ([]*RoleAssignment, error) {
+	query := assignmentQuery + "WHERE ur.user_id = ? AND " + orgVisible("ur") + " AND ur.start_date > NOW() ORDER BY ur.start_date"
+	return r.queryAssignments(query, userID, r.orgID)
+}
+
+// GetUpcomingForRole returns the assignments of a role that have not started yet.
+func (r *userRoleRepository) GetUpcomingForRole(roleID int) ([]*RoleAssignment, error) {
+	query := assignmentQuery + "WHERE ur.role_id = ? AND " + orgVisible("ur") + " AND ur.start_date > NOW() ORDER BY ur.start_date"
+	return r.queryAssignments(query, roleID, r.orgID)
+}
+
+// GetExpiringAssignments returns the assignments expiring before the given time whose holders
+// have not been warned yet, across all organizations.
+func (r *userRoleRepository) GetExpiringAssignments(before time.Time) ([]*RoleAssignment, error) {
+	query := assignmentQuery + "WHERE ur.expiry_warned_date IS NULL AND ur.expiry_date > NOW() AND ur.expiry_date <= ?"
+	return r.queryAssignments(query, before)
+}
+
+// MarkExpiryWarned records that the holder of an assignment has been warned about its expiry.
+func (r *userRoleRepository) MarkExpiryWarned(a *RoleAssignment) error {
+	query := "UPDATE user_roles SET expiry_warned_date = NOW() WHERE user_id = ? AND role_id = ? AND org_id = ?"
+	_, err := r.db.Exec(query, a.UserID, a.RoleID, a.OrgID)
+	return err
+}
+
+// ArchiveExpired moves expired assignments of all organizations into user_roles_history and returns
+// how many were moved.
+func (r *userRoleRepository) ArchiveExpired() (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Fix the cut-off so that both statements see the same set of rows
+	now := time.Now()
+
+	query := `
+		INSERT INTO user_roles_history (user_id, role_id, org_id, start_date, expiry_date, assigned_date, archived_date)
+		SELECT user_id, role_id, org_id, start_date, expiry_date, created_date, NOW()
+		FROM user_roles
+		WHERE expiry_date IS NOT NULL AND expiry_date <= ?
+	`
+	_, err = tx.Exec(query, now)
+	if err != nil {
+		return 0, err
+	}
+
+	query = "DELETE FROM user_roles WHERE expiry_date IS NOT NULL AND expiry_date <= ?"
+	result, err := tx.Exec(query, now)
+	if err != nil {
+		return 0, err
+	}
+
+	archived, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return archived, tx.Commit()
+}
+
+// revokeUserRole archives an assignment to user_roles_history and removes it within tx. Revoking an
+// assignment that does not exist is a no-op.
+func revokeUserRole(tx *sql.Tx, userID, roleID, orgID int) error {
+	query := `
+		INSERT INTO user_roles_history (user_id, role_id, org_id, start_date, expiry_date, assigned_date, archived_date)
+		SELECT user_id, role_id, org_id, start_date, expiry_date, created_date, NOW()
+		FROM user_roles
+		WHERE user_id = ? AND role_id = ? AND org_id = ?
+	`
+	_, err := tx.Exec(query, userID, roleID, orgID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_roles WHERE user_id = ? AND role_id = ? AND org_id = ?", userID, roleID, orgID)
+	return err
+}
+
+func (r *userRoleRepository) queryAssignments(query string, args ...interface{}) ([]*RoleAssignment, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	assignments := []*RoleAssignment{}
+
+	for rows.Next() {
+		assignment := &RoleAssignment{}
+		var startDate, expiryDate sql.NullTime
+		var condition sql.NullString
+		err := rows.Scan(&assignment.UserID, &assignment.UserName, &assignment.RoleID, &assignment.RoleName, &assignment.OrgID, &startDate, &expiryDate, &condition)
+		if err != nil {
+			return nil, err
+		}
+		assignment.StartDate = startDate.Time
+		assignment.ExpiryDate = expiryDate.Time
+		assignment.Condition = condition.String
+		assignments = append(assignments, assignment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+// holderWhere returns the WHERE clause on users selecting the members of orgID held by q, with its
+// arguments. Exclusions are only added when there is something to exclude, since "user_id NOT IN (NULL)"
+// would select no user at all.
+func holderWhere(q *HolderQuery, orgID int) (string, []interface{}) {
+	held := func(roleIDs, groupIDs []int) (string, []interface{}) {
+		roles, roleArgs := idList(roleIDs)
+		groups, groupArgs := idList(groupIDs)
+		query := `
+			SELECT ur.user_id FROM user_roles ur WHERE ur.role_id IN ` + roles + ` AND ` + orgVisible("ur") + ` AND ur.condition_expr IS NULL AND ` + activeUserRole + `
+			UNION
+			SELECT gm.user_id FROM group_members gm WHERE gm.group_id IN ` + groups
+		args := append(append(roleArgs, orgID), groupArgs...)
+		return query, args
+	}
+
+	holders, args := held(q.RoleIDs, q.GroupIDs)
+	where := `
+		WHERE user_id IN (` + holders + `)`
+
+	if len(q.ExcludedRoleIDs) > 0 || len(q.ExcludedGroupIDs) > 0 {
+		excluded, excludedArgs := held(q.ExcludedRoleIDs, q.ExcludedGroupIDs)
+		where += `
+		AND user_id NOT IN (` + excluded + `)`
+		args = append(args, excludedArgs...)
+	}
+
+	if len(q.ExcludedUserIDs) > 0 {
+		users, userArgs := idList(q.ExcludedUserIDs)
+		where += `
+		AND user_id NOT IN ` + users
+		args = append(args, userArgs...)
+	}
+
+	where += `
+		AND ` + userInOrg
+	args = append(args, orgID, orgID)
+
+	return where, args
+}
+
+// idList returns a parenthesised list of placeholders for ids and its arguments. An empty list is
+// "(NULL)", which matches nothing with IN; it must not be used with NOT IN.
+func idList(ids []int) (string, []interface{}) {
+	if len(ids) == 0 {
+		return "(NULL)", nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "(?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
+
+// MigrateExpiryWarnings adds the expiry_warned_date column to a user_roles table that predates expiry
+// warnings. Existing assignments have not been warned yet.
+func (r *userRoleRepository) MigrateExpiryWarnings() error {
+	exists, err := columnExists(r.db, "user_roles", "expiry_warned_date")
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		_, err = r.db.Exec("ALTER TABLE user_roles ADD COLUMN expiry_warned_date DATETIME")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateStartDates adds the start_date column to the user_roles and user_roles_history tables created
+// before scheduled assignments existed. Existing assignments took effect when they were assigned.
+func (r *userRoleRepository) MigrateStartDates() error {
+	for _, table := range []string{"user_roles", "user_roles_history"} {
+		exists, err := columnExists(r.db, table, "start_date")
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			continue
+		}
+
+		_, err = r.db.Exec("ALTER TABLE " + table + " ADD COLUMN start_date DATETIME")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nullTime stores a zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
 func (r *userRoleRepository) CreateTable() error {
 	query := `
         CREATE TABLE IF NOT EXISTS user_roles (
             user_id INT NOT NULL,
             role_id INT NOT NULL,
+            org_id INT NOT NULL DEFAULT 0,
+            start_date DATETIME,
             expiry_date DATETIME,
+            expiry_warned_date DATETIME,
+            condition_expr TEXT,
 			created_date DATETIME NOT NULL DEFAULT NOW(),
 			updated_date DATETIME NOT NULL DEFAULT NOW(),
-			PRIMARY KEY (user_id, role_id),
+			PRIMARY KEY (user_id, role_id, org_id),
             FOREIGN KEY (user_id) REFERENCES users(user_id),
             FOREIGN KEY (role_id) REFERENCES roles(role_id)
         )`
@@ -183,5 +613,23 @@ func (r *userRoleRepository) CreateTable() error {
 		return err
 	}
 
+	query = `
+        CREATE TABLE IF NOT EXISTS user_roles_history (
+            history_id INT AUTO_INCREMENT PRIMARY KEY,
+            user_id INT NOT NULL,
+            role_id INT NOT NULL,
+            org_id INT NOT NULL DEFAULT 0,
+            start_date DATETIME,
+            expiry_date DATETIME,
+            assigned_date DATETIME NOT NULL,
+            archived_date DATETIME NOT NULL DEFAULT NOW(),
+            INDEX (user_id),
+            INDEX (role_id)
+        )`
+	_, err = r.db.Exec(query)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHolderWhere(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       HolderQuery
+		wantNotIn   int
+		wantArgsLen int
+	}{
+		{
+			name:        "no denies",
+			query:       HolderQuery{RoleIDs: []int{1, 2}, GroupIDs: []int{10}},
+			wantNotIn:   0,
+			wantArgsLen: 2 + 1 + 1 + 2,
+		},
+		{
+			name:        "user denies",
+			query:       HolderQuery{RoleIDs: []int{1}, ExcludedUserIDs: []int{7, 8}},
+			wantNotIn:   1,
+			wantArgsLen: 1 + 1 + 2 + 2,
+		},
+		{
+			name:        "role denies",
+			query:       HolderQuery{RoleIDs: []int{1}, ExcludedRoleIDs: []int{3}},
+			wantNotIn:   1,
+			wantArgsLen: 1 + 1 + 1 + 1 + 2,
+		},
+		{
+			name:        "all denies",
+			query:       HolderQuery{RoleIDs: []int{1}, GroupIDs: []int{10}, ExcludedUserIDs: []int{7}, ExcludedRoleIDs: []int{3}, ExcludedGroupIDs: []int{11}},
+			wantNotIn:   2,
+			wantArgsLen: (1 + 1 + 1) + (1 + 1 + 1) + 1 + 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := holderWhere(&tt.query, 5)
+
+			if got := strings.Count(where, "NOT IN"); got != tt.wantNotIn {
+				t.Errorf("holderWhere() has %d NOT IN clauses, want %d:\n%s", got, tt.wantNotIn, where)
+			}
+			if strings.Contains(where, "NOT IN (NULL)") {
+				t.Errorf("holderWhere() excludes NULL, which selects no user:\n%s", where)
+			}
+			if got := strings.Count(where, "?"); got != len(args) {
+				t.Errorf("holderWhere() has %d placeholders and %d arguments", got, len(args))
+			}
+			if len(args) != tt.wantArgsLen {
+				t.Errorf("holderWhere() has %d arguments, want %d", len(args), tt.wantArgsLen)
+			}
+		})
+	}
+}
+
+func TestIDList(t *testing.T) {
+	tests := []struct {
+		ids  []int
+		want string
+	}{
+		{nil, "(NULL)"},
+		{[]int{1}, "(?)"},
+		{[]int{1, 2, 3}, "(?, ?, ?)"},
+	}
+
+	for _, tt := range tests {
+		got, args := idList(tt.ids)
+		if got != tt.want || len(args) != len(tt.ids) {
+			t.Errorf("idList(%v) = %q, %d args, want %q, %d args", tt.ids, got, len(args), tt.want, len(tt.ids))
+		}
+	}
+}